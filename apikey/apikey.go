@@ -0,0 +1,206 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/dakeeChv/assessment/auth"
+)
+
+var (
+	ErrNoKey        = errors.New("no api key")
+	ErrInvalidKey   = errors.New("invalid api key")
+	ErrInvalidScope = errors.New("invalid scope")
+)
+
+// secretPrefix starts every secret, so a leaked key is easy to recognise, e.g. by secret scanners.
+const secretPrefix = "exk"
+
+// Scopes are the scopes an API key may be granted.
+var Scopes = []string{auth.ScopeExpensesRead, auth.ScopeExpensesWrite}
+
+// Key is an API key, its secret is only known when it is created or rotated.
+type Key struct {
+	ID      int64    `json:"id"`
+	Name    string   `json:"name"`
+	OwnerID string   `json:"owner_id"`
+	Prefix  string   `json:"prefix"`
+	Scopes  []string `json:"scopes"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Service manages API keys, only a salted hash of their secret is stored.
+type Service struct {
+	db *sql.DB
+}
+
+// NewService returns service instance.
+func NewService(_ context.Context, db *sql.DB) (*Service, error) {
+	return &Service{db: db}, nil
+}
+
+// newSecret returns a secret "exk_<prefix>_<random>" with its salted hash,
+// the prefix is stored in clear to find the key.
+func newSecret() (secret, prefix string, salt, hash []byte, err error) {
+	b := make([]byte, 6+32+16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", nil, nil, err
+	}
+	prefix = hex.EncodeToString(b[:6])
+	secret = fmt.Sprintf("%s_%s_%s", secretPrefix, prefix, base64.RawURLEncoding.EncodeToString(b[6:38]))
+	salt = b[38:]
+	return secret, prefix, salt, hashSecret(salt, secret), nil
+}
+
+func hashSecret(salt []byte, secret string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+func validScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one of %s is required", ErrInvalidScope, strings.Join(Scopes, ", "))
+	}
+	for _, s := range scopes {
+		ok := false
+		for _, allowed := range Scopes {
+			ok = ok || s == allowed
+		}
+		if !ok {
+			return fmt.Errorf("%w: %q, allowed scopes are %s", ErrInvalidScope, s, strings.Join(Scopes, ", "))
+		}
+	}
+	return nil
+}
+
+// Create makes a key acting as owner with the given scopes and returns its secret.
+func (s *Service) Create(ctx context.Context, name, owner string, scopes []string) (Key, string, error) {
+	if err := validScopes(scopes); err != nil {
+		return Key{}, "", err
+	}
+	secret, prefix, salt, hash, err := newSecret()
+	if err != nil {
+		return Key{}, "", fmt.Errorf("Create(): generate secret: %w", err)
+	}
+
+	query := `INSERT INTO api_keys(name, owner_id, prefix, salt, hash, scopes) VALUES($1, $2, $3, $4, $5, $6) RETURNING id, name, owner_id, prefix, scopes, created_at, last_used_at, revoked_at`
+
+	var out Key
+	err = s.db.QueryRowContext(ctx, query, name, owner, prefix, salt, hash, pq.Array(scopes)).Scan(&out.ID, &out.Name, &out.OwnerID, &out.Prefix, pq.Array(&out.Scopes), &out.CreatedAt, &out.LastUsedAt, &out.RevokedAt)
+	if err != nil {
+		return Key{}, "", fmt.Errorf("Create(): db scan row: %w", err)
+	}
+
+	return out, secret, nil
+}
+
+// List returns every key, newest first.
+func (s *Service) List(ctx context.Context) ([]Key, error) {
+	query := `SELECT id, name, owner_id, prefix, scopes, created_at, last_used_at, revoked_at from api_keys ORDER BY id DESC`
+
+	out := make([]Key, 0)
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return []Key{}, fmt.Errorf("List(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k Key
+		if err := rows.Scan(&k.ID, &k.Name, &k.OwnerID, &k.Prefix, pq.Array(&k.Scopes), &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			return []Key{}, fmt.Errorf("List(): db scan row: %w", err)
+		}
+		out = append(out, k)
+	}
+	if err := rows.Err(); err != nil {
+		return []Key{}, fmt.Errorf("List(): db rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// Rotate replaces the secret of a key which is not revoked, the old secret stops working at once.
+func (s *Service) Rotate(ctx context.Context, id int64) (Key, string, error) {
+	secret, prefix, salt, hash, err := newSecret()
+	if err != nil {
+		return Key{}, "", fmt.Errorf("Rotate(): generate secret: %w", err)
+	}
+
+	query := `UPDATE api_keys SET prefix=$1, salt=$2, hash=$3, last_used_at=NULL WHERE id=$4 AND revoked_at IS NULL RETURNING id, name, owner_id, prefix, scopes, created_at, last_used_at, revoked_at`
+
+	var out Key
+	err = s.db.QueryRowContext(ctx, query, prefix, salt, hash, id).Scan(&out.ID, &out.Name, &out.OwnerID, &out.Prefix, pq.Array(&out.Scopes), &out.CreatedAt, &out.LastUsedAt, &out.RevokedAt)
+	if err == sql.ErrNoRows {
+		return Key{}, "", ErrNoKey
+	}
+	if err != nil {
+		return Key{}, "", fmt.Errorf("Rotate(): db scan row: %w", err)
+	}
+
+	return out, secret, nil
+}
+
+// Revoke disables a key for good.
+func (s *Service) Revoke(ctx context.Context, id int64) error {
+	query := `UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL`
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("Revoke(): db exec context: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Revoke(): db rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrNoKey
+	}
+
+	return nil
+}
+
+// Authenticate returns the principal of a secret and records when the key was last used.
+func (s *Service) Authenticate(ctx context.Context, secret string) (auth.Principal, error) {
+	parts := strings.SplitN(secret, "_", 3)
+	if len(parts) != 3 || parts[0] != secretPrefix {
+		return auth.Principal{}, ErrInvalidKey
+	}
+
+	var k Key
+	var salt, hash []byte
+	query := `SELECT id, owner_id, scopes, salt, hash from api_keys where prefix=$1 AND revoked_at IS NULL`
+	err := s.db.QueryRowContext(ctx, query, parts[1]).Scan(&k.ID, &k.OwnerID, pq.Array(&k.Scopes), &salt, &hash)
+	if err == sql.ErrNoRows {
+		return auth.Principal{}, ErrInvalidKey
+	}
+	if err != nil {
+		return auth.Principal{}, fmt.Errorf("Authenticate(): db scan row: %w", err)
+	}
+	if subtle.ConstantTimeCompare(hashSecret(salt, secret), hash) != 1 {
+		return auth.Principal{}, ErrInvalidKey
+	}
+
+	// last_used_at is only written once a minute, not on every request.
+	query = `UPDATE api_keys SET last_used_at=now() WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+	if _, err := s.db.ExecContext(ctx, query, k.ID); err != nil {
+		return auth.Principal{}, fmt.Errorf("Authenticate(): db exec context: %w", err)
+	}
+
+	return auth.Principal{ID: k.OwnerID, Scopes: k.Scopes, KeyID: k.ID}, nil
+}
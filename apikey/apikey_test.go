@@ -0,0 +1,152 @@
+package apikey_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/apikey"
+	"github.com/dakeeChv/assessment/auth"
+)
+
+// capture matches any argument and keeps it.
+type capture struct{ v driver.Value }
+
+func (c *capture) Match(v driver.Value) bool {
+	c.v = v
+	return true
+}
+
+var createdAt = time.Date(2022, time.November, 10, 9, 30, 0, 0, time.UTC)
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	scopes := []string{auth.ScopeExpensesRead}
+	prefix, salt, hash := &capture{}, &capture{}, &capture{}
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO api_keys(name, owner_id, prefix, salt, hash, scopes) VALUES($1, $2, $3, $4, $5, $6) RETURNING id, name, owner_id, prefix, scopes, created_at, last_used_at, revoked_at`)).
+		WithArgs("pos", "alice", prefix, salt, hash, pq.Array(scopes)).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "name", "owner_id", "prefix", "scopes", "created_at", "last_used_at", "revoked_at"}).
+				AddRow(1, "pos", "alice", "0a1b2c3d4e5f", pq.Array(scopes), createdAt, nil, nil),
+		)
+
+	ctx := context.Background()
+	keys, _ := apikey.NewService(ctx, db)
+
+	key, secret, err := keys.Create(ctx, "pos", "alice", scopes)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), key.ID)
+	assert.True(t, strings.HasPrefix(secret, "exk_"+prefix.v.(string)+"_"))
+	assert.NotContains(t, string(hash.v.([]byte)), secret)
+
+	t.Run("Authenticate", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, owner_id, scopes, salt, hash from api_keys where prefix=$1 AND revoked_at IS NULL`)).
+			WithArgs(prefix.v).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "scopes", "salt", "hash"}).AddRow(1, "alice", pq.Array(scopes), salt.v, hash.v))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE api_keys SET last_used_at=now() WHERE id=$1`)).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		got, err := keys.Authenticate(ctx, secret)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", got.ID)
+		assert.Equal(t, int64(1), got.KeyID)
+		assert.True(t, got.HasScope(auth.ScopeExpensesRead))
+		assert.False(t, got.HasScope(auth.ScopeExpensesWrite))
+	})
+
+	t.Run("Wrong secret", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, owner_id, scopes, salt, hash from api_keys`)).
+			WithArgs(prefix.v).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "scopes", "salt", "hash"}).AddRow(1, "alice", pq.Array(scopes), salt.v, hash.v))
+
+		_, err := keys.Authenticate(ctx, secret[:len(secret)-1]+"x")
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+	})
+
+	t.Run("Not a key", func(t *testing.T) {
+		_, err := keys.Authenticate(ctx, "November 10, 2009")
+
+		assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+	})
+}
+
+func TestCreateInvalidScope(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	keys, _ := apikey.NewService(ctx, db)
+
+	_, _, err = keys.Create(ctx, "pos", "alice", []string{"users:manage"})
+
+	assert.ErrorIs(t, err, apikey.ErrInvalidScope)
+}
+
+func TestRevoke(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL`)).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		ctx := context.Background()
+		keys, _ := apikey.NewService(ctx, db)
+
+		err := keys.Revoke(ctx, 1)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("Error no row", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL`)).
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		ctx := context.Background()
+		keys, _ := apikey.NewService(ctx, db)
+
+		err := keys.Revoke(ctx, 2)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, apikey.ErrNoKey)
+	})
+}
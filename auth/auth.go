@@ -0,0 +1,46 @@
+package auth
+
+import "context"
+
+// Scopes narrow down what the credentials of a principal may do.
+const (
+	ScopeExpensesRead  = "expenses:read"
+	ScopeExpensesWrite = "expenses:write"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// ID identifies the user, it is the owner_id of the expenses the user creates.
+	ID string
+	// Role names the permissions of the user, see package policy.
+	Role string
+	// Scopes restrict the credentials used to part of the role, nil means no restriction.
+	Scopes []string
+	// Claims are the verified claims of the bearer token, nil for other schemes.
+	Claims map[string]interface{}
+	// KeyID is the id of the API key used, zero for other schemes.
+	KeyID int64
+}
+
+// HasScope reports whether p was granted scope.
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, if any.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok && p.ID != ""
+}
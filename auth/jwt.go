@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrInvalidKey   = errors.New("invalid key")
+)
+
+// Leeway is the clock skew tolerated when checking exp and nbf.
+const Leeway = time.Minute
+
+// Key verifies the signature of tokens, its Value decides the algorithm:
+// []byte for HS256, *rsa.PublicKey for RS256 and *ecdsa.PublicKey on P-256 for ES256.
+type Key struct {
+	// ID matches the kid header of a token, a key without ID is tried on every token.
+	ID    string
+	Value interface{}
+}
+
+// HMACKey returns a shared secret key for HS256.
+func HMACKey(secret []byte) Key {
+	return Key{Value: secret}
+}
+
+// ParsePublicKeyPEM reads an RSA or P-256 ECDSA public key from PEM.
+func ParsePublicKeyPEM(b []byte) (Key, error) {
+	if k, err := jwt.ParseRSAPublicKeyFromPEM(b); err == nil {
+		return Key{Value: k}, nil
+	}
+	k, err := jwt.ParseECPublicKeyFromPEM(b)
+	if err != nil {
+		return Key{}, fmt.Errorf("%w: neither an RSA nor an ECDSA public key", ErrInvalidKey)
+	}
+	if k.Curve != elliptic.P256() {
+		return Key{}, fmt.Errorf("%w: ECDSA key must be on P-256", ErrInvalidKey)
+	}
+	return Key{Value: k}, nil
+}
+
+// ParseJWKS reads the RSA and P-256 EC keys of a JSON Web Key Set (RFC 7517), other keys are skipped.
+func ParseJWKS(r io.Reader) ([]Key, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	var out []Key
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := decodeInt(k.N)
+			e, err2 := decodeInt(k.E)
+			if err1 != nil || err2 != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("%w: key %d: malformed RSA modulus or exponent", ErrInvalidKey, i)
+			}
+			out = append(out, Key{ID: k.Kid, Value: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := decodeInt(k.X)
+			y, err2 := decodeInt(k.Y)
+			if err1 != nil || err2 != nil || !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("%w: key %d: malformed EC point", ErrInvalidKey, i)
+			}
+			out = append(out, Key{ID: k.Kid, Value: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}})
+		}
+	}
+	return out, nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("malformed integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// alg is the only algorithm a key is accepted for, so an RSA public key is never used as an HMAC secret.
+func (k Key) alg() string {
+	switch v := k.Value.(type) {
+	case []byte:
+		return "HS256"
+	case *rsa.PublicKey:
+		return "RS256"
+	case *ecdsa.PublicKey:
+		if v.Curve == elliptic.P256() {
+			return "ES256"
+		}
+	}
+	return ""
+}
+
+// Verifier checks JWT bearer tokens.
+type Verifier struct {
+	keys     []Key
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// VerifierOption configures a Verifier.
+type VerifierOption func(*Verifier)
+
+// WithIssuer requires the iss claim to be issuer.
+func WithIssuer(issuer string) VerifierOption {
+	return func(v *Verifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience requires the aud claim to contain audience.
+func WithAudience(audience string) VerifierOption {
+	return func(v *Verifier) {
+		v.audience = audience
+	}
+}
+
+// WithClock sets the time tokens are checked against, time.Now otherwise.
+func WithClock(now func() time.Time) VerifierOption {
+	return func(v *Verifier) {
+		v.now = now
+	}
+}
+
+// NewVerifier returns a verifier accepting tokens signed by one of keys.
+func NewVerifier(keys []Key, opts ...VerifierOption) (*Verifier, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no key configured", ErrInvalidKey)
+	}
+	for i, k := range keys {
+		if k.alg() == "" {
+			return nil, fmt.Errorf("%w: key %d: unsupported type %T", ErrInvalidKey, i, k.Value)
+		}
+	}
+
+	v := &Verifier{keys: keys, now: time.Now}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v, nil
+}
+
+// Verify checks the signature, exp, nbf, iss and aud of a token and returns its subject as the principal.
+// The role claim names the role of the user and the space separated scope claim (RFC 8693) restricts it.
+func (v *Verifier) Verify(token string) (Principal, error) {
+	parser := jwt.Parser{ValidMethods: []string{"HS256", "RS256", "ES256"}, SkipClaimsValidation: true}
+
+	var claims jwt.MapClaims
+	var err error
+	for _, k := range v.keys {
+		k := k
+		claims = jwt.MapClaims{}
+		_, perr := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+			if kid, _ := t.Header["kid"].(string); kid != "" && k.ID != "" && kid != k.ID {
+				return nil, errors.New("kid does not match")
+			}
+			if t.Method.Alg() != k.alg() {
+				return nil, errors.New("alg does not match")
+			}
+			return k.Value, nil
+		})
+		if perr == nil {
+			err = nil
+			break
+		}
+		err = fmt.Errorf("%w: %v", ErrInvalidToken, perr)
+		var verr *jwt.ValidationError
+		if errors.As(perr, &verr) && verr.Errors&jwt.ValidationErrorMalformed != 0 {
+			return Principal{}, err
+		}
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	now := v.now()
+	if !claims.VerifyExpiresAt(now.Add(-Leeway).Unix(), true) {
+		return Principal{}, fmt.Errorf("%w: token is expired or has no exp", ErrInvalidToken)
+	}
+	if !claims.VerifyNotBefore(now.Add(Leeway).Unix(), false) {
+		return Principal{}, fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return Principal{}, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return Principal{}, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return Principal{}, fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+	p := Principal{ID: sub, Claims: claims}
+	p.Role, _ = claims["role"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	}
+	return p, nil
+}
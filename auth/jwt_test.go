@@ -0,0 +1,159 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+)
+
+var (
+	secret = []byte("a very secret test key")
+	now    = time.Date(2022, time.November, 10, 9, 30, 0, 0, time.UTC)
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return s
+}
+
+func TestVerifyHS256(t *testing.T) {
+	v, err := auth.NewVerifier([]auth.Key{auth.HMACKey(secret)},
+		auth.WithIssuer("https://id.example.com"), auth.WithAudience("expenses"), auth.WithClock(func() time.Time { return now }))
+	assert.NoError(t, err)
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "alice",
+			"iss": "https://id.example.com",
+			"aud": []string{"expenses", "reports"},
+			"exp": now.Add(time.Hour).Unix(),
+			"nbf": now.Add(-time.Hour).Unix(),
+		}
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		got, err := v.Verify(sign(t, jwt.SigningMethodHS256, secret, "", claims()))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", got.ID)
+		assert.Equal(t, "https://id.example.com", got.Claims["iss"])
+		assert.Equal(t, "", got.Role)
+		assert.Nil(t, got.Scopes)
+	})
+
+	t.Run("Role and scope claims", func(t *testing.T) {
+		c := claims()
+		c["role"] = "admin"
+		c["scope"] = "expenses:read users:manage"
+
+		got, err := v.Verify(sign(t, jwt.SigningMethodHS256, secret, "", c))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "admin", got.Role)
+		assert.True(t, got.HasScope(auth.ScopeExpensesRead))
+		assert.False(t, got.HasScope(auth.ScopeExpensesWrite))
+	})
+
+	tests := []struct {
+		name   string
+		change func(jwt.MapClaims)
+	}{
+		{"Expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() }},
+		{"No exp", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"Not yet valid", func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Hour).Unix() }},
+		{"Other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"Other audience", func(c jwt.MapClaims) { c["aud"] = "reports" }},
+		{"No subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := claims()
+			tt.change(c)
+
+			_, err := v.Verify(sign(t, jwt.SigningMethodHS256, secret, "", c))
+
+			assert.ErrorIs(t, err, auth.ErrInvalidToken)
+		})
+	}
+
+	t.Run("Wrong secret", func(t *testing.T) {
+		_, err := v.Verify(sign(t, jwt.SigningMethodHS256, []byte("another key"), "", claims()))
+
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run("Alg none", func(t *testing.T) {
+		_, err := v.Verify(sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims()))
+
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := v.Verify("not.a.token")
+
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+}
+
+func TestVerifyES256JWKS(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "oct", "kid": "skipped", "k": "c2VjcmV0"},
+		{"kty": "EC", "kid": "2022-11", "use": "sig", "crv": "P-256", "x": %q, "y": %q}
+	]}`, b64(priv.X.FillBytes(make([]byte, 32))), b64(priv.Y.FillBytes(make([]byte, 32))))
+
+	keys, err := auth.ParseJWKS(strings.NewReader(jwks))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(keys))
+
+	v, err := auth.NewVerifier(keys, auth.WithClock(func() time.Time { return now }))
+	assert.NoError(t, err)
+	claims := jwt.MapClaims{"sub": "bob", "exp": now.Add(time.Hour).Unix()}
+
+	t.Run("Success", func(t *testing.T) {
+		got, err := v.Verify(sign(t, jwt.SigningMethodES256, priv, "2022-11", claims))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "bob", got.ID)
+	})
+
+	t.Run("Unknown kid", func(t *testing.T) {
+		_, err := v.Verify(sign(t, jwt.SigningMethodES256, priv, "2021-01", claims))
+
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run("HS256 with the public key", func(t *testing.T) {
+		_, err := v.Verify(sign(t, jwt.SigningMethodHS256, []byte(jwks), "2022-11", claims))
+
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+}
+
+func TestNewVerifier(t *testing.T) {
+	_, err := auth.NewVerifier(nil)
+
+	assert.ErrorIs(t, err, auth.ErrInvalidKey)
+}
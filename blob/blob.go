@@ -0,0 +1,33 @@
+// Package blob stores files by the SHA-256 of their content, so identical files are stored once.
+package blob
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidSum = errors.New("invalid blob sum")
+)
+
+// Store keeps contents addressed by the hex SHA-256 of their bytes, which Put returns.
+type Store interface {
+	// Put stores the content read from r and returns its sum, a content stored before is left as it is.
+	Put(ctx context.Context, r io.Reader) (string, error)
+	// Open reads the content of sum, ErrNotFound when it is not stored.
+	Open(ctx context.Context, sum string) (io.ReadSeekCloser, error)
+	// Delete removes the content of sum, removing a content which is not stored is not an error.
+	Delete(ctx context.Context, sum string) error
+}
+
+// ValidSum reports whether sum is a lower case hex SHA-256 as returned by Put.
+func ValidSum(sum string) bool {
+	if len(sum) != 64 {
+		return false
+	}
+	b, err := hex.DecodeString(sum)
+	return err == nil && hex.EncodeToString(b) == sum
+}
@@ -0,0 +1,97 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Disk is the Store of the files of a directory, each content is the file named by its sum
+// in the subdirectory of the first two characters of the sum, e.g. 9f/9f86d0....
+type Disk struct {
+	dir string
+}
+
+// NewDisk returns the store of dir, which is created when missing.
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("NewDisk(): %w", err)
+	}
+	return &Disk{dir: dir}, nil
+}
+
+func (d *Disk) path(sum string) string {
+	return filepath.Join(d.dir, sum[:2], sum)
+}
+
+// Put writes r to a temporary file while hashing it, then renames the file to its sum,
+// so a content is never seen partly written.
+func (d *Disk) Put(ctx context.Context, r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(d.dir, ".put-*")
+	if err != nil {
+		return "", fmt.Errorf("Put(): %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("Put(): %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	name := d.path(sum)
+	if _, err := os.Stat(name); err == nil {
+		return sum, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+		return "", fmt.Errorf("Put(): %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o640); err != nil {
+		return "", fmt.Errorf("Put(): %w", err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return "", fmt.Errorf("Put(): %w", err)
+	}
+	return sum, nil
+}
+
+func (d *Disk) Open(_ context.Context, sum string) (io.ReadSeekCloser, error) {
+	if !ValidSum(sum) {
+		return nil, ErrInvalidSum
+	}
+	f, err := os.Open(d.path(sum))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Open(): %w", err)
+	}
+	return f, nil
+}
+
+func (d *Disk) Delete(_ context.Context, sum string) error {
+	if !ValidSum(sum) {
+		return ErrInvalidSum
+	}
+	err := os.Remove(d.path(sum))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Delete(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package blob_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/blob"
+)
+
+// receiptSum is the SHA-256 of "receipt".
+const receiptSum = "6f32860910ca0fb2a20c7fda143666b09dbf8db5238195c90a586fb542ff0cad"
+
+func TestDisk(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := blob.NewDisk(dir)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the store", err)
+	}
+
+	t.Run("Identical contents are stored once", func(t *testing.T) {
+		a, err := store.Put(ctx, strings.NewReader("receipt"))
+		assert.NoError(t, err)
+		b, err := store.Put(ctx, strings.NewReader("receipt"))
+		assert.NoError(t, err)
+
+		assert.Equal(t, receiptSum, a)
+		assert.Equal(t, a, b)
+		files, _ := os.ReadDir(filepath.Join(dir, a[:2]))
+		assert.Len(t, files, 1)
+		// the temporary files are gone.
+		top, _ := os.ReadDir(dir)
+		assert.Len(t, top, 1)
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		sum, _ := store.Put(ctx, strings.NewReader("receipt"))
+
+		f, err := store.Open(ctx, sum)
+		if assert.NoError(t, err) {
+			defer f.Close()
+			f.Seek(3, io.SeekStart)
+			b, _ := io.ReadAll(f)
+			assert.Equal(t, "eipt", string(b))
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		sum, _ := store.Put(ctx, strings.NewReader("receipt"))
+
+		assert.NoError(t, store.Delete(ctx, sum))
+		assert.NoError(t, store.Delete(ctx, sum))
+		_, err := store.Open(ctx, sum)
+		assert.ErrorIs(t, err, blob.ErrNotFound)
+	})
+
+	t.Run("Invalid sum", func(t *testing.T) {
+		_, err := store.Open(ctx, "../../etc/passwd")
+		assert.ErrorIs(t, err, blob.ErrInvalidSum)
+		assert.ErrorIs(t, store.Delete(ctx, strings.ToUpper(receiptSum)), blob.ErrInvalidSum)
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+	"github.com/dakeeChv/assessment/statement"
+)
+
+const usage = `usage:
+  go-app                          start the api server
+  go-app import-rates <file>...   load exchange rates from CSV (date,base,quote,rate) or ECB XML files
+  go-app import-statement [-owner id] [-currency THB] [-account name] [-day-first] <file>...
+                                  create the expenses of OFX or QIF bank statements, skipping those imported before`
+
+// command runs a subcommand given on the command line instead of the api server.
+func command(args []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	switch args[0] {
+	case "import-rates":
+		if len(args) < 2 {
+			return fmt.Errorf("missing file\n%s", usage)
+		}
+		return importRates(ctx, args[1:])
+	case "import-statement":
+		return importStatements(ctx, args[1:])
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	}
+	return fmt.Errorf("unknown command\n%s", usage)
+}
+
+func importRates(ctx context.Context, files []string) error {
+	var rates []expn.Rate
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		parse := expn.ParseRatesCSV
+		if strings.EqualFold(filepath.Ext(name), ".xml") {
+			parse = expn.ParseRatesECB
+		}
+		r, err := parse(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		rates = append(rates, r...)
+	}
+
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	expense, err := expn.NewService(ctx, db)
+	if err != nil {
+		return err
+	}
+	n, err := expense.ImportRates(ctx, rates)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d exchange rates\n", n)
+	return nil
+}
+
+func importStatements(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import-statement", flag.ContinueOnError)
+	owner := fs.String("owner", OWNER, "the user owning the expenses")
+	var opts statement.Options
+	fs.StringVar(&opts.Currency, "currency", expn.DefaultCurrency, "the currency of QIF files and OFX files without one")
+	fs.StringVar(&opts.Account, "account", "", "the account of QIF files without an !Account header")
+	fs.BoolVar(&opts.DayFirst, "day-first", false, "read QIF dates as day/month/year")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("missing file\n%s", usage)
+	}
+
+	var lines []expn.StatementLine
+	credits := 0
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		txns, err := statement.Parse(f, name, opts)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		l, c := statement.Lines(txns)
+		lines = append(lines, l...)
+		credits += c
+	}
+
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx = auth.NewContext(ctx, auth.Principal{ID: *owner})
+	expense, err := expn.NewService(ctx, db)
+	if err != nil {
+		return err
+	}
+	out, err := expense.ImportStatement(ctx, lines)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d expenses, skipped %d imported before and %d credits\n", out.Imported, out.Duplicates, credits)
+	return nil
+}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS expenses_deleted_at_idx;
+
+ALTER TABLE expenses DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,3 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS expenses_deleted_at_idx ON expenses (deleted_at) WHERE deleted_at IS NOT NULL;
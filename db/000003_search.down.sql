@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS expenses_search_idx;
+
+ALTER TABLE expenses DROP COLUMN IF EXISTS search;
@@ -0,0 +1,6 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+  setweight(to_tsvector('simple', coalesce(note, '')), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS expenses_search_idx ON expenses USING GIN (search);
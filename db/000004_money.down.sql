@@ -0,0 +1,8 @@
+DO $$
+BEGIN
+  IF (SELECT data_type FROM information_schema.columns WHERE table_name = 'expenses' AND column_name = 'amount') = 'bigint' THEN
+    ALTER TABLE expenses ALTER COLUMN amount TYPE FLOAT USING amount / 100.0;
+  END IF;
+END $$;
+
+ALTER TABLE expenses DROP COLUMN IF EXISTS currency;
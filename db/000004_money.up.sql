@@ -0,0 +1,9 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'THB';
+
+-- amount holds integer minor units of currency, existing rows are THB so 1 bath is 100 satang.
+DO $$
+BEGIN
+  IF (SELECT data_type FROM information_schema.columns WHERE table_name = 'expenses' AND column_name = 'amount') = 'double precision' THEN
+    ALTER TABLE expenses ALTER COLUMN amount TYPE BIGINT USING round(amount * 100)::BIGINT;
+  END IF;
+END $$;
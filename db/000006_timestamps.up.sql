@@ -0,0 +1,4 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS spent_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS expenses_spent_at_idx ON expenses (spent_at);
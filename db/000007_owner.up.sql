@@ -0,0 +1,9 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS owner_id TEXT;
+
+-- expenses created before ownership belong to the default owner,
+-- set it with e.g. PGOPTIONS='-c expenses.default_owner=alice'.
+UPDATE expenses SET owner_id = COALESCE(NULLIF(current_setting('expenses.default_owner', true), ''), 'default')
+  WHERE owner_id IS NULL;
+
+ALTER TABLE expenses ALTER COLUMN owner_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS expenses_owner_id_idx ON expenses (owner_id);
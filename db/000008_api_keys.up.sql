@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL DEFAULT '',
+  owner_id TEXT NOT NULL,
+  prefix TEXT NOT NULL UNIQUE,
+  salt BYTEA NOT NULL,
+  hash BYTEA NOT NULL,
+  scopes TEXT[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS budgets (
+  id SERIAL PRIMARY KEY,
+  owner_id TEXT NOT NULL,
+  tag TEXT NOT NULL,
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  currency CHAR(3) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (owner_id, tag)
+);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS recurring_expenses (
+  id SERIAL PRIMARY KEY,
+  owner_id TEXT NOT NULL,
+  title TEXT,
+  amount BIGINT NOT NULL,
+  currency CHAR(3) NOT NULL,
+  note TEXT,
+  tags TEXT[],
+  rule TEXT NOT NULL,
+  starts_at TIMESTAMPTZ NOT NULL,
+  time_zone TEXT NOT NULL DEFAULT 'UTC',
+  next_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS recurring_expenses_next_at_idx ON recurring_expenses (next_at) WHERE next_at IS NOT NULL;
+CREATE TABLE IF NOT EXISTS recurring_occurrences (
+  recurring_id INT NOT NULL REFERENCES recurring_expenses (id) ON DELETE CASCADE,
+  occurs_at TIMESTAMPTZ NOT NULL,
+  expense_id INT REFERENCES expenses (id) ON DELETE SET NULL,
+  skipped BOOLEAN NOT NULL DEFAULT false,
+  PRIMARY KEY (recurring_id, occurs_at)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS statement_transactions (
+  owner_id TEXT NOT NULL,
+  account TEXT NOT NULL,
+  fitid TEXT NOT NULL,
+  expense_id INT REFERENCES expenses (id) ON DELETE SET NULL,
+  imported_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (owner_id, account, fitid)
+);
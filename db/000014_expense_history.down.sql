@@ -0,0 +1 @@
+DROP TABLE IF EXISTS expense_history;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS expense_history (
+  expense_id INT NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+  revision BIGINT NOT NULL,
+  actor TEXT NOT NULL,
+  action TEXT NOT NULL,
+  changes JSONB NOT NULL,
+  snapshot JSONB NOT NULL,
+  reverted_to BIGINT,
+  changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (expense_id, revision)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS attachments (
+  id SERIAL PRIMARY KEY,
+  expense_id INT NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  content_type TEXT NOT NULL,
+  size BIGINT NOT NULL,
+  sha256 TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS attachments_expense_id_idx ON attachments (expense_id);
+CREATE INDEX IF NOT EXISTS attachments_sha256_idx ON attachments (sha256);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS expense_tags;
+DROP TABLE IF EXISTS tags;
+DROP FUNCTION IF EXISTS canonical_tag(TEXT);
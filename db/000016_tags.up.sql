@@ -0,0 +1,35 @@
+CREATE OR REPLACE FUNCTION canonical_tag(tag TEXT) RETURNS TEXT AS $$
+  SELECT lower(btrim(regexp_replace(tag, '\s+', ' ', 'g')))
+$$ LANGUAGE SQL IMMUTABLE;
+CREATE TABLE IF NOT EXISTS tags (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS expense_tags (
+  expense_id INT NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+  tag_id INT NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+  PRIMARY KEY (expense_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS expense_tags_tag_id_idx ON expense_tags (tag_id);
+-- the tags arrays stay as a copy of expense_tags for the list filters and summaries.
+-- Once, they are canonicalised and linked, of the budgets of a user on tags differing only by case the oldest is kept.
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM expense_tags) THEN
+    UPDATE expenses SET tags = ARRAY(
+      SELECT canonical_tag(u.tag) FROM unnest(tags) WITH ORDINALITY u(tag, ord)
+      WHERE canonical_tag(u.tag) <> '' GROUP BY 1 ORDER BY min(u.ord)
+    ) WHERE cardinality(tags) > 0;
+    UPDATE recurring_expenses SET tags = ARRAY(
+      SELECT canonical_tag(u.tag) FROM unnest(tags) WITH ORDINALITY u(tag, ord)
+      WHERE canonical_tag(u.tag) <> '' GROUP BY 1 ORDER BY min(u.ord)
+    ) WHERE cardinality(tags) > 0;
+    DELETE FROM budgets b WHERE EXISTS (
+      SELECT 1 FROM budgets o WHERE o.owner_id = b.owner_id AND o.id < b.id AND canonical_tag(o.tag) = canonical_tag(b.tag)
+    );
+    UPDATE budgets SET tag = canonical_tag(tag) WHERE tag <> canonical_tag(tag);
+    INSERT INTO tags(name) SELECT DISTINCT unnest(tags) FROM expenses ON CONFLICT DO NOTHING;
+    INSERT INTO expense_tags(expense_id, tag_id) SELECT e.id, t.id FROM expenses e JOIN tags t ON t.name = ANY(e.tags) ON CONFLICT DO NOTHING;
+  END IF;
+END $$;
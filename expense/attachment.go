@@ -0,0 +1,297 @@
+package expense
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dakeeChv/assessment/blob"
+)
+
+var (
+	ErrNoAttachment          = errors.New("no attachment")
+	ErrInvalidAttachment     = errors.New("invalid attachment")
+	ErrUnsupportedAttachment = errors.New("unsupported attachment type")
+	ErrAttachmentTooLarge    = errors.New("attachment too large")
+	ErrNoBlobStore           = errors.New("no blob store configured")
+)
+
+// MaxAttachmentSize is the largest file which may be attached to an expense.
+const MaxAttachmentSize = 10 << 20
+
+// AttachmentTypes are the content types an attachment may have, as sniffed from its first bytes
+// rather than the type claimed by the client.
+var AttachmentTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf"}
+
+// Attachment is a file kept with an expense, such as the photo of its receipt.
+// Its content is in the blob store under SHA256.
+type Attachment struct {
+	ID          int64     `json:"id"`
+	ExpenseID   int64     `json:"expense_id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WithBlobStore keeps the content of the attachments in store, without it attachments are refused.
+func WithBlobStore(store blob.Store) Option {
+	return func(s *Service) {
+		s.blobs = store
+	}
+}
+
+// attachmentName is the base name of a client's file name, "receipt" when it has none.
+func attachmentName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "receipt"
+	}
+	return name
+}
+
+// sniff returns the content type of the first bytes of r and the sum and size of all of them,
+// leaving r at its start.
+func sniff(r io.ReadSeeker) (contentType, sum string, size int64, err error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", "", 0, err
+	}
+	contentType, _, _ = mime.ParseMediaType(http.DetectContentType(head[:n]))
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", "", 0, err
+	}
+	h := sha256.New()
+	size, err = io.Copy(h, io.LimitReader(r, MaxAttachmentSize+1))
+	if err != nil {
+		return "", "", 0, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", "", 0, err
+	}
+	return contentType, hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// lockBlob serialises the writes of the attachments of a content, so one is never deleted
+// from the blob store while another attachment of it is being added.
+func lockBlob(ctx context.Context, tx *sql.Tx, sum string) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, sum); err != nil {
+		return fmt.Errorf("lockBlob(): db exec: %w", err)
+	}
+	return nil
+}
+
+// Attach keeps the file read from r with an expense of the caller. The file is identified by the sniffed
+// content type of its first bytes, which must be one of AttachmentTypes, and may be at most MaxAttachmentSize.
+func (s *Service) Attach(ctx context.Context, expenseID int64, name string, r io.ReadSeeker) (Attachment, error) {
+	if s.blobs == nil {
+		return Attachment{}, ErrNoBlobStore
+	}
+	uid, err := scope(ctx)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	contentType, sum, size, err := sniff(r)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("Attach(): read: %w", err)
+	}
+	if size == 0 {
+		return Attachment{}, fmt.Errorf("%w: the file is empty", ErrInvalidAttachment)
+	}
+	if size > MaxAttachmentSize {
+		return Attachment{}, fmt.Errorf("%w: the file is larger than %d bytes", ErrAttachmentTooLarge, MaxAttachmentSize)
+	}
+	supported := false
+	for _, t := range AttachmentTypes {
+		supported = supported || t == contentType
+	}
+	if !supported {
+		return Attachment{}, fmt.Errorf("%w: %s, the file must be one of %s", ErrUnsupportedAttachment, contentType, strings.Join(AttachmentTypes, ", "))
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("Attach(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL FOR SHARE`, expenseID, uid).Scan(&id)
+	if err == sql.ErrNoRows {
+		return Attachment{}, ErrNoExpense
+	}
+	if err != nil {
+		return Attachment{}, fmt.Errorf("Attach(): db scan row: %w", err)
+	}
+	if err := lockBlob(ctx, tx, sum); err != nil {
+		return Attachment{}, err
+	}
+
+	stored, err := s.blobs.Put(ctx, io.LimitReader(r, MaxAttachmentSize))
+	if err != nil {
+		return Attachment{}, fmt.Errorf("Attach(): blob put: %w", err)
+	}
+	// a failure from here on leaves the content in the blob store without the attachment,
+	// it is collected once the transaction no longer holds the lock of the content.
+	fail := func(err error) (Attachment, error) {
+		tx.Rollback()
+		if err := s.collect(ctx, stored); err != nil {
+			log.Printf("Attach(): failed to collect blob %s: %v", stored, err)
+		}
+		return Attachment{}, err
+	}
+	if stored != sum {
+		return fail(fmt.Errorf("Attach(): the file changed while it was stored"))
+	}
+
+	out := Attachment{ExpenseID: expenseID, Name: attachmentName(name), ContentType: contentType, Size: size, SHA256: sum}
+	query := `INSERT INTO attachments(expense_id, name, content_type, size, sha256) VALUES($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, query, out.ExpenseID, out.Name, out.ContentType, out.Size, out.SHA256).Scan(&out.ID, &out.CreatedAt)
+	if err != nil {
+		return fail(fmt.Errorf("Attach(): db scan row: %w", err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fail(fmt.Errorf("Attach(): db commit: %w", err))
+	}
+	return out, nil
+}
+
+// Attachments lists the attachments of an expense of the caller, oldest first.
+func (s *Service) Attachments(ctx context.Context, expenseID int64) ([]Attachment, error) {
+	uid, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT a.id, a.expense_id, a.name, a.content_type, a.size, a.sha256, a.created_at from attachments a JOIN expenses e ON e.id = a.expense_id where a.expense_id=$1 AND e.owner_id=COALESCE($2, e.owner_id) AND e.deleted_at IS NULL ORDER BY a.id`
+
+	rows, err := s.db.QueryContext(ctx, query, expenseID, uid)
+	if err != nil {
+		return nil, fmt.Errorf("Attachments(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Attachment, 0)
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.ExpenseID, &a.Name, &a.ContentType, &a.Size, &a.SHA256, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("Attachments(): db scan row: %w", err)
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Attachments(): db rows: %w", err)
+	}
+
+	if len(out) == 0 {
+		// tell an expense without attachments from a missing one.
+		if _, err := s.Get(ctx, expenseID); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// OpenAttachment returns an attachment of an expense of the caller with its content, which the caller closes.
+func (s *Service) OpenAttachment(ctx context.Context, expenseID, id int64) (Attachment, io.ReadSeekCloser, error) {
+	if s.blobs == nil {
+		return Attachment{}, nil, ErrNoBlobStore
+	}
+	uid, err := scope(ctx)
+	if err != nil {
+		return Attachment{}, nil, err
+	}
+	query := `SELECT a.id, a.expense_id, a.name, a.content_type, a.size, a.sha256, a.created_at from attachments a JOIN expenses e ON e.id = a.expense_id where a.id=$1 AND a.expense_id=$2 AND e.owner_id=COALESCE($3, e.owner_id) AND e.deleted_at IS NULL`
+
+	var a Attachment
+	err = s.db.QueryRowContext(ctx, query, id, expenseID, uid).Scan(&a.ID, &a.ExpenseID, &a.Name, &a.ContentType, &a.Size, &a.SHA256, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Attachment{}, nil, ErrNoAttachment
+	}
+	if err != nil {
+		return Attachment{}, nil, fmt.Errorf("OpenAttachment(): db scan row: %w", err)
+	}
+
+	f, err := s.blobs.Open(ctx, a.SHA256)
+	if err != nil {
+		return Attachment{}, nil, fmt.Errorf("OpenAttachment(): blob open: %w", err)
+	}
+	return a, f, nil
+}
+
+// DeleteAttachment removes an attachment of an expense of the caller,
+// its content stays in the blob store as long as another attachment has it.
+func (s *Service) DeleteAttachment(ctx context.Context, expenseID, id int64) error {
+	if s.blobs == nil {
+		return ErrNoBlobStore
+	}
+	uid, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+	query := `DELETE FROM attachments a USING expenses e WHERE a.id=$1 AND a.expense_id=$2 AND e.id = a.expense_id AND e.owner_id=COALESCE($3, e.owner_id) AND e.deleted_at IS NULL RETURNING a.sha256`
+
+	var sum string
+	err = s.db.QueryRowContext(ctx, query, id, expenseID, uid).Scan(&sum)
+	if err == sql.ErrNoRows {
+		return ErrNoAttachment
+	}
+	if err != nil {
+		return fmt.Errorf("DeleteAttachment(): db scan row: %w", err)
+	}
+
+	return s.collect(ctx, sum)
+}
+
+// collect removes a content from the blob store once no attachment has it.
+func (s *Service) collect(ctx context.Context, sum string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("collect(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockBlob(ctx, tx, sum); err != nil {
+		return err
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) from attachments where sha256=$1`, sum).Scan(&n); err != nil {
+		return fmt.Errorf("collect(): db scan row: %w", err)
+	}
+	if n == 0 {
+		if err := s.blobs.Delete(ctx, sum); err != nil {
+			return fmt.Errorf("collect(): blob delete: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("collect(): db commit: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package expense_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	"github.com/dakeeChv/assessment/blob"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// receipt is the start of a PNG file, enough for its type to be sniffed.
+var receipt = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01")
+
+func receiptSum() string {
+	sum := sha256.Sum256(receipt)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAttach(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	store, _ := blob.NewDisk(t.TempDir())
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL FOR SHARE`)).
+			WithArgs(int64(1), alice.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+			WithArgs(receiptSum()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO attachments(expense_id, name, content_type, size, sha256) VALUES($1, $2, $3, $4, $5) RETURNING id, created_at`)).
+			WithArgs(int64(1), "ใบเสร็จ.png", "image/png", int64(len(receipt)), receiptSum()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, at))
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+		got, err := expense.Attach(ctx, 1, `C:\Users\alice\ใบเสร็จ.png`, bytes.NewReader(receipt))
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), got.ID)
+		assert.Equal(t, "image/png", got.ContentType)
+		f, err := store.Open(ctx, receiptSum())
+		if assert.NoError(t, err) {
+			b, _ := io.ReadAll(f)
+			f.Close()
+			assert.Equal(t, receipt, b)
+		}
+	})
+
+	t.Run("Failed insert collects the content", func(t *testing.T) {
+		store, _ := blob.NewDisk(t.TempDir())
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id from expenses where id=$1`)).
+			WithArgs(int64(1), alice.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+			WithArgs(receiptSum()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO attachments(expense_id, name, content_type, size, sha256)`)).
+			WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+			WithArgs(receiptSum()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) from attachments where sha256=$1`)).
+			WithArgs(receiptSum()).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+		_, err := expense.Attach(ctx, 1, "receipt.png", bytes.NewReader(receipt))
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, sql.ErrConnDone)
+		_, err = store.Open(ctx, receiptSum())
+		assert.ErrorIs(t, err, blob.ErrNotFound)
+	})
+
+	t.Run("Unsupported type", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+		_, err := expense.Attach(ctx, 1, "receipt.png", strings.NewReader("<html><script>alert(1)</script>"))
+
+		assert.ErrorIs(t, err, expn.ErrUnsupportedAttachment)
+	})
+
+	t.Run("Empty file", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+		_, err := expense.Attach(ctx, 1, "receipt.pdf", strings.NewReader(""))
+
+		assert.ErrorIs(t, err, expn.ErrInvalidAttachment)
+	})
+
+	t.Run("Too large", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+		large := append([]byte("%PDF-1.4\n"), make([]byte, expn.MaxAttachmentSize)...)
+		_, err := expense.Attach(ctx, 1, "receipt.pdf", bytes.NewReader(large))
+
+		assert.ErrorIs(t, err, expn.ErrAttachmentTooLarge)
+	})
+
+	t.Run("Error no expense", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id from expenses where id=$1`)).
+			WithArgs(int64(2), alice.ID).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+		_, err := expense.Attach(ctx, 2, "receipt.png", bytes.NewReader(receipt))
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrNoExpense)
+	})
+
+	t.Run("No blob store", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.Attach(ctx, 1, "receipt.png", bytes.NewReader(receipt))
+
+		assert.ErrorIs(t, err, expn.ErrNoBlobStore)
+	})
+}
+
+func TestDeleteAttachment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	store, _ := blob.NewDisk(t.TempDir())
+	ctx := auth.NewContext(context.Background(), alice)
+	sum, _ := store.Put(ctx, bytes.NewReader(receipt))
+
+	expectDelete := func(refs int) {
+		mock.ExpectQuery(regexp.QuoteMeta(`DELETE FROM attachments a USING expenses e WHERE a.id=$1 AND a.expense_id=$2 AND e.id = a.expense_id AND e.owner_id=COALESCE($3, e.owner_id) AND e.deleted_at IS NULL RETURNING a.sha256`)).
+			WithArgs(int64(5), int64(1), alice.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"sha256"}).AddRow(sum))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+			WithArgs(sum).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) from attachments where sha256=$1`)).
+			WithArgs(sum).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(refs))
+		mock.ExpectCommit()
+	}
+
+	t.Run("Content kept while attached elsewhere", func(t *testing.T) {
+		expectDelete(1)
+		expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+		err := expense.DeleteAttachment(ctx, 1, 5)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		_, err = store.Open(ctx, sum)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Last attachment removes the content", func(t *testing.T) {
+		expectDelete(0)
+		expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+		err := expense.DeleteAttachment(ctx, 1, 5)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		_, err = store.Open(ctx, sum)
+		assert.ErrorIs(t, err, blob.ErrNotFound)
+	})
+
+	t.Run("Error no attachment", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`DELETE FROM attachments a USING expenses e`)).
+			WithArgs(int64(6), int64(1), alice.ID).
+			WillReturnError(sql.ErrNoRows)
+		expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+		err := expense.DeleteAttachment(ctx, 1, 6)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrNoAttachment)
+	})
+}
+
+func TestOpenAttachment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	store, _ := blob.NewDisk(t.TempDir())
+	ctx := auth.NewContext(context.Background(), alice)
+	sum, _ := store.Put(ctx, bytes.NewReader(receipt))
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT a.id, a.expense_id, a.name, a.content_type, a.size, a.sha256, a.created_at from attachments a JOIN expenses e ON e.id = a.expense_id where a.id=$1 AND a.expense_id=$2 AND e.owner_id=COALESCE($3, e.owner_id) AND e.deleted_at IS NULL`)).
+		WithArgs(int64(5), int64(1), alice.ID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "expense_id", "name", "content_type", "size", "sha256", "created_at"}).
+				AddRow(5, 1, "receipt.png", "image/png", len(receipt), sum, at),
+		)
+
+	expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+
+	got, f, err := expense.OpenAttachment(ctx, 1, 5)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	if assert.NoError(t, err) {
+		defer f.Close()
+		assert.Equal(t, "receipt.png", got.Name)
+		b, _ := io.ReadAll(f)
+		assert.Equal(t, receipt, b)
+	}
+}
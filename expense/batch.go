@@ -0,0 +1,117 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidBatch  = errors.New("invalid batch")
+	ErrBatchRejected = errors.New("batch rejected")
+)
+
+// MaxBatch is the most expenses a single CreateMany creates.
+const MaxBatch = 500
+
+// BatchResult is the outcome of an expense of a batch, either the expense created or why it was not.
+type BatchResult struct {
+	Expense *Expense
+	Err     error
+}
+
+// CreateMany creates the expenses with a single multi-row insert in one transaction. When atomic, an invalid expense rejects
+// the whole batch with ErrBatchRejected and creates nothing, otherwise the valid expenses are still created.
+// Either way the results tell the outcome of every expense, in the order given.
+func (s *Service) CreateMany(ctx context.Context, in []Expense, atomic bool) ([]BatchResult, error) {
+	if len(in) == 0 || len(in) > MaxBatch {
+		return nil, fmt.Errorf("%w: a batch holds 1 to %d expenses, not %d", ErrInvalidBatch, MaxBatch, len(in))
+	}
+	uid, err := owner(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(in))
+	var valid []int
+	for i := range in {
+		if err := validate(&in[i]); err != nil {
+			results[i].Err = err
+			continue
+		}
+		valid = append(valid, i)
+	}
+	if len(valid) < len(in) && atomic {
+		return results, fmt.Errorf("%w: %d of %d expenses are invalid", ErrBatchRejected, len(in)-len(valid), len(in))
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	values := make([]string, len(valid))
+	args := make([]interface{}, 0, len(valid)*7)
+	for n, i := range valid {
+		e := in[i]
+		p := len(args)
+		values[n] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, COALESCE($%d, now()), $%d)", p+1, p+2, p+3, p+4, p+5, p+6, p+7)
+		args = append(args, e.Title, e.Amount.Minor, e.Amount.Currency, e.Note, pq.Array(e.Tags), spentAt(e), uid)
+	}
+	query := `INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id) VALUES ` + strings.Join(values, ", ") + ` RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("CreateMany(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("CreateMany(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	created := make([]Expense, 0, len(valid))
+	for rows.Next() {
+		var e Expense
+		if err := rows.Scan(&e.ID, &e.Title, &e.Amount.Minor, &e.Amount.Currency, &e.Note, pq.Array(&e.Tags), &e.SpentAt, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("CreateMany(): db scan row: %w", err)
+		}
+		created = append(created, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("CreateMany(): db rows: %w", err)
+	}
+	rows.Close()
+	if len(created) != len(valid) {
+		return nil, fmt.Errorf("CreateMany(): %d expenses returned for %d inserted", len(created), len(valid))
+	}
+
+	// RETURNING does not promise the order of VALUES, but the ids are drawn from the sequence in that order.
+	sort.Slice(created, func(a, b int) bool { return created[a].ID < created[b].ID })
+	prepared := &preparedOnce{tx: tx, stmts: make(map[string]*sql.Stmt)}
+	for n, i := range valid {
+		if len(created[n].Tags) > 0 {
+			if err := syncTags(ctx, prepared, created[n].ID, created[n].Tags); err != nil {
+				return nil, err
+			}
+		}
+		created[n].Version = 1
+		if err := s.record(ctx, prepared, ActionCreate, nil, created[n], nil); err != nil {
+			return nil, err
+		}
+		if created[n].Overspent, err = s.overspent(ctx, tx, nil, created[n]); err != nil {
+			return nil, err
+		}
+		results[i].Expense = &created[n]
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("CreateMany(): db commit: %w", err)
+	}
+	return results, nil
+}
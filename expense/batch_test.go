@@ -0,0 +1,88 @@
+package expense_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestCreateMany(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	batch := func() []expn.Expense {
+		return []expn.Expense{
+			{Title: "ข้าวมันไก่", Amount: expn.Money{Minor: 6000, Currency: "THB"}, Tags: []string{"food"}},
+			{Title: "toll", Amount: expn.Money{Minor: 500, Currency: "XXX"}},
+			{Title: "coffee", Amount: expn.Money{Minor: 450, Currency: "USD"}, SpentAt: at},
+		}
+	}
+
+	t.Run("Partial", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id) VALUES ($1, $2, $3, $4, $5, COALESCE($6, now()), $7), ($8, $9, $10, $11, $12, COALESCE($13, now()), $14) RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at`)).
+			WithArgs("ข้าวมันไก่", 6000, "THB", "", pq.Array([]string{"food"}), nil, alice.ID, "coffee", 450, "USD", "", pq.Array([]string(nil)), at, alice.ID).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(12, "coffee", 450, "USD", "", pq.Array([]string{}), at, at, at).
+					AddRow(11, "ข้าวมันไก่", 6000, "THB", "", pq.Array([]string{"food"}), at, at, at),
+			)
+		expectTags(mock, 11, "food")
+		history := mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expense_history(expense_id, revision, actor, action, changes, snapshot, reverted_to)`))
+		history.ExpectExec().WithArgs(int64(11), int64(1), alice.ID, expn.ActionCreate, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectBudgets(mock, 11, "food")
+		history.ExpectExec().WithArgs(int64(12), int64(1), alice.ID, expn.ActionCreate, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.CreateMany(ctx, batch(), false)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		if assert.Equal(t, 3, len(got)) {
+			assert.Equal(t, int64(11), got[0].Expense.ID)
+			assert.Nil(t, got[1].Expense)
+			assert.ErrorIs(t, got[1].Err, expn.ErrUnknownCurrency)
+			assert.Equal(t, int64(12), got[2].Expense.ID)
+		}
+	})
+
+	t.Run("Atomic rejects", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.CreateMany(ctx, batch(), true)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrBatchRejected)
+		assert.ErrorIs(t, got[1].Err, expn.ErrUnknownCurrency)
+		assert.Nil(t, got[0].Expense)
+	})
+
+	t.Run("Too many", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.CreateMany(ctx, make([]expn.Expense, expn.MaxBatch+1), true)
+
+		assert.ErrorIs(t, err, expn.ErrInvalidBatch)
+	})
+}
@@ -0,0 +1,353 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNoBudget      = errors.New("no budget")
+	ErrInvalidBudget = errors.New("invalid budget")
+	ErrBudgetExists  = errors.New("budget exists")
+)
+
+// monthLayout is the layout of a budget period such as "2026-10".
+const monthLayout = "2006-01"
+
+// Budget is the monthly spending limit of the expenses having Tag, each user has at most one budget per tag.
+type Budget struct {
+	ID     int64  `json:"id"`
+	Tag    string `json:"tag"`
+	Amount Money  `json:"amount"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// budgetJSON is Budget without its json methods.
+type budgetJSON Budget
+
+// MarshalJSON writes the amount and its currency as an Expense does.
+func (b Budget) MarshalJSON() ([]byte, error) {
+	return marshalWithCurrency(budgetJSON(b), b.Amount)
+}
+
+// UnmarshalJSON reads the amount and its currency as an Expense does.
+func (b *Budget) UnmarshalJSON(data []byte) error {
+	return unmarshalWithCurrency(data, (*budgetJSON)(b), &b.Amount)
+}
+
+// BudgetStatus is how much of a budget is spent in a month, expenses in other currencies are
+// converted with the exchange rate of the day they were spent.
+type BudgetStatus struct {
+	Budget      Budget  `json:"budget"`
+	Month       string  `json:"month"`
+	Spent       Money   `json:"spent"`
+	Remaining   Money   `json:"remaining"`
+	PercentUsed float64 `json:"percent_used"`
+}
+
+// Over reports whether more than the budget is spent.
+func (st BudgetStatus) Over() bool {
+	return st.Spent.Minor > st.Budget.Amount.Minor
+}
+
+// ParseMonth parses a budget period such as "2026-10" into its first instant in UTC.
+func ParseMonth(s string) (time.Time, error) {
+	m, err := time.Parse(monthLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: month %q must be YYYY-MM", ErrInvalidBudget, s)
+	}
+	return m, nil
+}
+
+// monthOf returns the first instant of the month of t in UTC.
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func validateBudget(in *Budget) error {
+	in.Tag = CanonicalTag(in.Tag)
+	if in.Tag == "" {
+		return fmt.Errorf("%w: tag must not be empty", ErrInvalidBudget)
+	}
+	if in.Amount.Currency == "" {
+		in.Amount.Currency = DefaultCurrency
+	}
+	if _, err := Exponent(in.Amount.Currency); err != nil {
+		return err
+	}
+	if in.Amount.Minor <= 0 {
+		return fmt.Errorf("%w: amount must be greater than zero", ErrInvalidBudget)
+	}
+	return nil
+}
+
+// uniqueViolation reports whether err is a postgres unique_violation.
+func uniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// CreateBudget sets the monthly budget of a tag for the caller.
+func (s *Service) CreateBudget(ctx context.Context, in Budget) (Budget, error) {
+	if err := validateBudget(&in); err != nil {
+		return Budget{}, err
+	}
+	uid, err := owner(ctx)
+	if err != nil {
+		return Budget{}, err
+	}
+	query := `INSERT INTO budgets(owner_id, tag, amount, currency) VALUES($1, $2, $3, $4) RETURNING id, tag, amount, currency, created_at, updated_at`
+
+	var out Budget
+	err = s.db.QueryRowContext(ctx, query, uid, in.Tag, in.Amount.Minor, in.Amount.Currency).Scan(&out.ID, &out.Tag, &out.Amount.Minor, &out.Amount.Currency, &out.CreatedAt, &out.UpdatedAt)
+	if uniqueViolation(err) {
+		return Budget{}, fmt.Errorf("%w: tag %q already has a budget", ErrBudgetExists, in.Tag)
+	}
+	if err != nil {
+		return Budget{}, fmt.Errorf("CreateBudget(): db scan row: %w", err)
+	}
+
+	return out, nil
+}
+
+// ListBudgets returns the budgets of the caller ordered by tag.
+func (s *Service) ListBudgets(ctx context.Context) ([]Budget, error) {
+	uid, err := owner(ctx)
+	if err != nil {
+		return []Budget{}, err
+	}
+	query := `SELECT id, tag, amount, currency, created_at, updated_at from budgets where owner_id=$1 ORDER BY tag`
+
+	out, err := s.budgets(ctx, s.db, query, uid)
+	if err != nil {
+		return []Budget{}, fmt.Errorf("ListBudgets(): %w", err)
+	}
+	return out, nil
+}
+
+func (s *Service) budgets(ctx context.Context, db querier, query string, args ...interface{}) ([]Budget, error) {
+	out := make([]Budget, 0)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("db query context: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Budget
+		if err := rows.Scan(&b.ID, &b.Tag, &b.Amount.Minor, &b.Amount.Currency, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("db scan row: %w", err)
+		}
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db rows: %w", err)
+	}
+	return out, nil
+}
+
+func (s *Service) GetBudget(ctx context.Context, id int64) (Budget, error) {
+	uid, err := owner(ctx)
+	if err != nil {
+		return Budget{}, err
+	}
+	query := `SELECT id, tag, amount, currency, created_at, updated_at from budgets where id=$1 AND owner_id=$2`
+
+	var out Budget
+	err = s.db.QueryRowContext(ctx, query, id, uid).Scan(&out.ID, &out.Tag, &out.Amount.Minor, &out.Amount.Currency, &out.CreatedAt, &out.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return Budget{}, ErrNoBudget
+	}
+	if err != nil {
+		return Budget{}, fmt.Errorf("GetBudget(): db scan row: %w", err)
+	}
+
+	return out, nil
+}
+
+func (s *Service) UpdateBudget(ctx context.Context, in Budget) (Budget, error) {
+	if err := validateBudget(&in); err != nil {
+		return Budget{}, err
+	}
+	uid, err := owner(ctx)
+	if err != nil {
+		return Budget{}, err
+	}
+	query := `UPDATE budgets SET tag=$1, amount=$2, currency=$3, updated_at=now() WHERE id=$4 AND owner_id=$5 RETURNING id, tag, amount, currency, created_at, updated_at`
+
+	var out Budget
+	err = s.db.QueryRowContext(ctx, query, in.Tag, in.Amount.Minor, in.Amount.Currency, in.ID, uid).Scan(&out.ID, &out.Tag, &out.Amount.Minor, &out.Amount.Currency, &out.CreatedAt, &out.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return Budget{}, ErrNoBudget
+	}
+	if uniqueViolation(err) {
+		return Budget{}, fmt.Errorf("%w: tag %q already has a budget", ErrBudgetExists, in.Tag)
+	}
+	if err != nil {
+		return Budget{}, fmt.Errorf("UpdateBudget(): db scan row: %w", err)
+	}
+
+	return out, nil
+}
+
+func (s *Service) DeleteBudget(ctx context.Context, id int64) error {
+	uid, err := owner(ctx)
+	if err != nil {
+		return err
+	}
+	query := `DELETE FROM budgets WHERE id=$1 AND owner_id=$2`
+
+	res, err := s.db.ExecContext(ctx, query, id, uid)
+	if err != nil {
+		return fmt.Errorf("DeleteBudget(): db exec context: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("DeleteBudget(): db rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrNoBudget
+	}
+
+	return nil
+}
+
+// BudgetStatus returns how much of each budget of the caller is spent in the month of the given time.
+func (s *Service) BudgetStatus(ctx context.Context, month time.Time) ([]BudgetStatus, error) {
+	budgets, err := s.ListBudgets(ctx)
+	if err != nil {
+		return []BudgetStatus{}, err
+	}
+	out, err := s.status(ctx, s.db, budgets, monthOf(month))
+	if err != nil {
+		return []BudgetStatus{}, fmt.Errorf("BudgetStatus(): %w", err)
+	}
+	return out, nil
+}
+
+// overspent returns the budgets of the owner of after which its write in tx pushes over their limit in the month
+// it is spent, that is the budgets which are over with after but were not with before, nil for a new expense.
+// A budget whose spending can not be converted for want of an exchange rate is left out.
+func (s *Service) overspent(ctx context.Context, tx *sql.Tx, before *Expense, after Expense) ([]BudgetStatus, error) {
+	if len(after.Tags) == 0 {
+		return nil, nil
+	}
+	// the budgets are those of the owner of the expense, who is not the caller when an admin writes it.
+	query := `SELECT id, tag, amount, currency, created_at, updated_at from budgets where owner_id=(SELECT owner_id from expenses where id=$1) AND tag = ANY($2) ORDER BY tag`
+	budgets, err := s.budgets(ctx, tx, query, after.ID, pq.Array(after.Tags))
+	if err != nil {
+		return nil, fmt.Errorf("overspent(): %w", err)
+	}
+
+	month := monthOf(after.SpentAt)
+	statuses, err := s.status(ctx, tx, budgets, month)
+	if errors.Is(err, ErrMissingRate) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("overspent(): %w", err)
+	}
+
+	var out []BudgetStatus
+	for _, st := range statuses {
+		if !st.Over() {
+			continue
+		}
+		// the spending without the write takes after out and puts before back when it counted.
+		write := []Expense{after}
+		if before != nil && before.DeletedAt == nil && monthOf(before.SpentAt).Equal(month) && hasTag(before.Tags, st.Budget.Tag) {
+			write = append(write, *before)
+		}
+		err := s.convertAll(ctx, write, st.Budget.Amount.Currency)
+		if errors.Is(err, ErrMissingRate) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("overspent(): %w", err)
+		}
+		spent := st.Spent.Minor - write[0].Converted.Amount.Minor
+		if len(write) > 1 {
+			spent += write[1].Converted.Amount.Minor
+		}
+		if spent <= st.Budget.Amount.Minor {
+			out = append(out, st)
+		}
+	}
+	return out, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// status sums the expenses tagged with the tag of each budget spent in the month starting at month.
+func (s *Service) status(ctx context.Context, db querier, budgets []Budget, month time.Time) ([]BudgetStatus, error) {
+	out := make([]BudgetStatus, 0, len(budgets))
+	if len(budgets) == 0 {
+		return out, nil
+	}
+
+	ids := make([]int64, len(budgets))
+	for i, b := range budgets {
+		ids[i] = b.ID
+	}
+	// the expenses are summed per currency and day, so each sum converts with the rate of its day.
+	query := `SELECT b.id, e.currency, e.spent_at::DATE, SUM(e.amount)::BIGINT from budgets b
+		JOIN expenses e ON e.owner_id = b.owner_id AND b.tag = ANY(e.tags) AND e.deleted_at IS NULL AND e.spent_at >= $2 AND e.spent_at < $3
+		where b.id = ANY($1)
+		GROUP BY b.id, e.currency, e.spent_at::DATE`
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids), month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, fmt.Errorf("status(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	sums := make(map[int64][]Expense)
+	for rows.Next() {
+		var id int64
+		var e Expense
+		if err := rows.Scan(&id, &e.Amount.Currency, &e.SpentAt, &e.Amount.Minor); err != nil {
+			return nil, fmt.Errorf("status(): db scan row: %w", err)
+		}
+		sums[id] = append(sums[id], e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("status(): db rows: %w", err)
+	}
+
+	for _, b := range budgets {
+		st := BudgetStatus{
+			Budget: b,
+			Month:  month.Format(monthLayout),
+			Spent:  Money{Currency: b.Amount.Currency},
+		}
+		if err := s.convertAll(ctx, sums[b.ID], b.Amount.Currency); err != nil {
+			return nil, err
+		}
+		for _, e := range sums[b.ID] {
+			spent, err := st.Spent.Add(e.Converted.Amount)
+			if err != nil {
+				return nil, err
+			}
+			st.Spent = spent
+		}
+		st.Remaining = Money{Minor: b.Amount.Minor - st.Spent.Minor, Currency: b.Amount.Currency}
+		st.PercentUsed = math.Round(float64(st.Spent.Minor)*1000/float64(b.Amount.Minor)) / 10
+		out = append(out, st)
+	}
+	return out, nil
+}
@@ -0,0 +1,188 @@
+package expense_test
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestCreateBudget(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		var in expn.Budget
+		err := json.Unmarshal([]byte(`{"tag":"food","amount":"8000"}`), &in)
+		assert.NoError(t, err)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO budgets(owner_id, tag, amount, currency) VALUES($1, $2, $3, $4) RETURNING id, tag, amount, currency, created_at, updated_at`)).
+			WithArgs(alice.ID, "food", 800000, "THB").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "tag", "amount", "currency", "created_at", "updated_at"}).AddRow(1, "food", 800000, "THB", at, at))
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.CreateBudget(ctx, in)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, expn.Budget{ID: 1, Tag: "food", Amount: expn.Money{Minor: 800000, Currency: "THB"}, CreatedAt: at, UpdatedAt: at}, got)
+	})
+
+	t.Run("Tag already has a budget", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO budgets`)).
+			WillReturnError(&pq.Error{Code: "23505"})
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.CreateBudget(ctx, expn.Budget{Tag: "food", Amount: expn.Money{Minor: 100}})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrBudgetExists)
+	})
+
+	t.Run("Invalid amount", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.CreateBudget(ctx, expn.Budget{Tag: "food"})
+
+		assert.ErrorIs(t, err, expn.ErrInvalidBudget)
+	})
+}
+
+func TestBudgetStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	october := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	day := time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, tag, amount, currency, created_at, updated_at from budgets where owner_id=$1 ORDER BY tag`)).
+		WithArgs(alice.ID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "tag", "amount", "currency", "created_at", "updated_at"}).
+				AddRow(1, "food", 800000, "THB", at, at).
+				AddRow(2, "travel", 500000, "THB", at, at),
+		)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT b.id, e.currency, e.spent_at::DATE, SUM(e.amount)::BIGINT from budgets b`)).
+		WithArgs(pq.Array([]int64{1, 2}), october, october.AddDate(0, 1, 0)).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "currency", "spent_at", "sum"}).
+				AddRow(1, "THB", day, 600000).
+				AddRow(1, "USD", day, 10000),
+		)
+	mock.ExpectQuery(regexp.QuoteMeta(`from exchange_rates`)).
+		WillReturnRows(sqlmock.NewRows([]string{"date", "base", "quote", "rate"}).AddRow(day, "USD", "THB", "36.5"))
+
+	ctx := auth.NewContext(context.Background(), alice)
+	expense, _ := expn.NewService(ctx, db)
+
+	got, err := expense.BudgetStatus(ctx, time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(got)) {
+		// 6,000 THB + 100 USD * 36.5 = 9,650 THB of 8,000 THB.
+		assert.Equal(t, "2026-10", got[0].Month)
+		assert.Equal(t, expn.Money{Minor: 965000, Currency: "THB"}, got[0].Spent)
+		assert.Equal(t, expn.Money{Minor: -165000, Currency: "THB"}, got[0].Remaining)
+		assert.Equal(t, 120.6, got[0].PercentUsed)
+		assert.True(t, got[0].Over())
+
+		assert.Equal(t, expn.Money{Minor: 0, Currency: "THB"}, got[1].Spent)
+		assert.Equal(t, expn.Money{Minor: 500000, Currency: "THB"}, got[1].Remaining)
+		assert.Equal(t, 0.0, got[1].PercentUsed)
+	}
+}
+
+// budgetsQuery is the read of the budgets of the owner of an expense which a write of it may overspend.
+var budgetsQuery = regexp.QuoteMeta(`SELECT id, tag, amount, currency, created_at, updated_at from budgets where owner_id=(SELECT owner_id from expenses where id=$1) AND tag = ANY($2)`)
+
+// expectBudgets expects a write of an expense to look for its budgets, the owner has none.
+func expectBudgets(mock sqlmock.Sqlmock, id int64, tags ...string) {
+	mock.ExpectQuery(budgetsQuery).
+		WithArgs(id, pq.Array(tags)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tag", "amount", "currency", "created_at", "updated_at"}))
+}
+
+func TestUpdateOverspent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	november := time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		before int64
+		after  int64
+		want   int
+	}{
+		{"Pushed over", 700000, 850000, 1},
+		{"Already over", 900000, 850000, 0},
+		{"Within", 700000, 750000, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := expn.Expense{ID: 7, Title: "groceries", Amount: expn.Money{Minor: tt.after, Currency: "THB"}, Tags: []string{"food"}}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(lockQuery).WithArgs(want.ID, alice.ID).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "deleted_at", "version"}).
+					AddRow(want.ID, "groceries", tt.before, "THB", "", pq.Array([]string{"food"}), at, at, at, nil, 1))
+			mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET")).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}).
+					AddRow(want.ID, "groceries", tt.after, "THB", "", pq.Array([]string{"food"}), at, at, at, 2))
+			expectTags(mock, want.ID, "food")
+			expectRecord(mock, want.ID, 2, expn.ActionUpdate)
+			// nothing else is spent on food in the month, the limit is 8,000 THB.
+			mock.ExpectQuery(budgetsQuery).
+				WithArgs(want.ID, pq.Array(want.Tags)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "tag", "amount", "currency", "created_at", "updated_at"}).AddRow(1, "food", 800000, "THB", at, at))
+			mock.ExpectQuery(regexp.QuoteMeta(`from budgets b`)).
+				WithArgs(pq.Array([]int64{1}), november, november.AddDate(0, 1, 0)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "currency", "spent_at", "sum"}).AddRow(1, "THB", november, tt.after))
+			mock.ExpectCommit()
+
+			ctx := auth.NewContext(context.Background(), alice)
+			expense, _ := expn.NewService(ctx, db)
+
+			got, err := expense.Update(ctx, want)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, len(got.Overspent))
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package expense
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the keyset position of the last expense on a page.
+type cursor struct {
+	// Sort is the sort order the cursor was issued for.
+	Sort string `json:"s"`
+	// Keys holds the sort key values of the last expense.
+	Keys []interface{} `json:"k"`
+}
+
+// encodeCursor returns an opaque cursor, the payload is signed so a client can not forge a position.
+func (s *Service) encodeCursor(c cursor) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, s.cursorKey)
+	mac.Write(payload)
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s *Service) decodeCursor(raw string) (cursor, error) {
+	enc := base64.RawURLEncoding
+	parts := strings.Split(raw, ".")
+	if len(parts) != 2 {
+		return cursor{}, ErrInvalidCursor
+	}
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	sig, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, s.cursorKey)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	var c cursor
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	for i, key := range c.Keys {
+		// json.Number keeps int64 ids exact, the driver sends it as text.
+		if n, ok := key.(json.Number); ok {
+			c.Keys[i] = n.String()
+		}
+	}
+	return c, nil
+}
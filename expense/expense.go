@@ -2,54 +2,214 @@ package expense
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
+
+	"github.com/dakeeChv/assessment/auth"
+	"github.com/dakeeChv/assessment/blob"
+	"github.com/dakeeChv/assessment/policy"
 )
 
-var ErrNoExpense = errors.New("no expense")
+var (
+	ErrNoExpense       = errors.New("no expense")
+	ErrUnauthenticated = errors.New("no authenticated principal")
+	ErrStaleVersion    = errors.New("stale expense version")
+)
 
 // Expense is  Expense tracking model.
 type Expense struct {
 	ID     int64    `json:"id"`
 	Title  string   `json:"title"`
-	Amount float64  `json:"amount"`
+	Amount Money    `json:"amount"`
 	Note   string   `json:"note"`
 	Tags   []string `json:"tags"`
+
+	// SpentAt is when the money was spent, it defaults to the time the expense is created.
+	SpentAt   time.Time  `json:"spent_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Version counts the writes of the expense, it is sent as the ETag rather than in the body.
+	// The Version given to Update is the one the caller last read, 0 to overwrite any.
+	Version int64 `json:"-"`
+
+	// Converted is the amount in the currency requested by the caller, if any.
+	Converted *Conversion `json:"converted,omitempty"`
+
+	// Overspent are the budgets the write returning the expense pushed over their limit,
+	// they are sent as warning headers rather than in the body.
+	Overspent []BudgetStatus `json:"-"`
+}
+
+// expenseJSON is Expense without its json methods.
+type expenseJSON Expense
+
+// MarshalJSON writes the currency of the amount as its own member.
+func (e Expense) MarshalJSON() ([]byte, error) {
+	return marshalWithCurrency(expenseJSON(e), e.Amount)
+}
+
+// UnmarshalJSON reads the amount in the currency member, DefaultCurrency when it is missing.
+func (e *Expense) UnmarshalJSON(b []byte) error {
+	return unmarshalWithCurrency(b, (*expenseJSON)(e), &e.Amount)
 }
 
 type Service struct {
-	db *sql.DB
+	db        *sql.DB
+	cursorKey []byte
+	blobs     blob.Store
+}
+
+// Option configures the expense service.
+type Option func(*Service)
+
+// WithCursorKey sets the secret used to sign the pagination cursors,
+// without it a random key is used and cursors do not survive a restart.
+func WithCursorKey(key []byte) Option {
+	return func(s *Service) {
+		s.cursorKey = key
+	}
 }
 
 // NewService returns expense service.
-func NewService(_ context.Context, db *sql.DB) (*Service, error) {
-	return &Service{db: db}, nil
+func NewService(_ context.Context, db *sql.DB, opts ...Option) (*Service, error) {
+	s := &Service{db: db}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if len(s.cursorKey) == 0 {
+		s.cursorKey = make([]byte, 32)
+		if _, err := rand.Read(s.cursorKey); err != nil {
+			return nil, fmt.Errorf("NewService(): generate cursor key: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// spentAt is the spent_at argument of a write, nil lets the database keep or default it.
+func spentAt(in Expense) interface{} {
+	if in.SpentAt.IsZero() {
+		return nil
+	}
+	return in.SpentAt
+}
+
+// owner returns the id of the authenticated caller, every expense belongs to exactly one user.
+func owner(ctx context.Context) (string, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	return p.ID, nil
+}
+
+// scope returns the owner_id argument restricting a query to the caller's expenses,
+// it is nil for callers allowed to act on everyone's expenses.
+func scope(ctx context.Context) (interface{}, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if policy.Can(p, policy.AllExpenses) {
+		return nil, nil
+	}
+	return p.ID, nil
+}
+
+// validate canonicalises the tags, defaults the currency of the amount and checks it is supported.
+func validate(in *Expense) error {
+	in.Tags = CanonicalTags(in.Tags)
+	if in.Amount.Currency == "" {
+		in.Amount.Currency = DefaultCurrency
+	}
+	_, err := Exponent(in.Amount.Currency)
+	return err
+}
+
+// preparer is the *sql.Tx of a write, which commits together with its history.
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// querier is a *sql.DB, or a *sql.Tx to read what it wrote.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 }
 
 func (s *Service) Create(ctx context.Context, in Expense) (Expense, error) {
-	stmt, err := s.db.PrepareContext(ctx, `INSERT INTO expenses(title, amount, note, tags) VALUES($1, $2, $3, $4) RETURNING id, title, amount, note, tags`)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Expense{}, fmt.Errorf("Create(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	out, err := s.create(ctx, tx, in)
+	if err != nil {
+		return Expense{}, err
+	}
+	if out.Overspent, err = s.overspent(ctx, tx, nil, out); err != nil {
+		return Expense{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Expense{}, fmt.Errorf("Create(): db commit: %w", err)
+	}
+	return out, nil
+}
+
+func (s *Service) create(ctx context.Context, db preparer, in Expense) (Expense, error) {
+	if err := validate(&in); err != nil {
+		return Expense{}, err
+	}
+	uid, err := owner(ctx)
+	if err != nil {
+		return Expense{}, err
+	}
+
+	stmt, err := db.PrepareContext(ctx, `INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id) VALUES($1, $2, $3, $4, $5, COALESCE($6, now()), $7) RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at`)
 	if err != nil {
 		return Expense{}, fmt.Errorf("Create(): db prepare context failure: %w", err)
 	}
 
-	err = stmt.QueryRowContext(ctx, in.Title, in.Amount, in.Note, pq.Array(in.Tags)).Scan(&in.ID, &in.Title, &in.Amount, &in.Note, pq.Array(&in.Tags))
+	err = stmt.QueryRowContext(ctx, in.Title, in.Amount.Minor, in.Amount.Currency, in.Note, pq.Array(in.Tags), spentAt(in), uid).Scan(&in.ID, &in.Title, &in.Amount.Minor, &in.Amount.Currency, &in.Note, pq.Array(&in.Tags), &in.SpentAt, &in.CreatedAt, &in.UpdatedAt)
 	if err != nil {
 		return Expense{}, fmt.Errorf("Create(): db scan row: %w", err)
 	}
 
+	if len(in.Tags) > 0 {
+		if err := syncTags(ctx, db, in.ID, in.Tags); err != nil {
+			return Expense{}, err
+		}
+	}
+
+	// a new expense is at the version the column defaults to.
+	in.Version = 1
+	if err := s.record(ctx, db, ActionCreate, nil, in, nil); err != nil {
+		return Expense{}, err
+	}
 	out := in
 
 	return out, nil
 }
 
 func (s *Service) Get(ctx context.Context, id int64) (Expense, error) {
-	query := `SELECT id, title, amount, note, tags from expenses where id=$1`
+	uid, err := scope(ctx)
+	if err != nil {
+		return Expense{}, err
+	}
+	query := `SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL`
 
 	var out Expense
-	err := s.db.QueryRowContext(ctx, query, id).Scan(&out.ID, &out.Title, &out.Amount, &out.Note, pq.Array(&out.Tags))
+	err = s.db.QueryRowContext(ctx, query, id, uid).Scan(&out.ID, &out.Title, &out.Amount.Minor, &out.Amount.Currency, &out.Note, pq.Array(&out.Tags), &out.SpentAt, &out.CreatedAt, &out.UpdatedAt, &out.Version)
 	if err == sql.ErrNoRows {
 		return Expense{}, ErrNoExpense
 	}
@@ -60,38 +220,320 @@ func (s *Service) Get(ctx context.Context, id int64) (Expense, error) {
 	return out, nil
 }
 
+// Update overwrites an expense. When in.Version is set the expense must still be at that version,
+// otherwise ErrStaleVersion is returned as someone else wrote it since the caller read it.
 func (s *Service) Update(ctx context.Context, in Expense) (Expense, error) {
-	query := `UPDATE expenses SET title=$1, amount=$2, note=$3, tags=$4 WHERE id=$5 RETURNING id, title, amount, note, tags`
+	if err := validate(&in); err != nil {
+		return Expense{}, err
+	}
+
+	uid, err := scope(ctx)
+	if err != nil {
+		return Expense{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Expense{}, fmt.Errorf("Update(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, in.ID, uid, false)
+	if err != nil {
+		return Expense{}, err
+	}
+	if in.Version != 0 && in.Version != before.Version {
+		return Expense{}, ErrStaleVersion
+	}
+
+	out, err := overwrite(ctx, tx, in)
+	if err != nil {
+		return Expense{}, err
+	}
+	if err := s.record(ctx, tx, ActionUpdate, &before, out, nil); err != nil {
+		return Expense{}, err
+	}
+	if out.Overspent, err = s.overspent(ctx, tx, &before, out); err != nil {
+		return Expense{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Expense{}, fmt.Errorf("Update(): db commit: %w", err)
+	}
+
+	return out, nil
+}
+
+// Patch updates only the fields present in p, so concurrent patches of different fields do not overwrite each other.
+func (s *Service) Patch(ctx context.Context, id int64, p Patch) (Expense, error) {
+	if p.IsEmpty() {
+		return s.Get(ctx, id)
+	}
+	uid, err := scope(ctx)
+	if err != nil {
+		return Expense{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Expense{}, fmt.Errorf("Patch(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the row is locked to read the part of the amount not being patched and the fields before, for the history.
+	before, err := lock(ctx, tx, id, uid, false)
+	if err != nil {
+		return Expense{}, err
+	}
+
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s=$%d", column, len(args)))
+	}
+	if p.Title != nil {
+		set("title", *p.Title)
+	}
+	if p.Amount != nil || p.Currency != nil {
+		// The amount is validated against the resulting currency.
+		amount, currency := before.Amount.String(), before.Amount.Currency
+		if p.Amount != nil {
+			amount = *p.Amount
+		}
+		if p.Currency != nil {
+			currency = *p.Currency
+		}
+		m, err := ParseMoney(amount, currency)
+		if err != nil {
+			return Expense{}, err
+		}
+		set("amount", m.Minor)
+		set("currency", m.Currency)
+	}
+	if p.Note != nil {
+		set("note", *p.Note)
+	}
+	if p.Tags != nil {
+		set("tags", pq.Array(CanonicalTags(*p.Tags)))
+	}
+	if p.SpentAt != nil {
+		set("spent_at", *p.SpentAt)
+	}
+	sets = append(sets, "updated_at=now()", "version=version+1")
+	args = append(args, id)
+	query := fmt.Sprintf(`UPDATE expenses SET %s WHERE id=$%d RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version`, strings.Join(sets, ", "), len(args))
 
 	var out Expense
-	err := s.db.QueryRowContext(ctx, query, in.Title, in.Amount, in.Note, pq.Array(in.Tags), in.ID).Scan(&out.ID, &out.Title, &out.Amount, &out.Note, pq.Array(&out.Tags))
-	if err == sql.ErrNoRows {
-		return Expense{}, ErrNoExpense
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&out.ID, &out.Title, &out.Amount.Minor, &out.Amount.Currency, &out.Note, pq.Array(&out.Tags), &out.SpentAt, &out.CreatedAt, &out.UpdatedAt, &out.Version)
+	if err != nil {
+		return Expense{}, fmt.Errorf("Patch(): db scan row: %w", err)
+	}
+	if p.Tags != nil {
+		if err := syncTags(ctx, tx, out.ID, out.Tags); err != nil {
+			return Expense{}, err
+		}
+	}
+	if err := s.record(ctx, tx, ActionUpdate, &before, out, nil); err != nil {
+		return Expense{}, err
 	}
+	if out.Overspent, err = s.overspent(ctx, tx, &before, out); err != nil {
+		return Expense{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Expense{}, fmt.Errorf("Patch(): db commit: %w", err)
+	}
+
+	return out, nil
+}
+
+// PatchJSON applies JSON Patch operations to an expense while holding its row lock.
+func (s *Service) PatchJSON(ctx context.Context, id int64, ops []Operation) (Expense, error) {
+	uid, err := scope(ctx)
+	if err != nil {
+		return Expense{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return Expense{}, fmt.Errorf("Update(): db scan row: %w", err)
+		return Expense{}, fmt.Errorf("PatchJSON(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, id, uid, false)
+	if err != nil {
+		return Expense{}, err
+	}
+
+	in, err := ApplyJSONPatch(before, ops)
+	if err != nil {
+		return Expense{}, err
+	}
+	if err := validate(&in); err != nil {
+		return Expense{}, err
+	}
+
+	in.ID = id
+	out, err := overwrite(ctx, tx, in)
+	if err != nil {
+		return Expense{}, err
+	}
+	if err := s.record(ctx, tx, ActionUpdate, &before, out, nil); err != nil {
+		return Expense{}, err
+	}
+	if out.Overspent, err = s.overspent(ctx, tx, &before, out); err != nil {
+		return Expense{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Expense{}, fmt.Errorf("PatchJSON(): db commit: %w", err)
 	}
 
 	return out, nil
 }
 
-func (s *Service) List(ctx context.Context) ([]Expense, error) {
-	query := `SELECT id, title, amount, note, tags from expenses`
+// Delete moves an expense into the trash, it can be restored until it is purged.
+func (s *Service) Delete(ctx context.Context, id int64) error {
+	uid, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Delete(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, id, uid, false)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE expenses SET deleted_at=now(), updated_at=now(), version=version+1 WHERE id=$1 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at, version`
+
+	var out Expense
+	err = tx.QueryRowContext(ctx, query, id).Scan(&out.ID, &out.Title, &out.Amount.Minor, &out.Amount.Currency, &out.Note, pq.Array(&out.Tags), &out.SpentAt, &out.CreatedAt, &out.UpdatedAt, &out.DeletedAt, &out.Version)
+	if err != nil {
+		return fmt.Errorf("Delete(): db scan row: %w", err)
+	}
+	if err := s.record(ctx, tx, ActionDelete, &before, out, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Delete(): db commit: %w", err)
+	}
+
+	return nil
+}
+
+// Trash lists the soft deleted expenses, most recently deleted first.
+func (s *Service) Trash(ctx context.Context) ([]Expense, error) {
+	uid, err := scope(ctx)
+	if err != nil {
+		return []Expense{}, err
+	}
+	query := `SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at from expenses where owner_id=COALESCE($1, owner_id) AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id`
 
 	out := make([]Expense, 0)
-	rows, err := s.db.QueryContext(ctx, query)
+	rows, err := s.db.QueryContext(ctx, query, uid)
 	if err != nil {
-		return []Expense{}, fmt.Errorf("List(): db query context: %w", err)
+		return []Expense{}, fmt.Errorf("Trash(): db query context: %w", err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var expense Expense
-		err := rows.Scan(&expense.ID, &expense.Title, &expense.Amount, &expense.Note, pq.Array(&expense.Tags))
+		err := rows.Scan(&expense.ID, &expense.Title, &expense.Amount.Minor, &expense.Amount.Currency, &expense.Note, pq.Array(&expense.Tags), &expense.SpentAt, &expense.CreatedAt, &expense.UpdatedAt, &expense.DeletedAt)
 		if err != nil {
-			return []Expense{}, fmt.Errorf("List(): db scan row: %w", err)
+			return []Expense{}, fmt.Errorf("Trash(): db scan row: %w", err)
 		}
 		out = append(out, expense)
 	}
+	if err := rows.Err(); err != nil {
+		return []Expense{}, fmt.Errorf("Trash(): db rows: %w", err)
+	}
+
+	return out, nil
+}
+
+// Restore takes an expense back out of the trash.
+func (s *Service) Restore(ctx context.Context, id int64) (Expense, error) {
+	uid, err := scope(ctx)
+	if err != nil {
+		return Expense{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Expense{}, fmt.Errorf("Restore(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, id, uid, true)
+	if err != nil {
+		return Expense{}, err
+	}
+	query := `UPDATE expenses SET deleted_at=NULL, updated_at=now(), version=version+1 WHERE id=$1 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version`
+
+	var out Expense
+	err = tx.QueryRowContext(ctx, query, id).Scan(&out.ID, &out.Title, &out.Amount.Minor, &out.Amount.Currency, &out.Note, pq.Array(&out.Tags), &out.SpentAt, &out.CreatedAt, &out.UpdatedAt, &out.Version)
+	if err != nil {
+		return Expense{}, fmt.Errorf("Restore(): db scan row: %w", err)
+	}
+	if err := s.record(ctx, tx, ActionRestore, &before, out, nil); err != nil {
+		return Expense{}, err
+	}
+	if out.Overspent, err = s.overspent(ctx, tx, &before, out); err != nil {
+		return Expense{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Expense{}, fmt.Errorf("Restore(): db commit: %w", err)
+	}
 
 	return out, nil
 }
+
+// Purge hard deletes every expense of every user that was moved into the trash before the given time.
+func (s *Service) Purge(ctx context.Context, before time.Time) (int64, error) {
+	// the attachments go with their expenses, their contents are collected afterwards.
+	var sums []string
+	if s.blobs != nil {
+		rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT a.sha256 from attachments a JOIN expenses e ON e.id = a.expense_id where e.deleted_at IS NOT NULL AND e.deleted_at < $1`, before)
+		if err != nil {
+			return 0, fmt.Errorf("Purge(): db query context: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var sum string
+			if err := rows.Scan(&sum); err != nil {
+				return 0, fmt.Errorf("Purge(): db scan row: %w", err)
+			}
+			sums = append(sums, sum)
+		}
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("Purge(): db rows: %w", err)
+		}
+		rows.Close()
+	}
+
+	query := `DELETE FROM expenses WHERE deleted_at IS NOT NULL AND deleted_at < $1`
+
+	res, err := s.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, fmt.Errorf("Purge(): db exec context: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Purge(): db rows affected: %w", err)
+	}
+	for _, sum := range sums {
+		if err := s.collect(ctx, sum); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
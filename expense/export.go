@@ -0,0 +1,171 @@
+package expense
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidExport = errors.New("invalid export")
+
+// CSVHeader are the columns of an expense CSV file.
+var CSVHeader = []string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}
+
+// bom is the UTF-8 byte order mark, without it Excel reads the Thai text of a CSV file in the local code page.
+const bom = "\uFEFF"
+
+// tagSeparator separates the tags in their single CSV column, e.g. "food|beverage".
+const tagSeparator = '|'
+
+// flushEvery is how many rows are buffered before they are flushed to the client.
+const flushEvery = 100
+
+// ExportOptions selects the expenses of an export and the CSV dialect.
+type ExportOptions struct {
+	Filter ListFilter
+	// Sort orders the rows, by id when empty.
+	Sort []SortKey
+
+	// Comma is the delimiter, ',' by default.
+	Comma rune
+	// BOM starts the file with a UTF-8 byte order mark.
+	BOM bool
+}
+
+// ValidComma reports whether r can delimit CSV fields.
+func ValidComma(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && r != tagSeparator && r != '\\' && r != utf8.RuneError
+}
+
+// EncodeTags writes tags into a single CSV column separated by '|', a '|' or '\' in a tag is escaped with '\'.
+func EncodeTags(tags []string) string {
+	escaped := make([]string, len(tags))
+	r := strings.NewReplacer(`\`, `\\`, string(tagSeparator), `\`+string(tagSeparator))
+	for i, t := range tags {
+		escaped[i] = r.Replace(t)
+	}
+	return strings.Join(escaped, string(tagSeparator))
+}
+
+// DecodeTags reads the tags written by EncodeTags.
+func DecodeTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	var cur strings.Builder
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == tagSeparator:
+			out = append(out, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	return append(out, cur.String())
+}
+
+// formulaSafe keeps a spreadsheet from running a text cell as a formula by quoting it with a leading apostrophe.
+func formulaSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// ExportCSV writes the expenses matching the options to w as CSV. The rows are streamed from the database
+// as they are read and flushed every few rows, so an export of any size runs in constant memory.
+// It returns how many expenses were written.
+func (s *Service) ExportCSV(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	if opts.Comma == 0 {
+		opts.Comma = ','
+	}
+	if !ValidComma(opts.Comma) {
+		return 0, fmt.Errorf("%w: %q can not be the delimiter", ErrInvalidExport, opts.Comma)
+	}
+	wh, err := filtered(ctx, opts.Filter)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := normalizeSort(opts.Sort)
+	if err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where %s ORDER BY %s`, wh, orderBy(keys))
+
+	rows, err := s.db.QueryContext(ctx, query, wh.args...)
+	if err != nil {
+		return 0, fmt.Errorf("ExportCSV(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	if opts.BOM {
+		if _, err := io.WriteString(w, bom); err != nil {
+			return 0, fmt.Errorf("ExportCSV(): write: %w", err)
+		}
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = opts.Comma
+	flush := func() error {
+		cw.Flush()
+		if f, ok := w.(interface{ Flush() }); ok {
+			f.Flush()
+		}
+		return cw.Error()
+	}
+	if err := cw.Write(CSVHeader); err != nil {
+		return 0, fmt.Errorf("ExportCSV(): write: %w", err)
+	}
+
+	n := 0
+	for rows.Next() {
+		var e Expense
+		err := rows.Scan(&e.ID, &e.Title, &e.Amount.Minor, &e.Amount.Currency, &e.Note, pq.Array(&e.Tags), &e.SpentAt, &e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			return n, fmt.Errorf("ExportCSV(): db scan row: %w", err)
+		}
+		record := []string{
+			strconv.FormatInt(e.ID, 10),
+			formulaSafe(e.Title),
+			e.Amount.String(),
+			e.Amount.Currency,
+			formulaSafe(e.Note),
+			formulaSafe(EncodeTags(e.Tags)),
+			e.SpentAt.Format(time.RFC3339),
+			e.CreatedAt.Format(time.RFC3339),
+			e.UpdatedAt.Format(time.RFC3339),
+		}
+		if err := cw.Write(record); err != nil {
+			return n, fmt.Errorf("ExportCSV(): write: %w", err)
+		}
+		n++
+		if n%flushEvery == 0 {
+			if err := flush(); err != nil {
+				return n, fmt.Errorf("ExportCSV(): write: %w", err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("ExportCSV(): db rows: %w", err)
+	}
+	if err := flush(); err != nil {
+		return n, fmt.Errorf("ExportCSV(): write: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,67 @@
+package expense_test
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestExportCSV(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where owner_id = $1 AND deleted_at IS NULL AND tags && $2 ORDER BY id`)).
+			WithArgs(alice.ID, pq.Array([]string{"food"})).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(1, "ข้าวมันไก่; พิเศษ", 6000, "THB", "=1+1", pq.Array([]string{"food", "a|b"}), at, at, at),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		var buf bytes.Buffer
+		n, err := expense.ExportCSV(ctx, &buf, expn.ExportOptions{
+			Filter: expn.ListFilter{TagsAny: []string{"food"}},
+			Comma:  ';',
+			BOM:    true,
+		})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, "\uFEFFid;title;amount;currency;note;tags;spent_at;created_at;updated_at\n"+
+			`1;"ข้าวมันไก่; พิเศษ";60.00;THB;'=1+1;food|a\|b;2022-11-10T09:30:00Z;2022-11-10T09:30:00Z;2022-11-10T09:30:00Z`+"\n", buf.String())
+	})
+
+	t.Run("Invalid delimiter", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.ExportCSV(ctx, &bytes.Buffer{}, expn.ExportOptions{Comma: '"'})
+
+		assert.ErrorIs(t, err, expn.ErrInvalidExport)
+	})
+}
+
+func TestDecodeTags(t *testing.T) {
+	tags := []string{"food", `a|b`, `c\d`, ""}
+
+	assert.Equal(t, tags, expn.DecodeTags(expn.EncodeTags(tags)))
+	assert.Nil(t, expn.DecodeTags(""))
+}
@@ -6,15 +6,23 @@ import (
 	"errors"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/lib/pq"
 	_ "github.com/proullon/ramsql/driver"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/dakeeChv/assessment/auth"
 	expn "github.com/dakeeChv/assessment/expense"
 )
 
+// alice is the caller of every service method, she owns the stubbed rows.
+var alice = auth.Principal{ID: "alice"}
+
+// at is the spent_at, created_at and updated_at of the stubbed rows.
+var at = time.Date(2022, time.November, 10, 9, 30, 0, 0, time.UTC)
+
 func TestCreateExpense(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -25,22 +33,27 @@ func TestCreateExpense(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		in := expn.Expense{
 			Title:  "strawberry smoothie",
-			Amount: 79,
+			Amount: expn.Money{Minor: 7900, Currency: "THB"},
 			Note:   "night market promotion discount 10 bath",
 			Tags:   []string{"food", "beverage"},
 		}
 
-		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, note, tags) VALUES($1, $2, $3, $4) RETURNING id, title, amount, note, tags`)).
+		mock.ExpectBegin()
+		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id) VALUES($1, $2, $3, $4, $5, COALESCE($6, now()), $7) RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at`)).
 			ExpectQuery().
 			WillReturnRows(
-				sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-					AddRow(1, "strawberry smoothie", 79.00, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"})),
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(1, "strawberry smoothie", 7900, "THB", "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}), at, at, at),
 			).
-			WithArgs(in.Title, in.Amount, in.Note, pq.Array(in.Tags))
+			WithArgs(in.Title, in.Amount.Minor, in.Amount.Currency, in.Note, pq.Array(in.Tags), nil, alice.ID)
+		expectTags(mock, 1, "food", "beverage")
+		expectRecord(mock, 1, 1, expn.ActionCreate)
+		expectBudgets(mock, 1, "food", "beverage")
+		mock.ExpectCommit()
 
 		want := in
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
 		got, err := expense.Create(ctx, in)
@@ -60,18 +73,20 @@ func TestCreateExpense(t *testing.T) {
 
 	t.Run("Failed to db scan row", func(t *testing.T) {
 		want := errors.New(`sql: Scan error on column index 4, name "tags": unsupported Scan, storing driver.Value type string into type *[]string`)
-		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, note, tags) VALUES($1, $2, $3, $4) RETURNING id, title, amount, note, tags`)).
+		mock.ExpectBegin()
+		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id) VALUES($1, $2, $3, $4, $5, COALESCE($6, now()), $7) RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at`)).
 			ExpectQuery().
 			WillReturnError(want)
+		mock.ExpectRollback()
 
 		in := expn.Expense{
 			Title:  "strawberry smoothie",
-			Amount: 79,
+			Amount: expn.Money{Minor: 7900, Currency: "THB"},
 			Note:   "night market promotion discount 10 bath",
 			Tags:   []string{"food", "beverage"},
 		}
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
 		got, err := expense.Create(ctx, in)
@@ -86,17 +101,19 @@ func TestCreateExpense(t *testing.T) {
 
 	t.Run("Failed to db prepare", func(t *testing.T) {
 		want := errors.New("call to Prepare statement with query 'INSERT INTO expenses(title, amount, note, tags) VALUES($1, $2, $3)', was not expected")
-		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, note, tags) VALUES($1, $2, $3, $4) RETURNING id, title, amount, note, tags`)).
+		mock.ExpectBegin()
+		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id) VALUES($1, $2, $3, $4, $5, COALESCE($6, now()), $7) RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at`)).
 			WillReturnError(want)
+		mock.ExpectRollback()
 
 		in := expn.Expense{
 			Title:  "strawberry smoothie",
-			Amount: 79,
+			Amount: expn.Money{Minor: 7900, Currency: "THB"},
 			Note:   "night market promotion discount 10 bath",
 			Tags:   []string{"food", "beverage"},
 		}
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
 		got, err := expense.Create(ctx, in)
@@ -121,19 +138,19 @@ func TestGetExpense(t *testing.T) {
 		want := expn.Expense{
 			ID:     1,
 			Title:  "strawberry smoothie",
-			Amount: 79,
+			Amount: expn.Money{Minor: 7900, Currency: "THB"},
 			Note:   "night market promotion discount 10 bath",
 			Tags:   []string{"food", "beverage"},
 		}
 
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, note, tags from expenses where id=$1")).
-			WithArgs(want.ID).
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL")).
+			WithArgs(want.ID, alice.ID).
 			WillReturnRows(
-				sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-					AddRow(1, "strawberry smoothie", 79.00, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"})),
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}).
+					AddRow(1, "strawberry smoothie", 7900, "THB", "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}), at, at, at, 2),
 			)
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
 		got, err := expense.Get(ctx, want.ID)
@@ -149,6 +166,7 @@ func TestGetExpense(t *testing.T) {
 		assert.Equal(t, want.Amount, got.Amount)
 		assert.Equal(t, want.Note, got.Note)
 		assert.Equal(t, want.Tags, got.Tags)
+		assert.Equal(t, int64(2), got.Version)
 	})
 
 	t.Run("Error no row", func(t *testing.T) {
@@ -156,11 +174,11 @@ func TestGetExpense(t *testing.T) {
 			ID: 1,
 		}
 
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, note, tags from expenses where id=$1")).
-			WithArgs(want.ID).
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL")).
+			WithArgs(want.ID, alice.ID).
 			WillReturnError(sql.ErrNoRows)
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
 		got, err := expense.Get(ctx, want.ID)
@@ -178,11 +196,11 @@ func TestGetExpense(t *testing.T) {
 		var id int64 = 1
 		want := errors.New("some error")
 
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, note, tags from expenses where id=$1")).
-			WithArgs(id).
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL")).
+			WithArgs(id, alice.ID).
 			WillReturnError(want)
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
 		got, err := expense.Get(ctx, id)
@@ -195,6 +213,41 @@ func TestGetExpense(t *testing.T) {
 		assert.Equal(t, expn.Expense{}, got)
 		assert.ErrorIs(t, err, want)
 	})
+
+	t.Run("Error unauthenticated", func(t *testing.T) {
+		ctx := context.Background()
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Get(ctx, 1)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.Equal(t, expn.Expense{}, got)
+		assert.ErrorIs(t, err, expn.ErrUnauthenticated)
+	})
+
+	t.Run("Admin reads any owner", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL")).
+			WithArgs(1, nil).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}).
+					AddRow(1, "strawberry smoothie", 7900, "THB", "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}), at, at, at, 2),
+			)
+
+		ctx := auth.NewContext(context.Background(), auth.Principal{ID: "bob", Role: "admin"})
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Get(ctx, 1)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), got.ID)
+	})
 }
 
 func TestUpdateExpense(t *testing.T) {
@@ -208,19 +261,25 @@ func TestUpdateExpense(t *testing.T) {
 		want := expn.Expense{
 			ID:     123,
 			Title:  "apple smoothie",
-			Amount: 89,
+			Amount: expn.Money{Minor: 8900, Currency: "THB"},
 			Note:   "no discount",
 			Tags:   []string{"beverage"},
 		}
 
-		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET title=$1, amount=$2, note=$3, tags=$4 WHERE id=$5 RETURNING id, title, amount, note, tags")).
-			WithArgs(want.Title, want.Amount, want.Note, pq.Array(want.Tags), want.ID).
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(want.ID, alice.ID).WillReturnRows(lockRows(want.ID, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET title=$1, amount=$2, currency=$3, note=$4, tags=$5, spent_at=COALESCE($6, spent_at), updated_at=now(), version=version+1 WHERE id=$7 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version")).
+			WithArgs(want.Title, want.Amount.Minor, want.Amount.Currency, want.Note, pq.Array(want.Tags), nil, want.ID).
 			WillReturnRows(
-				sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-					AddRow(123, "apple smoothie", 89.00, "no discount", pq.Array([]string{"beverage"})),
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}).
+					AddRow(123, "apple smoothie", 8900, "THB", "no discount", pq.Array([]string{"beverage"}), at, at, at, 2),
 			)
+		expectTags(mock, want.ID, "beverage")
+		expectRecord(mock, want.ID, 2, expn.ActionUpdate)
+		expectBudgets(mock, want.ID, "beverage")
+		mock.ExpectCommit()
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
 		got, err := expense.Update(ctx, want)
@@ -242,16 +301,18 @@ func TestUpdateExpense(t *testing.T) {
 		want := expn.Expense{
 			ID:     123,
 			Title:  "apple smoothie",
-			Amount: 89,
+			Amount: expn.Money{Minor: 8900, Currency: "THB"},
 			Note:   "no discount",
 			Tags:   []string{"beverage"},
 		}
 
-		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET title=$1, amount=$2, note=$3, tags=$4 WHERE id=$5 RETURNING id, title, amount, note, tags")).
-			WithArgs(want.Title, want.Amount, want.Note, pq.Array(want.Tags), want.ID).
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(want.ID, alice.ID).
 			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
 		got, err := expense.Update(ctx, want)
@@ -272,18 +333,21 @@ func TestUpdateExpense(t *testing.T) {
 		want := expn.Expense{
 			ID:     123,
 			Title:  "apple smoothie",
-			Amount: 89,
+			Amount: expn.Money{Minor: 8900, Currency: "THB"},
 			Note:   "no discount",
 			Tags:   []string{"beverage"},
 		}
 
 		errwant := errors.New("some error")
 
-		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET title=$1, amount=$2, note=$3, tags=$4 WHERE id=$5 RETURNING id, title, amount, note, tags")).
-			WithArgs(want.Title, want.Amount, want.Note, pq.Array(want.Tags), want.ID).
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(want.ID, alice.ID).WillReturnRows(lockRows(want.ID, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET title=$1, amount=$2, currency=$3, note=$4, tags=$5, spent_at=COALESCE($6, spent_at), updated_at=now(), version=version+1 WHERE id=$7 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version")).
+			WithArgs(want.Title, want.Amount.Minor, want.Amount.Currency, want.Note, pq.Array(want.Tags), nil, want.ID).
 			WillReturnError(errwant)
+		mock.ExpectRollback()
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
 		got, err := expense.Update(ctx, want)
@@ -299,9 +363,56 @@ func TestUpdateExpense(t *testing.T) {
 		assert.Empty(t, got.Note)
 		assert.Empty(t, got.Tags)
 	})
+
+	t.Run("Matching version", func(t *testing.T) {
+		want := expn.Expense{ID: 123, Title: "apple smoothie", Amount: expn.Money{Minor: 8900, Currency: "THB"}, Version: 2}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(want.ID, alice.ID).WillReturnRows(lockRows(want.ID, 2))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET")).
+			WithArgs(want.Title, want.Amount.Minor, want.Amount.Currency, want.Note, pq.Array(want.Tags), nil, want.ID).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}).
+					AddRow(123, "apple smoothie", 8900, "THB", "", pq.Array([]string{}), at, at, at, 3),
+			)
+		expectTags(mock, want.ID, []string{}...)
+		expectRecord(mock, want.ID, 3, expn.ActionUpdate)
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Update(ctx, want)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), got.Version)
+	})
+
+	t.Run("Stale version", func(t *testing.T) {
+		want := expn.Expense{ID: 123, Title: "apple smoothie", Amount: expn.Money{Minor: 8900, Currency: "THB"}, Version: 2}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(want.ID, alice.ID).WillReturnRows(lockRows(want.ID, 3))
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.Update(ctx, want)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrStaleVersion)
+	})
 }
 
-func TestListExpenses(t *testing.T) {
+func TestDeleteExpense(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -309,57 +420,118 @@ func TestListExpenses(t *testing.T) {
 	defer db.Close()
 
 	t.Run("Success", func(t *testing.T) {
-		lexpense := []expn.Expense{
-			{
-				ID:     1,
-				Title:  "apple smoothie",
-				Amount: 89,
-				Note:   "no discount",
-				Tags:   []string{"beverage"},
-			},
-			{
-				ID:     2,
-				Title:  "iPhone 14 Pro Max 1TB",
-				Amount: 66900,
-				Note:   "birthday gift from my love",
-				Tags:   []string{"gadget"},
-			},
-		}
-
-		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, note, tags from expenses`)).
+		var id int64 = 1
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(id, alice.ID).WillReturnRows(lockRows(id, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET deleted_at=now(), updated_at=now(), version=version+1 WHERE id=$1 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at, version")).
+			WithArgs(id).
 			WillReturnRows(
-				sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-					AddRow(lexpense[0].ID, lexpense[0].Title, lexpense[0].Amount, lexpense[0].Note, pq.Array(lexpense[0].Tags)).
-					AddRow(lexpense[1].ID, lexpense[1].Title, lexpense[1].Amount, lexpense[1].Note, pq.Array(lexpense[1].Tags)),
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "deleted_at", "version"}).
+					AddRow(1, "strawberry smoothie", 7900, "THB", "no discount", pq.Array([]string{"food"}), at, at, at, at, 2),
 			)
+		expectRecord(mock, id, 2, expn.ActionDelete)
+		mock.ExpectCommit()
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
-		got, err := expense.List(ctx)
+		err := expense.Delete(ctx, id)
 
 		if err := mock.ExpectationsWereMet(); err != nil {
 			t.Errorf("there were unfulfilled expectations: %s", err)
 		}
 
 		assert.NoError(t, err)
-		assert.NotEmpty(t, got)
-		assert.Equal(t, 2, len(got))
-		assert.NotEmpty(t, got[0].ID)
-		assert.NotEmpty(t, got[1].ID)
-		assert.Equal(t, lexpense[0].Tags, got[0].Tags)
+	})
+
+	t.Run("Error no row", func(t *testing.T) {
+		var id int64 = 1
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(id, alice.ID).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		err := expense.Delete(ctx, id)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrNoExpense)
 	})
 
 	t.Run("Some error", func(t *testing.T) {
+		var id int64 = 1
 		errwant := errors.New("some error")
 
-		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, note, tags from expenses`)).
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(id, alice.ID).
 			WillReturnError(errwant)
+		mock.ExpectRollback()
 
-		ctx := context.Background()
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		err := expense.Delete(ctx, id)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, errwant)
+	})
+}
+
+func TestTrashExpenses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		deletedAt := time.Date(2022, 11, 10, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at from expenses where owner_id=COALESCE($1, owner_id) AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id`)).
+			WithArgs(alice.ID).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "deleted_at"}).
+					AddRow(1, "apple smoothie", 8900, "THB", "no discount", pq.Array([]string{"beverage"}), at, at, at, deletedAt),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Trash(ctx)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(got))
+		if assert.NotNil(t, got[0].DeletedAt) {
+			assert.Equal(t, deletedAt, *got[0].DeletedAt)
+		}
+	})
+
+	t.Run("Some error", func(t *testing.T) {
+		errwant := errors.New("some error")
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at from expenses where owner_id=COALESCE($1, owner_id) AND deleted_at IS NOT NULL`)).
+			WillReturnError(errwant)
+
+		ctx := auth.NewContext(context.Background(), alice)
 		expense, _ := expn.NewService(ctx, db)
 
-		got, err := expense.List(ctx)
+		got, err := expense.Trash(ctx)
 
 		if err := mock.ExpectationsWereMet(); err != nil {
 			t.Errorf("there were unfulfilled expectations: %s", err)
@@ -369,3 +541,246 @@ func TestListExpenses(t *testing.T) {
 		assert.Equal(t, 0, len(got))
 	})
 }
+
+func TestRestoreExpense(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		var id int64 = 1
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NOT NULL FOR UPDATE")).
+			WithArgs(id, alice.ID).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "deleted_at", "version"}).
+					AddRow(1, "apple smoothie", 8900, "THB", "no discount", pq.Array([]string{"beverage"}), at, at, at, at, 2),
+			)
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET deleted_at=NULL, updated_at=now(), version=version+1 WHERE id=$1 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version")).
+			WithArgs(id).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}).
+					AddRow(1, "apple smoothie", 8900, "THB", "no discount", pq.Array([]string{"beverage"}), at, at, at, 3),
+			)
+		expectRecord(mock, id, 3, expn.ActionRestore)
+		expectBudgets(mock, id, "beverage")
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Restore(ctx, id)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, id, got.ID)
+		assert.Nil(t, got.DeletedAt)
+	})
+
+	t.Run("Error no row", func(t *testing.T) {
+		var id int64 = 1
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NOT NULL FOR UPDATE")).
+			WithArgs(id, alice.ID).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Restore(ctx, id)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrNoExpense)
+		assert.Equal(t, expn.Expense{}, got)
+	})
+}
+
+func TestPurgeExpenses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		before := time.Date(2022, 11, 10, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM expenses WHERE deleted_at IS NOT NULL AND deleted_at < $1")).
+			WithArgs(before).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Purge(ctx, before)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), got)
+	})
+}
+
+func TestPatchExpense(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		var id int64 = 1
+		amount := "90"
+		note := ""
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(id, alice.ID).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "deleted_at", "version"}).
+					AddRow(1, "strawberry smoothie", 7900, "USD", "no discount", pq.Array([]string{"food", "beverage"}), at, at, at, nil, 1),
+			)
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET amount=$1, currency=$2, note=$3, updated_at=now(), version=version+1 WHERE id=$4 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version")).
+			WithArgs(9000, "USD", note, id).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}).
+					AddRow(1, "strawberry smoothie", 9000, "USD", "", pq.Array([]string{"food", "beverage"}), at, at, at, 2),
+			)
+		expectRecord(mock, id, 2, expn.ActionUpdate)
+		expectBudgets(mock, id, "food", "beverage")
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Patch(ctx, id, expn.Patch{Amount: &amount, Note: &note})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, "strawberry smoothie", got.Title)
+		assert.Equal(t, expn.Money{Minor: 9000, Currency: "USD"}, got.Amount)
+		assert.Equal(t, note, got.Note)
+	})
+
+	t.Run("Currency too precise for amount", func(t *testing.T) {
+		var id int64 = 1
+		currency := "JPY"
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(id, alice.ID).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "deleted_at", "version"}).
+					AddRow(1, "strawberry smoothie", 150, "USD", "", pq.Array([]string{}), at, at, at, nil, 1),
+			)
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.Patch(ctx, id, expn.Patch{Currency: &currency})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrInvalidAmount)
+	})
+
+	t.Run("Error no row", func(t *testing.T) {
+		var id int64 = 1
+		title := "apple smoothie"
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(id, alice.ID).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Patch(ctx, id, expn.Patch{Title: &title})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrNoExpense)
+		assert.Equal(t, expn.Expense{}, got)
+	})
+}
+
+func TestPatchJSONExpense(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		var id int64 = 1
+		ops, _ := expn.ParseJSONPatch([]byte(`[{"op": "add", "path": "/tags/-", "value": "dessert"}]`))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(id, alice.ID).WillReturnRows(lockRows(id, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET title=$1, amount=$2, currency=$3, note=$4, tags=$5, spent_at=COALESCE($6, spent_at), updated_at=now(), version=version+1 WHERE id=$7 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version")).
+			WithArgs("strawberry smoothie", 7900, "THB", "no discount", pq.Array([]string{"food", "dessert"}), at, id).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}).
+					AddRow(1, "strawberry smoothie", 7900, "THB", "no discount", pq.Array([]string{"food", "dessert"}), at, at, at, 2),
+			)
+		expectTags(mock, id, "food", "dessert")
+		expectRecord(mock, id, 2, expn.ActionUpdate)
+		expectBudgets(mock, id, "food", "dessert")
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.PatchJSON(ctx, id, ops)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"food", "dessert"}, got.Tags)
+	})
+
+	t.Run("Test failed", func(t *testing.T) {
+		var id int64 = 1
+		ops, _ := expn.ParseJSONPatch([]byte(`[{"op": "test", "path": "/amount", "value": 1}]`))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(id, alice.ID).WillReturnRows(lockRows(id, 1))
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.PatchJSON(ctx, id, ops)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrPatchTestFailed)
+	})
+}
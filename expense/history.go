@@ -0,0 +1,229 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/dakeeChv/assessment/auth"
+)
+
+var ErrNoRevision = errors.New("no revision")
+
+// The actions recorded in the history of an expense.
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionRevert  = "revert"
+)
+
+// Change is the value of a field before and after a revision, From is nil when the expense is created.
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Revision is a write of an expense, numbered by the version it made.
+type Revision struct {
+	Revision int64             `json:"revision"`
+	Action   string            `json:"action"`
+	Actor    string            `json:"actor"`
+	At       time.Time         `json:"at"`
+	Changes  map[string]Change `json:"changes"`
+	// RevertedTo is the revision a revert restored.
+	RevertedTo *int64 `json:"reverted_to,omitempty"`
+}
+
+// fields are the tracked fields of an expense as its json writes them.
+func fields(e Expense) map[string]interface{} {
+	tags := e.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	var deleted interface{}
+	if e.DeletedAt != nil {
+		deleted = e.DeletedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return map[string]interface{}{
+		"title":      e.Title,
+		"amount":     e.Amount.String(),
+		"currency":   e.Amount.Currency,
+		"note":       e.Note,
+		"tags":       tags,
+		"spent_at":   e.SpentAt.UTC().Format(time.RFC3339Nano),
+		"deleted_at": deleted,
+	}
+}
+
+// diff returns the fields which differ between before and after, every field set when before is nil.
+func diff(before *Expense, after Expense) map[string]Change {
+	to := fields(after)
+	out := make(map[string]Change)
+	if before == nil {
+		for name, v := range to {
+			if v != nil {
+				out[name] = Change{To: v}
+			}
+		}
+		return out
+	}
+	from := fields(*before)
+	for name, v := range to {
+		if !reflect.DeepEqual(from[name], v) {
+			out[name] = Change{From: from[name], To: v}
+		}
+	}
+	return out
+}
+
+// record adds the revision after.Version of an expense to its history, in the transaction of the write.
+func (s *Service) record(ctx context.Context, db preparer, action string, before *Expense, after Expense, revertedTo *int64) error {
+	p, _ := auth.FromContext(ctx)
+	changes, err := json.Marshal(diff(before, after))
+	if err != nil {
+		return fmt.Errorf("record(): marshal changes: %w", err)
+	}
+	snapshot, err := json.Marshal(after)
+	if err != nil {
+		return fmt.Errorf("record(): marshal snapshot: %w", err)
+	}
+
+	stmt, err := db.PrepareContext(ctx, `INSERT INTO expense_history(expense_id, revision, actor, action, changes, snapshot, reverted_to) VALUES($1, $2, $3, $4, $5, $6, $7)`)
+	if err != nil {
+		return fmt.Errorf("record(): db prepare context failure: %w", err)
+	}
+	if _, err := stmt.ExecContext(ctx, after.ID, after.Version, p.ID, action, changes, snapshot, revertedTo); err != nil {
+		return fmt.Errorf("record(): db exec: %w", err)
+	}
+	return nil
+}
+
+// lock reads an expense of the caller for update, one in the trash when deleted.
+func lock(ctx context.Context, tx *sql.Tx, id int64, uid interface{}, deleted bool) (Expense, error) {
+	cond := "deleted_at IS NULL"
+	if deleted {
+		cond = "deleted_at IS NOT NULL"
+	}
+	query := `SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND ` + cond + ` FOR UPDATE`
+
+	var out Expense
+	err := tx.QueryRowContext(ctx, query, id, uid).Scan(&out.ID, &out.Title, &out.Amount.Minor, &out.Amount.Currency, &out.Note, pq.Array(&out.Tags), &out.SpentAt, &out.CreatedAt, &out.UpdatedAt, &out.DeletedAt, &out.Version)
+	if err == sql.ErrNoRows {
+		return Expense{}, ErrNoExpense
+	}
+	if err != nil {
+		return Expense{}, fmt.Errorf("lock(): db scan row: %w", err)
+	}
+	return out, nil
+}
+
+// overwrite sets the fields of an expense locked by the transaction to those of in, making a new version.
+func overwrite(ctx context.Context, tx *sql.Tx, in Expense) (Expense, error) {
+	query := `UPDATE expenses SET title=$1, amount=$2, currency=$3, note=$4, tags=$5, spent_at=COALESCE($6, spent_at), updated_at=now(), version=version+1 WHERE id=$7 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version`
+
+	var out Expense
+	err := tx.QueryRowContext(ctx, query, in.Title, in.Amount.Minor, in.Amount.Currency, in.Note, pq.Array(in.Tags), spentAt(in), in.ID).Scan(&out.ID, &out.Title, &out.Amount.Minor, &out.Amount.Currency, &out.Note, pq.Array(&out.Tags), &out.SpentAt, &out.CreatedAt, &out.UpdatedAt, &out.Version)
+	if err != nil {
+		return Expense{}, fmt.Errorf("overwrite(): db scan row: %w", err)
+	}
+	if err := syncTags(ctx, tx, out.ID, out.Tags); err != nil {
+		return Expense{}, err
+	}
+	return out, nil
+}
+
+// History lists the revisions of an expense, oldest first. Expenses written before the history was kept
+// start with the first revision made since.
+func (s *Service) History(ctx context.Context, id int64) ([]Revision, error) {
+	uid, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT h.revision, h.action, h.actor, h.changed_at, h.changes, h.reverted_to from expense_history h JOIN expenses e ON e.id = h.expense_id where h.expense_id=$1 AND e.owner_id=COALESCE($2, e.owner_id) ORDER BY h.revision`
+
+	rows, err := s.db.QueryContext(ctx, query, id, uid)
+	if err != nil {
+		return nil, fmt.Errorf("History(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Revision, 0)
+	for rows.Next() {
+		var r Revision
+		var changes []byte
+		if err := rows.Scan(&r.Revision, &r.Action, &r.Actor, &r.At, &changes, &r.RevertedTo); err != nil {
+			return nil, fmt.Errorf("History(): db scan row: %w", err)
+		}
+		if err := json.Unmarshal(changes, &r.Changes); err != nil {
+			return nil, fmt.Errorf("History(): unmarshal changes: %w", err)
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("History(): db rows: %w", err)
+	}
+
+	if len(out) == 0 {
+		if _, err := s.Get(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Revert sets an expense back to how it was at revision to, as a new revision.
+func (s *Service) Revert(ctx context.Context, id, to int64) (Expense, error) {
+	uid, err := scope(ctx)
+	if err != nil {
+		return Expense{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Expense{}, fmt.Errorf("Revert(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, id, uid, false)
+	if err != nil {
+		return Expense{}, err
+	}
+
+	var snapshot []byte
+	err = tx.QueryRowContext(ctx, `SELECT snapshot from expense_history where expense_id=$1 AND revision=$2`, id, to).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return Expense{}, ErrNoRevision
+	}
+	if err != nil {
+		return Expense{}, fmt.Errorf("Revert(): db scan row: %w", err)
+	}
+	var in Expense
+	if err := json.Unmarshal(snapshot, &in); err != nil {
+		return Expense{}, fmt.Errorf("Revert(): unmarshal snapshot: %w", err)
+	}
+	in.ID = id
+
+	out, err := overwrite(ctx, tx, in)
+	if err != nil {
+		return Expense{}, err
+	}
+	if err := s.record(ctx, tx, ActionRevert, &before, out, &to); err != nil {
+		return Expense{}, err
+	}
+	if out.Overspent, err = s.overspent(ctx, tx, &before, out); err != nil {
+		return Expense{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Expense{}, fmt.Errorf("Revert(): db commit: %w", err)
+	}
+	return out, nil
+}
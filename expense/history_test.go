@@ -0,0 +1,196 @@
+package expense_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// lockQuery is the read of an expense for update which every write of one starts with.
+var lockQuery = regexp.QuoteMeta("SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL FOR UPDATE")
+
+// lockRows is a strawberry smoothie of alice as it is locked at version.
+func lockRows(id, version int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "deleted_at", "version"}).
+		AddRow(id, "strawberry smoothie", 7900, "THB", "no discount", pq.Array([]string{"food"}), at, at, at, nil, version)
+}
+
+// expectRecord expects a write of alice to add the revision of an expense to its history.
+func expectRecord(mock sqlmock.Sqlmock, id, revision int64, action string) {
+	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO expense_history(expense_id, revision, actor, action, changes, snapshot, reverted_to) VALUES($1, $2, $3, $4, $5, $6, $7)")).
+		ExpectExec().
+		WithArgs(id, revision, alice.ID, action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// jsonArg matches an argument holding the same json document.
+type jsonArg string
+
+func (a jsonArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+	var want, got interface{}
+	if json.Unmarshal([]byte(a), &want) != nil || json.Unmarshal(b, &got) != nil {
+		return false
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+func TestHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	query := regexp.QuoteMeta("SELECT h.revision, h.action, h.actor, h.changed_at, h.changes, h.reverted_to from expense_history h JOIN expenses e ON e.id = h.expense_id where h.expense_id=$1 AND e.owner_id=COALESCE($2, e.owner_id) ORDER BY h.revision")
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(query).
+			WithArgs(int64(1), alice.ID).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"revision", "action", "actor", "changed_at", "changes", "reverted_to"}).
+					AddRow(1, "create", "alice", at, []byte(`{"title": {"from": null, "to": "strawberry smoothie"}}`), nil).
+					AddRow(2, "update", "alice", at, []byte(`{"title": {"from": "strawberry smoothie", "to": "apple smoothie"}}`), nil).
+					AddRow(3, "revert", "alice", at, []byte(`{"title": {"from": "apple smoothie", "to": "strawberry smoothie"}}`), 1),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.History(ctx, 1)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		if assert.Len(t, got, 3) {
+			assert.Equal(t, expn.Change{From: "strawberry smoothie", To: "apple smoothie"}, got[1].Changes["title"])
+			assert.Nil(t, got[1].RevertedTo)
+			if assert.NotNil(t, got[2].RevertedTo) {
+				assert.Equal(t, int64(1), *got[2].RevertedTo)
+			}
+		}
+	})
+
+	t.Run("Error no expense", func(t *testing.T) {
+		mock.ExpectQuery(query).
+			WithArgs(int64(2), alice.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"revision", "action", "actor", "changed_at", "changes", "reverted_to"}))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version from expenses where id=$1")).
+			WithArgs(int64(2), alice.ID).
+			WillReturnError(sql.ErrNoRows)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.History(ctx, 2)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrNoExpense)
+	})
+}
+
+func TestRevert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	snapshotQuery := regexp.QuoteMeta("SELECT snapshot from expense_history where expense_id=$1 AND revision=$2")
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(int64(1), alice.ID).WillReturnRows(lockRows(1, 3))
+		mock.ExpectQuery(snapshotQuery).
+			WithArgs(int64(1), int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"snapshot"}).
+				AddRow([]byte(`{"id": 1, "title": "apple smoothie", "amount": "89.00", "currency": "THB", "note": "", "tags": ["beverage"], "spent_at": "2022-11-10T09:30:00Z"}`)))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET title=$1, amount=$2, currency=$3, note=$4, tags=$5, spent_at=COALESCE($6, spent_at), updated_at=now(), version=version+1 WHERE id=$7 RETURNING id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version")).
+			WithArgs("apple smoothie", 8900, "THB", "", pq.Array([]string{"beverage"}), at, int64(1)).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}).
+					AddRow(1, "apple smoothie", 8900, "THB", "", pq.Array([]string{"beverage"}), at, at, at, 4),
+			)
+		expectTags(mock, 1, "beverage")
+		mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO expense_history")).
+			ExpectExec().
+			WithArgs(int64(1), int64(4), alice.ID, expn.ActionRevert, jsonArg(`{
+				"title": {"from": "strawberry smoothie", "to": "apple smoothie"},
+				"amount": {"from": "79.00", "to": "89.00"},
+				"note": {"from": "no discount", "to": ""},
+				"tags": {"from": ["food"], "to": ["beverage"]}
+			}`), sqlmock.AnyArg(), int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectBudgets(mock, 1, "beverage")
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Revert(ctx, 1, 1)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, "apple smoothie", got.Title)
+		assert.Equal(t, int64(4), got.Version)
+	})
+
+	t.Run("Error no revision", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(int64(1), alice.ID).WillReturnRows(lockRows(1, 3))
+		mock.ExpectQuery(snapshotQuery).WithArgs(int64(1), int64(9)).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.Revert(ctx, 1, 9)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrNoRevision)
+	})
+
+	t.Run("Some error", func(t *testing.T) {
+		errwant := errors.New("some error")
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(int64(1), alice.ID).WillReturnError(errwant)
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.Revert(ctx, 1, 1)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, errwant)
+	})
+}
@@ -0,0 +1,291 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidImport  = errors.New("invalid import")
+	ErrImportRejected = errors.New("import rejected")
+)
+
+// MaxImportRows is the most rows a single import may hold.
+const MaxImportRows = 50000
+
+// Mapping maps the fields of an expense to the CSV columns holding them, e.g. {"title": "Description"}.
+// The fields are title, amount, currency, note, tags and spent_at, title and amount must be mapped.
+type Mapping map[string]string
+
+// DefaultMapping reads the columns written by ExportCSV.
+var DefaultMapping = Mapping{
+	"title":    "title",
+	"amount":   "amount",
+	"currency": "currency",
+	"note":     "note",
+	"tags":     "tags",
+	"spent_at": "spent_at",
+}
+
+// ImportOptions describes the CSV file of an import.
+type ImportOptions struct {
+	// Mapping is DefaultMapping when nil, whose optional columns may then be left out of the file.
+	Mapping Mapping
+	// Comma is the delimiter, ',' by default.
+	Comma rune
+	// Currency is the currency of the rows without one, DefaultCurrency by default.
+	Currency string
+	// DateFormat is the Go layout of spent_at, RFC 3339 or YYYY-MM-DD by default.
+	DateFormat string
+}
+
+// ImportRow is the expense a CSV line would create, or why it can not.
+type ImportRow struct {
+	Line    int      `json:"line"`
+	Expense *Expense `json:"expense,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// ImportReport tells what an import did, or would do on a dry run.
+type ImportReport struct {
+	DryRun   bool        `json:"dry_run"`
+	Valid    int         `json:"valid"`
+	Invalid  int         `json:"invalid"`
+	Imported int         `json:"imported"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+func (o *ImportOptions) validate() error {
+	for field := range o.Mapping {
+		if _, ok := DefaultMapping[field]; !ok {
+			return fmt.Errorf("%w: unknown field %q in mapping", ErrInvalidImport, field)
+		}
+	}
+	for _, field := range []string{"title", "amount"} {
+		if o.Mapping != nil && o.Mapping[field] == "" {
+			return fmt.Errorf("%w: mapping must name the column of %s", ErrInvalidImport, field)
+		}
+	}
+	if o.Comma == 0 {
+		o.Comma = ','
+	}
+	if !ValidComma(o.Comma) {
+		return fmt.Errorf("%w: %q can not be the delimiter", ErrInvalidImport, o.Comma)
+	}
+	if o.Currency == "" {
+		o.Currency = DefaultCurrency
+	}
+	if _, err := Exponent(o.Currency); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	return nil
+}
+
+// unquoteFormula removes the apostrophe ExportCSV puts before a text which looks like a formula.
+func unquoteFormula(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// ParseImport reads and validates every row of a CSV file with a header line.
+// An error is only returned when the file as a whole can not be read, invalid rows are reported in their ImportRow.
+func ParseImport(r io.Reader, opts ImportOptions) ([]ImportRow, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = opts.Comma
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidImport, err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], bom)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	mapping, optional := opts.Mapping, false
+	if mapping == nil {
+		mapping, optional = DefaultMapping, true
+	}
+	index := make(map[string]int)
+	var missing []string
+	for field, column := range mapping {
+		i, ok := columns[column]
+		if !ok && optional && field != "title" && field != "amount" {
+			continue
+		}
+		if !ok {
+			missing = append(missing, fmt.Sprintf("%q for %s", column, field))
+			continue
+		}
+		index[field] = i
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: header has no column %s", ErrInvalidImport, strings.Join(missing, ", "))
+	}
+
+	var out []ImportRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			out = append(out, ImportRow{Line: perr.Line, Errors: []string{perr.Err.Error()}})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		if len(out) == MaxImportRows {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrInvalidImport, MaxImportRows)
+		}
+		// FieldPos is only valid for a record read without error.
+		line, _ := cr.FieldPos(0)
+		out = append(out, parseRecord(line, record, index, opts))
+	}
+	return out, nil
+}
+
+func parseRecord(line int, record []string, index map[string]int, opts ImportOptions) ImportRow {
+	row := ImportRow{Line: line}
+	value := func(field string) string {
+		i, ok := index[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	e := Expense{
+		Title: unquoteFormula(value("title")),
+		Note:  unquoteFormula(value("note")),
+		Tags:  DecodeTags(unquoteFormula(value("tags"))),
+	}
+	currency := strings.ToUpper(value("currency"))
+	if currency == "" {
+		currency = opts.Currency
+	}
+	if _, err := Exponent(currency); err != nil {
+		row.Errors = append(row.Errors, fmt.Sprintf("currency: %v", err))
+	} else {
+		// a thousands separator is tolerated, e.g. "1,250.00".
+		amount, err := ParseMoney(strings.ReplaceAll(value("amount"), ",", ""), currency)
+		if err != nil {
+			row.Errors = append(row.Errors, fmt.Sprintf("amount: %v", err))
+		}
+		e.Amount = amount
+	}
+	if raw := value("spent_at"); raw != "" {
+		layouts := []string{time.RFC3339, dateLayout}
+		if opts.DateFormat != "" {
+			layouts = []string{opts.DateFormat}
+		}
+		parsed := false
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, raw); err == nil {
+				e.SpentAt, parsed = t, true
+				break
+			}
+		}
+		if !parsed {
+			row.Errors = append(row.Errors, fmt.Sprintf("spent_at: %q does not match %s", raw, strings.Join(layouts, " or ")))
+		}
+	}
+	for _, tag := range e.Tags {
+		if tag == "" {
+			row.Errors = append(row.Errors, "tags: tag must not be empty")
+			break
+		}
+	}
+
+	if len(row.Errors) == 0 {
+		row.Expense = &e
+	}
+	return row
+}
+
+// preparedOnce prepares each statement once for the many writes of a transaction.
+type preparedOnce struct {
+	tx    *sql.Tx
+	stmts map[string]*sql.Stmt
+}
+
+func (p *preparedOnce) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if stmt, ok := p.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := p.tx.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	p.stmts[query] = stmt
+	return stmt, nil
+}
+
+// ImportCSV validates every row of a CSV file and creates all of their expenses in a single transaction,
+// or none of them when a row is invalid, which returns ErrImportRejected with the report.
+// A dry run only validates.
+func (s *Service) ImportCSV(ctx context.Context, r io.Reader, opts ImportOptions, dryRun bool) (ImportReport, error) {
+	rows, err := ParseImport(r, opts)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	report := ImportReport{DryRun: dryRun, Rows: rows}
+	for _, row := range rows {
+		if row.Expense != nil {
+			report.Valid++
+		} else {
+			report.Invalid++
+		}
+	}
+	if report.Rows == nil {
+		report.Rows = []ImportRow{}
+	}
+	if dryRun {
+		return report, nil
+	}
+	if report.Invalid > 0 {
+		return report, fmt.Errorf("%w: %d of %d rows are invalid", ErrImportRejected, report.Invalid, len(rows))
+	}
+	if _, err := owner(ctx); err != nil {
+		return ImportReport{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("ImportCSV(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	prepared := &preparedOnce{tx: tx, stmts: make(map[string]*sql.Stmt)}
+	for i, row := range rows {
+		created, err := s.create(ctx, prepared, *row.Expense)
+		if err != nil {
+			return ImportReport{}, fmt.Errorf("ImportCSV(): line %d: %w", row.Line, err)
+		}
+		report.Rows[i].Expense = &created
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ImportReport{}, fmt.Errorf("ImportCSV(): db commit: %w", err)
+	}
+	report.Imported = len(rows)
+	return report, nil
+}
@@ -0,0 +1,143 @@
+package expense_test
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestImportCSV(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	bank := "Date;Description;Debit;Category\n" +
+		"2022-11-10;ข้าวมันไก่;\"1,250.50\";food|lunch\n" +
+		"2022-11-11;'=coffee;45;\n"
+	mapping := expn.Mapping{"title": "Description", "amount": "Debit", "tags": "Category", "spent_at": "Date"}
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		prep := mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id)`))
+		prep.ExpectQuery().
+			WithArgs("ข้าวมันไก่", 125050, "THB", "", pq.Array([]string{"food", "lunch"}), time.Date(2022, time.November, 10, 0, 0, 0, 0, time.UTC), alice.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+				AddRow(1, "ข้าวมันไก่", 125050, "THB", "", pq.Array([]string{"food", "lunch"}), at, at, at))
+		expectTags(mock, 1, "food", "lunch")
+		history := mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expense_history(expense_id, revision, actor, action, changes, snapshot, reverted_to)`))
+		history.ExpectExec().WithArgs(int64(1), int64(1), alice.ID, expn.ActionCreate, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).WillReturnResult(sqlmock.NewResult(0, 1))
+		prep.ExpectQuery().
+			WithArgs("=coffee", 4500, "THB", "", pq.Array([]string(nil)), time.Date(2022, time.November, 11, 0, 0, 0, 0, time.UTC), alice.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+				AddRow(2, "=coffee", 4500, "THB", "", pq.Array([]string{}), at, at, at))
+		history.ExpectExec().WithArgs(int64(2), int64(1), alice.ID, expn.ActionCreate, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		report, err := expense.ImportCSV(ctx, strings.NewReader(bank), expn.ImportOptions{Mapping: mapping, Comma: ';'}, false)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Imported)
+		if assert.Equal(t, 2, len(report.Rows)) {
+			assert.Equal(t, 3, report.Rows[1].Line)
+			assert.Equal(t, int64(2), report.Rows[1].Expense.ID)
+		}
+	})
+
+	t.Run("Invalid rows write nothing", func(t *testing.T) {
+		file := "\uFEFFtitle,amount,currency,spent_at\n" +
+			"ok,10,THB,2022-11-10T09:30:00Z\n" +
+			"bad,ten,XXX,yesterday\n"
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		report, err := expense.ImportCSV(ctx, strings.NewReader(file), expn.ImportOptions{}, false)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrImportRejected)
+		assert.Equal(t, 1, report.Valid)
+		assert.Equal(t, 1, report.Invalid)
+		assert.Equal(t, 0, report.Imported)
+		assert.Equal(t, 3, report.Rows[1].Line)
+		assert.Nil(t, report.Rows[1].Expense)
+		assert.Equal(t, 2, len(report.Rows[1].Errors))
+	})
+
+	t.Run("Dry run", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		report, err := expense.ImportCSV(ctx, strings.NewReader(bank), expn.ImportOptions{Mapping: mapping, Comma: ';', Currency: "USD"}, true)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.Equal(t, 2, report.Valid)
+		assert.Equal(t, expn.Money{Minor: 125050, Currency: "USD"}, report.Rows[0].Expense.Amount)
+		assert.Equal(t, "=coffee", report.Rows[1].Expense.Title)
+	})
+
+	t.Run("Malformed row", func(t *testing.T) {
+		file := "title,amount\n" +
+			"ok,1\n" +
+			"\"a\"b,2\n"
+
+		for _, dryRun := range []bool{true, false} {
+			ctx := auth.NewContext(context.Background(), alice)
+			expense, _ := expn.NewService(ctx, db)
+
+			report, err := expense.ImportCSV(ctx, strings.NewReader(file), expn.ImportOptions{}, dryRun)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+
+			if dryRun {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, expn.ErrImportRejected)
+			}
+			assert.Equal(t, 1, report.Valid)
+			assert.Equal(t, 1, report.Invalid)
+			assert.Equal(t, 0, report.Imported)
+			if assert.Equal(t, 2, len(report.Rows)) {
+				assert.Equal(t, 3, report.Rows[1].Line)
+				assert.Nil(t, report.Rows[1].Expense)
+				assert.Equal(t, 1, len(report.Rows[1].Errors))
+			}
+		}
+	})
+
+	t.Run("Unmapped column", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.ImportCSV(ctx, strings.NewReader(bank), expn.ImportOptions{Comma: ';'}, true)
+
+		assert.ErrorIs(t, err, expn.ErrInvalidImport)
+	})
+}
@@ -0,0 +1,248 @@
+package expense
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// ListFilter narrows the listed expenses, every non-zero field must match.
+type ListFilter struct {
+	// TagsAny matches expenses having at least one of the tags.
+	TagsAny []string
+	// TagsAll matches expenses having every one of the tags.
+	TagsAll []string
+
+	// Currency matches expenses recorded in the currency.
+	Currency string
+	// MinAmount and MaxAmount only match expenses of their currency.
+	MinAmount *Money
+	MaxAmount *Money
+
+	// Title and Note match a case-insensitive substring.
+	Title string
+	Note  string
+
+	// From and To match expenses spent in [From, To).
+	From *time.Time
+	To   *time.Time
+}
+
+// Validate reports the first inconsistent criteria of the filter.
+func (f ListFilter) Validate() error {
+	if f.Currency != "" {
+		if _, err := Exponent(f.Currency); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+		}
+	}
+	for _, m := range []*Money{f.MinAmount, f.MaxAmount} {
+		if m != nil && f.Currency != "" && m.Currency != f.Currency {
+			return fmt.Errorf("%w: amount range must be in %s", ErrInvalidFilter, f.Currency)
+		}
+	}
+	if f.MinAmount != nil && f.MaxAmount != nil {
+		if f.MinAmount.Currency != f.MaxAmount.Currency {
+			return fmt.Errorf("%w: min_amount and max_amount must be in the same currency", ErrInvalidFilter)
+		}
+		if f.MinAmount.Minor > f.MaxAmount.Minor {
+			return fmt.Errorf("%w: min_amount %v is greater than max_amount %v", ErrInvalidFilter, *f.MinAmount, *f.MaxAmount)
+		}
+	}
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidFilter)
+	}
+	for _, tag := range append(f.TagsAny, f.TagsAll...) {
+		if CanonicalTag(tag) == "" {
+			return fmt.Errorf("%w: tag must not be empty", ErrInvalidFilter)
+		}
+	}
+	return nil
+}
+
+// where accumulates parameterised conditions, user input only ever goes into args.
+type where struct {
+	conds []string
+	args  []interface{}
+}
+
+// add appends a condition, format holds a single %d verb for the placeholder of arg.
+func (w *where) add(format string, arg interface{}) {
+	w.args = append(w.args, arg)
+	w.conds = append(w.conds, fmt.Sprintf(format, len(w.args)))
+}
+
+// arg binds a value and returns its placeholder.
+func (w *where) arg(v interface{}) string {
+	w.args = append(w.args, v)
+	return fmt.Sprintf("$%d", len(w.args))
+}
+
+func (w *where) String() string {
+	return strings.Join(w.conds, " AND ")
+}
+
+func (f ListFilter) apply(w *where) {
+	if len(f.TagsAny) > 0 {
+		w.add("tags && $%d", pq.Array(CanonicalTags(f.TagsAny)))
+	}
+	if len(f.TagsAll) > 0 {
+		w.add("tags @> $%d", pq.Array(CanonicalTags(f.TagsAll)))
+	}
+	currency := f.Currency
+	for _, m := range []*Money{f.MinAmount, f.MaxAmount} {
+		if m != nil && currency == "" {
+			currency = m.Currency
+		}
+	}
+	if currency != "" {
+		w.add("currency = $%d", currency)
+	}
+	if f.MinAmount != nil {
+		w.add("amount >= $%d", f.MinAmount.Minor)
+	}
+	if f.MaxAmount != nil {
+		w.add("amount <= $%d", f.MaxAmount.Minor)
+	}
+	if f.Title != "" {
+		w.add(`title ILIKE $%d ESCAPE '\'`, "%"+escapeLike(f.Title)+"%")
+	}
+	if f.Note != "" {
+		w.add(`note ILIKE $%d ESCAPE '\'`, "%"+escapeLike(f.Note)+"%")
+	}
+	if f.From != nil {
+		w.add("spent_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		w.add("spent_at < $%d", *f.To)
+	}
+}
+
+// filtered returns the conditions selecting the expenses of the filter the caller may see, which are not in the trash.
+func filtered(ctx context.Context, f ListFilter) (*where, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	uid, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &where{}
+	if uid != nil {
+		w.add("owner_id = $%d", uid)
+	}
+	w.conds = append(w.conds, "deleted_at IS NULL")
+	f.apply(w)
+	return w, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// ListOptions selects a page of expenses.
+type ListOptions struct {
+	Filter ListFilter
+	// Sort orders the page, id is always the final tiebreak. Empty means by id.
+	Sort []SortKey
+
+	// Limit is the page size, zero means DefaultPageSize and it is capped at MaxPageSize.
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first page.
+	Cursor string
+
+	// ConvertTo sets Converted on every expense to its amount in the currency.
+	ConvertTo string
+}
+
+// Page is a page of expenses.
+type Page struct {
+	Expenses []Expense
+	// NextCursor is empty on the last page.
+	NextCursor string
+}
+
+// List returns a page of expenses using keyset pagination, so deep pages stay as cheap as the first one.
+func (s *Service) List(ctx context.Context, opts ListOptions) (Page, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	w, err := filtered(ctx, opts.Filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	keys, err := normalizeSort(opts.Sort)
+	if err != nil {
+		return Page{}, err
+	}
+
+	if opts.Cursor != "" {
+		after, err := s.decodeCursor(opts.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		if after.Sort != sortString(keys) || len(after.Keys) != len(keys) {
+			return Page{}, fmt.Errorf("%w: cursor belongs to another sort order", ErrInvalidCursor)
+		}
+		seek(w, keys, after.Keys)
+	}
+	query := fmt.Sprintf(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where %s ORDER BY %s LIMIT %s`, w, orderBy(keys), w.arg(limit+1))
+
+	out := make([]Expense, 0, limit)
+	rows, err := s.db.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return Page{}, fmt.Errorf("List(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var expense Expense
+		err := rows.Scan(&expense.ID, &expense.Title, &expense.Amount.Minor, &expense.Amount.Currency, &expense.Note, pq.Array(&expense.Tags), &expense.SpentAt, &expense.CreatedAt, &expense.UpdatedAt)
+		if err != nil {
+			return Page{}, fmt.Errorf("List(): db scan row: %w", err)
+		}
+		out = append(out, expense)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, fmt.Errorf("List(): db rows: %w", err)
+	}
+
+	page := Page{Expenses: out}
+	if len(out) > limit {
+		page.Expenses = out[:limit]
+		last := page.Expenses[limit-1]
+		next := cursor{Sort: sortString(keys)}
+		for _, key := range keys {
+			next.Keys = append(next.Keys, sortFields[key.Field].value(last))
+		}
+		page.NextCursor, err = s.encodeCursor(next)
+		if err != nil {
+			return Page{}, fmt.Errorf("List(): encode cursor: %w", err)
+		}
+	}
+
+	if opts.ConvertTo != "" {
+		if err := s.convertAll(ctx, page.Expenses, opts.ConvertTo); err != nil {
+			return Page{}, err
+		}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,303 @@
+package expense_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestListExpenses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	lexpense := []expn.Expense{
+		{
+			ID:        1,
+			Title:     "apple smoothie",
+			Amount:    expn.Money{Minor: 8900, Currency: "THB"},
+			Note:      "no discount",
+			Tags:      []string{"beverage"},
+			SpentAt:   at,
+			CreatedAt: at,
+			UpdatedAt: at,
+		},
+		{
+			ID:        2,
+			Title:     "iPhone 14 Pro Max 1TB",
+			Amount:    expn.Money{Minor: 6690000, Currency: "THB"},
+			Note:      "birthday gift from my love",
+			Tags:      []string{"gadget"},
+			SpentAt:   at,
+			CreatedAt: at,
+			UpdatedAt: at,
+		},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where owner_id = $1 AND deleted_at IS NULL ORDER BY id LIMIT $2`)).
+			WithArgs(alice.ID, expn.DefaultPageSize+1).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(lexpense[0].ID, lexpense[0].Title, lexpense[0].Amount.Minor, lexpense[0].Amount.Currency, lexpense[0].Note, pq.Array(lexpense[0].Tags), at, at, at).
+					AddRow(lexpense[1].ID, lexpense[1].Title, lexpense[1].Amount.Minor, lexpense[1].Amount.Currency, lexpense[1].Note, pq.Array(lexpense[1].Tags), at, at, at),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.List(ctx, expn.ListOptions{})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.NotEmpty(t, got.Expenses)
+		assert.Equal(t, 2, len(got.Expenses))
+		assert.NotEmpty(t, got.Expenses[0].ID)
+		assert.NotEmpty(t, got.Expenses[1].ID)
+		assert.Equal(t, lexpense[0].Tags, got.Expenses[0].Tags)
+		assert.Empty(t, got.NextCursor)
+	})
+
+	t.Run("Next page", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db, expn.WithCursorKey([]byte("secret")))
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where owner_id = $1 AND deleted_at IS NULL ORDER BY id LIMIT $2`)).
+			WithArgs(alice.ID, 2).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(lexpense[0].ID, lexpense[0].Title, lexpense[0].Amount.Minor, lexpense[0].Amount.Currency, lexpense[0].Note, pq.Array(lexpense[0].Tags), at, at, at).
+					AddRow(lexpense[1].ID, lexpense[1].Title, lexpense[1].Amount.Minor, lexpense[1].Amount.Currency, lexpense[1].Note, pq.Array(lexpense[1].Tags), at, at, at),
+			)
+
+		first, err := expense.List(ctx, expn.ListOptions{Limit: 1})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(first.Expenses))
+		assert.NotEmpty(t, first.NextCursor)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where owner_id = $1 AND deleted_at IS NULL AND ((id > $2)) ORDER BY id LIMIT $3`)).
+			WithArgs(alice.ID, "1", 2).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(lexpense[1].ID, lexpense[1].Title, lexpense[1].Amount.Minor, lexpense[1].Amount.Currency, lexpense[1].Note, pq.Array(lexpense[1].Tags), at, at, at),
+			)
+
+		second, err := expense.List(ctx, expn.ListOptions{Limit: 1, Cursor: first.NextCursor})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, []expn.Expense{lexpense[1]}, second.Expenses)
+		assert.Empty(t, second.NextCursor)
+	})
+
+	t.Run("Tampered cursor", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		signer, _ := expn.NewService(ctx, db, expn.WithCursorKey([]byte("other secret")))
+		expense, _ := expn.NewService(ctx, db, expn.WithCursorKey([]byte("secret")))
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses`)).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(lexpense[0].ID, lexpense[0].Title, lexpense[0].Amount.Minor, lexpense[0].Amount.Currency, lexpense[0].Note, pq.Array(lexpense[0].Tags), at, at, at).
+					AddRow(lexpense[1].ID, lexpense[1].Title, lexpense[1].Amount.Minor, lexpense[1].Amount.Currency, lexpense[1].Note, pq.Array(lexpense[1].Tags), at, at, at),
+			)
+		forged, err := signer.List(ctx, expn.ListOptions{Limit: 1})
+		assert.NoError(t, err)
+
+		for _, c := range []string{forged.NextCursor, "eyJpZCI6MX0", "not a cursor"} {
+			_, err = expense.List(ctx, expn.ListOptions{Cursor: c})
+			assert.ErrorIs(t, err, expn.ErrInvalidCursor)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Limit capped", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses`)).
+			WithArgs(alice.ID, expn.MaxPageSize+1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}))
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.List(ctx, expn.ListOptions{Limit: expn.MaxPageSize * 10})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(got.Expenses))
+	})
+
+	t.Run("Some error", func(t *testing.T) {
+		errwant := errors.New("some error")
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses`)).
+			WillReturnError(errwant)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.List(ctx, expn.ListOptions{})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, errwant)
+		assert.Equal(t, 0, len(got.Expenses))
+	})
+}
+
+func TestListExpensesFilter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		min, max := expn.Money{Minor: 1000, Currency: "THB"}, expn.Money{Minor: 10000, Currency: "THB"}
+		filter := expn.ListFilter{
+			TagsAny:   []string{"food", "beverage"},
+			TagsAll:   []string{"night"},
+			MinAmount: &min,
+			MaxAmount: &max,
+			Title:     "50%_off",
+			Note:      "promotion",
+		}
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where owner_id = $1 AND deleted_at IS NULL AND tags && $2 AND tags @> $3 AND currency = $4 AND amount >= $5 AND amount <= $6 AND title ILIKE $7 ESCAPE '\' AND note ILIKE $8 ESCAPE '\' ORDER BY id LIMIT $9`)).
+			WithArgs(alice.ID, pq.Array(filter.TagsAny), pq.Array(filter.TagsAll), "THB", min.Minor, max.Minor, `%50\%\_off%`, "%promotion%", expn.DefaultPageSize+1).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(1, "50%_off smoothie", 7900, "THB", "night market promotion", pq.Array([]string{"food", "night"}), at, at, at),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.List(ctx, expn.ListOptions{Filter: filter})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(got.Expenses))
+	})
+
+	t.Run("Date range", func(t *testing.T) {
+		from, to := time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, time.December, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where owner_id = $1 AND deleted_at IS NULL AND spent_at >= $2 AND spent_at < $3 ORDER BY id LIMIT $4`)).
+			WithArgs(alice.ID, from, to, expn.DefaultPageSize+1).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(1, "apple smoothie", 8900, "THB", "no discount", pq.Array([]string{"beverage"}), at, at, at),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.List(ctx, expn.ListOptions{Filter: expn.ListFilter{From: &from, To: &to}})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		if assert.Equal(t, 1, len(got.Expenses)) {
+			assert.Equal(t, at, got.Expenses[0].SpentAt)
+		}
+	})
+
+	t.Run("Invalid amount range", func(t *testing.T) {
+		min, max := expn.Money{Minor: 10000, Currency: "THB"}, expn.Money{Minor: 1000, Currency: "THB"}
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.List(ctx, expn.ListOptions{Filter: expn.ListFilter{MinAmount: &min, MaxAmount: &max}})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrInvalidFilter)
+	})
+}
+
+func TestListExpensesSort(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+		sort, err := expn.ParseSort("-amount,title")
+		assert.NoError(t, err)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where owner_id = $1 AND deleted_at IS NULL ORDER BY amount DESC, title, id LIMIT $2`)).
+			WithArgs(alice.ID, 2).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(2, "iPhone 14 Pro Max 1TB", 6690000, "THB", "birthday gift from my love", pq.Array([]string{"gadget"}), at, at, at).
+					AddRow(1, "apple smoothie", 8900, "THB", "no discount", pq.Array([]string{"beverage"}), at, at, at),
+			)
+
+		first, err := expense.List(ctx, expn.ListOptions{Limit: 1, Sort: sort})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, first.NextCursor)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses where owner_id = $1 AND deleted_at IS NULL AND ((amount < $2) OR (amount = $3 AND title > $4) OR (amount = $5 AND title = $6 AND id > $7)) ORDER BY amount DESC, title, id LIMIT $8`)).
+			WithArgs(alice.ID, "6690000", "6690000", "iPhone 14 Pro Max 1TB", "6690000", "iPhone 14 Pro Max 1TB", "2", 2).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(1, "apple smoothie", 8900, "THB", "no discount", pq.Array([]string{"beverage"}), at, at, at),
+			)
+
+		second, err := expense.List(ctx, expn.ListOptions{Limit: 1, Sort: sort, Cursor: first.NextCursor})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(second.Expenses))
+		assert.Empty(t, second.NextCursor)
+
+		_, err = expense.List(ctx, expn.ListOptions{Limit: 1, Cursor: first.NextCursor})
+		assert.ErrorIs(t, err, expn.ErrInvalidCursor)
+	})
+
+	t.Run("Unknown field", func(t *testing.T) {
+		_, err := expn.ParseSort("-amount,tags")
+
+		assert.ErrorIs(t, err, expn.ErrInvalidSort)
+		assert.Contains(t, err.Error(), "amount, created_at, id, note, spent_at, title, updated_at")
+	})
+}
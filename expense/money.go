@@ -0,0 +1,217 @@
+package expense
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of an amount given without one.
+const DefaultCurrency = "THB"
+
+var (
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrUnknownCurrency = errors.New("unknown currency")
+)
+
+// exponents is the number of minor unit digits of the supported ISO-4217 currencies.
+var exponents = map[string]int{
+	"THB": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CNY": 2,
+	"SGD": 2,
+	"HKD": 2,
+	"MYR": 2,
+	"LAK": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Exponent returns the number of minor unit digits of currency.
+func Exponent(currency string) (int, error) {
+	exp, ok := exponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	return exp, nil
+}
+
+// Money is an exact amount, stored as an integer number of minor units of its currency,
+// e.g. 79.50 THB is 7950 satang.
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+// ParseMoney parses a decimal string such as "79.50" in currency,
+// it rejects amounts with more fractional digits than the currency allows.
+func ParseMoney(s, currency string) (Money, error) {
+	exp, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	raw := s
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || !digits(whole) || !digits(frac) {
+		return Money{}, fmt.Errorf("%w: %q is not a decimal number", ErrInvalidAmount, raw)
+	}
+
+	// trailing zeros do not add precision.
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("%w: %q has more than %d decimal places allowed for %s", ErrInvalidAmount, raw, exp, currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, raw)
+	}
+	if neg {
+		minor = -minor
+	}
+
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the amount as a decimal with the minor unit digits of its currency.
+func (m Money) String() string {
+	exp, err := Exponent(m.Currency)
+	if err != nil {
+		exp = 0
+	}
+
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign = "-"
+	}
+	abs := strconv.FormatUint(uint64(minor), 10)
+	if minor < 0 {
+		abs = strconv.FormatUint(uint64(-(minor+1))+1, 10)
+	}
+	if exp == 0 {
+		return sign + abs
+	}
+	if len(abs) <= exp {
+		abs = strings.Repeat("0", exp-len(abs)+1) + abs
+	}
+	return sign + abs[:len(abs)-exp] + "." + abs[len(abs)-exp:]
+}
+
+// Add returns the exact sum of two amounts of the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: can not add %s to %s", ErrInvalidAmount, o.Currency, m.Currency)
+	}
+	if (o.Minor > 0 && m.Minor > math.MaxInt64-o.Minor) || (o.Minor < 0 && m.Minor < math.MinInt64-o.Minor) {
+		return Money{}, fmt.Errorf("%w: sum is out of range", ErrInvalidAmount)
+	}
+	return Money{Minor: m.Minor + o.Minor, Currency: m.Currency}, nil
+}
+
+// MarshalJSON encodes the amount as a decimal string, so no client parses it into a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON decodes a decimal string or number in DefaultCurrency,
+// or in the currency already set on m.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	s, err := decimalJSON(b)
+	if err != nil {
+		return err
+	}
+	out, err := ParseMoney(s, currency)
+	if err != nil {
+		return err
+	}
+	*m = out
+	return nil
+}
+
+// decimalJSON reads a json string or number as decimal text without going through float64.
+func decimalJSON(b []byte) (string, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+		}
+		return s, nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return "", fmt.Errorf("%w: %s is not a number", ErrInvalidAmount, b)
+	}
+	if strings.ContainsAny(n.String(), "eE") {
+		return "", fmt.Errorf("%w: %s must not use an exponent", ErrInvalidAmount, n)
+	}
+	return n.String(), nil
+}
+
+// marshalWithCurrency encodes v, a struct without json methods holding amount, with the currency
+// of the amount as its own "currency" member. Expenses, budgets and recurring expenses share it.
+func marshalWithCurrency(v interface{}, amount Money) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := json.Marshal(amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	b = bytes.TrimSuffix(b, []byte("}"))
+	if len(b) > 1 {
+		b = append(b, ',')
+	}
+	b = append(b, `"currency":`...)
+	b = append(b, currency...)
+	return append(b, '}'), nil
+}
+
+// unmarshalWithCurrency decodes data into v, a struct without json methods holding amount,
+// reading the amount in the currency member, DefaultCurrency when it is missing.
+func unmarshalWithCurrency(data []byte, v interface{}, amount *Money) error {
+	var aux struct {
+		Currency string `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.Currency == "" {
+		aux.Currency = DefaultCurrency
+	}
+	// the amount is decoded in the currency already set on it.
+	*amount = Money{Currency: aux.Currency}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,115 @@
+package expense_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		currency string
+		want     expn.Money
+		err      error
+	}{
+		{"Whole", "79", "THB", expn.Money{Minor: 7900, Currency: "THB"}, nil},
+		{"Satang", "0.10", "THB", expn.Money{Minor: 10, Currency: "THB"}, nil},
+		{"Trailing zeros", "79.500", "THB", expn.Money{Minor: 7950, Currency: "THB"}, nil},
+		{"Negative", "-1.5", "USD", expn.Money{Minor: -150, Currency: "USD"}, nil},
+		{"No minor unit", "1200", "JPY", expn.Money{Minor: 1200, Currency: "JPY"}, nil},
+		{"Too precise", "79.001", "THB", expn.Money{}, expn.ErrInvalidAmount},
+		{"Too precise yen", "1200.5", "JPY", expn.Money{}, expn.ErrInvalidAmount},
+		{"Not a number", "1e3", "THB", expn.Money{}, expn.ErrInvalidAmount},
+		{"Missing whole", ".5", "THB", expn.Money{}, expn.ErrInvalidAmount},
+		{"Out of range", "92233720368547758.08", "THB", expn.Money{}, expn.ErrInvalidAmount},
+		{"Unknown currency", "1", "XXX", expn.Money{}, expn.ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expn.ParseMoney(tt.in, tt.currency)
+
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "79.00", expn.Money{Minor: 7900, Currency: "THB"}.String())
+	assert.Equal(t, "0.05", expn.Money{Minor: 5, Currency: "THB"}.String())
+	assert.Equal(t, "-1.50", expn.Money{Minor: -150, Currency: "USD"}.String())
+	assert.Equal(t, "1200", expn.Money{Minor: 1200, Currency: "JPY"}.String())
+	assert.Equal(t, "0.001", expn.Money{Minor: 1, Currency: "KWD"}.String())
+	assert.Equal(t, "-92233720368547758.08", expn.Money{Minor: math.MinInt64, Currency: "THB"}.String())
+}
+
+func TestMoneyAdd(t *testing.T) {
+	sum := expn.Money{Currency: "THB"}
+	for i := 0; i < 10; i++ {
+		var err error
+		sum, err = sum.Add(expn.Money{Minor: 10, Currency: "THB"})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "1.00", sum.String())
+
+	_, err := sum.Add(expn.Money{Minor: 1, Currency: "USD"})
+	assert.ErrorIs(t, err, expn.ErrInvalidAmount)
+
+	_, err = expn.Money{Minor: math.MaxInt64, Currency: "THB"}.Add(expn.Money{Minor: 1, Currency: "THB"})
+	assert.ErrorIs(t, err, expn.ErrInvalidAmount)
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var e expn.Expense
+	err := json.Unmarshal([]byte(`{"title":"strawberry smoothie","amount":79.5}`), &e)
+	assert.NoError(t, err)
+	assert.Equal(t, expn.Money{Minor: 7950, Currency: "THB"}, e.Amount)
+
+	err = json.Unmarshal([]byte(`{"amount":"0.30"}`), &e)
+	assert.NoError(t, err)
+	assert.Equal(t, expn.Money{Minor: 30, Currency: "THB"}, e.Amount)
+
+	raw, err := json.Marshal(e.Amount)
+	assert.NoError(t, err)
+	assert.Equal(t, `"0.30"`, string(raw))
+
+	err = json.Unmarshal([]byte(`{"amount":0.001}`), &e)
+	assert.ErrorIs(t, err, expn.ErrInvalidAmount)
+}
+
+func TestAmountCurrencyJSON(t *testing.T) {
+	for name, v := range map[string]interface{}{
+		"Expense":   &expn.Expense{},
+		"Budget":    &expn.Budget{},
+		"Recurring": &expn.Recurring{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := json.Unmarshal([]byte(`{"currency":"JPY","amount":"1200"}`), v)
+			assert.NoError(t, err)
+
+			raw, err := json.Marshal(v)
+			assert.NoError(t, err)
+			var got struct {
+				Amount   string `json:"amount"`
+				Currency string `json:"currency"`
+			}
+			assert.NoError(t, json.Unmarshal(raw, &got))
+			assert.Equal(t, "1200", got.Amount)
+			assert.Equal(t, "JPY", got.Currency)
+
+			err = json.Unmarshal([]byte(`{"amount":"12.50"}`), v)
+			assert.NoError(t, err)
+			raw, _ = json.Marshal(v)
+			assert.NoError(t, json.Unmarshal(raw, &got))
+			assert.Equal(t, "12.50", got.Amount)
+			assert.Equal(t, expn.DefaultCurrency, got.Currency)
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package expense
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidPatch    = errors.New("invalid patch")
+	ErrPatchTestFailed = errors.New("patch test operation failed")
+)
+
+// Patch is a partial update of an expense, only the non-nil fields are written.
+type Patch struct {
+	Title *string
+	// Amount is a decimal in Currency, or in the current currency of the expense when Currency is nil.
+	Amount   *string
+	Currency *string
+	Note     *string
+	Tags     *[]string
+	SpentAt  *time.Time
+}
+
+// IsEmpty reports whether the patch changes nothing.
+func (p Patch) IsEmpty() bool {
+	return p.Title == nil && p.Amount == nil && p.Currency == nil && p.Note == nil && p.Tags == nil && p.SpentAt == nil
+}
+
+// MergePatch parses a JSON Merge Patch (RFC 7396) document.
+// A member set to null resets the field to its zero value, or spent_at to now.
+func MergePatch(doc []byte) (Patch, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &members); err != nil {
+		return Patch{}, fmt.Errorf("%w: merge patch must be a json object", ErrInvalidPatch)
+	}
+
+	var p Patch
+	for name, raw := range members {
+		null := bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+		switch name {
+		case "title":
+			var v string
+			if !null && json.Unmarshal(raw, &v) != nil {
+				return Patch{}, fmt.Errorf("%w: title must be a string", ErrInvalidPatch)
+			}
+			p.Title = &v
+		case "amount":
+			v := "0"
+			if !null {
+				var err error
+				if v, err = decimalJSON(raw); err != nil {
+					return Patch{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+				}
+			}
+			p.Amount = &v
+		case "currency":
+			v := DefaultCurrency
+			if !null && json.Unmarshal(raw, &v) != nil {
+				return Patch{}, fmt.Errorf("%w: currency must be a string", ErrInvalidPatch)
+			}
+			p.Currency = &v
+		case "note":
+			var v string
+			if !null && json.Unmarshal(raw, &v) != nil {
+				return Patch{}, fmt.Errorf("%w: note must be a string", ErrInvalidPatch)
+			}
+			p.Note = &v
+		case "tags":
+			v := []string{}
+			if !null && json.Unmarshal(raw, &v) != nil {
+				return Patch{}, fmt.Errorf("%w: tags must be an array of string", ErrInvalidPatch)
+			}
+			p.Tags = &v
+		case "spent_at":
+			v := time.Now()
+			if !null && json.Unmarshal(raw, &v) != nil {
+				return Patch{}, fmt.Errorf("%w: spent_at must be an RFC 3339 time", ErrInvalidPatch)
+			}
+			p.SpentAt = &v
+		default:
+			return Patch{}, fmt.Errorf("%w: member %q can not be patched", ErrInvalidPatch, name)
+		}
+	}
+
+	return p, nil
+}
+
+// Operation is a single JSON Patch (RFC 6902) operation.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ParseJSONPatch parses a JSON Patch (RFC 6902) document.
+func ParseJSONPatch(doc []byte) ([]Operation, error) {
+	var ops []Operation
+	if err := json.Unmarshal(doc, &ops); err != nil {
+		return nil, fmt.Errorf("%w: json patch must be an array of operations", ErrInvalidPatch)
+	}
+
+	for i, op := range ops {
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("%w: operation %d: missing value", ErrInvalidPatch, i)
+			}
+		case "move", "copy":
+			if _, err := splitPointer(op.From); err != nil {
+				return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%w: operation %d: unknown op %q", ErrInvalidPatch, i, op.Op)
+		}
+		if _, err := splitPointer(op.Path); err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+		}
+	}
+
+	return ops, nil
+}
+
+// ApplyJSONPatch applies the operations to the JSON representation of in.
+// The id can not be changed and removed members are reset to their zero value.
+func ApplyJSONPatch(in Expense, ops []Operation) (Expense, error) {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return Expense{}, fmt.Errorf("ApplyJSONPatch(): json marshal: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return Expense{}, fmt.Errorf("ApplyJSONPatch(): json unmarshal: %w", err)
+	}
+
+	for i, op := range ops {
+		doc, err = applyOperation(doc, op)
+		if err != nil {
+			return Expense{}, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	members, ok := doc.(map[string]interface{})
+	if !ok {
+		return Expense{}, fmt.Errorf("%w: patched document must be an object", ErrInvalidPatch)
+	}
+	for name := range members {
+		switch name {
+		case "id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at":
+		default:
+			return Expense{}, fmt.Errorf("%w: member %q can not be patched", ErrInvalidPatch, name)
+		}
+	}
+
+	raw, err = json.Marshal(doc)
+	if err != nil {
+		return Expense{}, fmt.Errorf("ApplyJSONPatch(): json marshal: %w", err)
+	}
+	var out Expense
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return Expense{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	if out.ID != in.ID {
+		return Expense{}, fmt.Errorf("%w: id can not be changed", ErrInvalidPatch)
+	}
+	if !out.CreatedAt.Equal(in.CreatedAt) || !out.UpdatedAt.Equal(in.UpdatedAt) {
+		return Expense{}, fmt.Errorf("%w: created_at and updated_at are maintained by the server", ErrInvalidPatch)
+	}
+	if out.Tags == nil {
+		out.Tags = []string{}
+	}
+
+	return out, nil
+}
+
+func applyOperation(doc interface{}, op Operation) (interface{}, error) {
+	path, _ := splitPointer(op.Path)
+
+	switch op.Op {
+	case "add", "replace":
+		var value interface{}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: invalid value", ErrInvalidPatch)
+		}
+		if op.Op == "replace" {
+			if _, err := lookup(doc, path); err != nil {
+				return nil, err
+			}
+			var err error
+			if doc, err = remove(doc, path); err != nil {
+				return nil, err
+			}
+		}
+		return add(doc, path, value)
+	case "remove":
+		return remove(doc, path)
+	case "move", "copy":
+		from, _ := splitPointer(op.From)
+		value, err := lookup(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, fmt.Errorf("%w: can not move %q into its own child", ErrInvalidPatch, op.From)
+			}
+			if doc, err = remove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+		return add(doc, path, value)
+	case "test":
+		var want interface{}
+		if err := json.Unmarshal(op.Value, &want); err != nil {
+			return nil, fmt.Errorf("%w: invalid value", ErrInvalidPatch)
+		}
+		got, err := lookup(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		gotRaw, _ := json.Marshal(got)
+		wantRaw, _ := json.Marshal(want)
+		if !bytes.Equal(gotRaw, wantRaw) {
+			return nil, fmt.Errorf("%w: %s is not %s", ErrPatchTestFailed, op.Path, op.Value)
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op.Op)
+}
+
+// splitPointer splits a JSON Pointer (RFC 6901) into its unescaped reference tokens.
+func splitPointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("json pointer %q must start with /", ptr)
+	}
+
+	tokens := strings.Split(ptr[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func lookup(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidPatch, token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidPatch, token)
+		}
+	}
+	return doc, nil
+}
+
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := lookup(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		i := len(node)
+		if last != "-" {
+			if i, err = arrayIndex(last, len(node)); err != nil {
+				return nil, err
+			}
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return replaceParent(doc, path[:len(path)-1], node)
+	}
+
+	return nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidPatch, last)
+}
+
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: can not remove the whole document", ErrInvalidPatch)
+	}
+
+	parent, err := lookup(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := node[last]; !ok {
+			return nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidPatch, last)
+		}
+		delete(node, last)
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node[:i:i], node[i+1:]...)
+		return replaceParent(doc, path[:len(path)-1], node)
+	}
+
+	return nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidPatch, last)
+}
+
+// replaceParent stores a re-allocated array back at path.
+func replaceParent(doc interface{}, path []string, value []interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	grand, err := lookup(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := grand.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		i, _ := arrayIndex(last, len(node)-1)
+		node[i] = value
+	}
+	return doc, nil
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+	return i, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	raw, _ := json.Marshal(value)
+	var out interface{}
+	_ = json.Unmarshal(raw, &out)
+	return out
+}
@@ -0,0 +1,128 @@
+package expense_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestMergePatch(t *testing.T) {
+	t.Run("Only present members", func(t *testing.T) {
+		got, err := expn.MergePatch([]byte(`{"amount": 90.50}`))
+
+		assert.NoError(t, err)
+		if assert.NotNil(t, got.Amount) {
+			assert.Equal(t, "90.50", *got.Amount)
+		}
+		assert.Nil(t, got.Currency)
+		assert.Nil(t, got.Title)
+		assert.Nil(t, got.Note)
+		assert.Nil(t, got.Tags)
+	})
+
+	t.Run("Null resets member", func(t *testing.T) {
+		got, err := expn.MergePatch([]byte(`{"note": null, "tags": null}`))
+
+		assert.NoError(t, err)
+		if assert.NotNil(t, got.Note) && assert.NotNil(t, got.Tags) {
+			assert.Equal(t, "", *got.Note)
+			assert.Equal(t, []string{}, *got.Tags)
+		}
+	})
+
+	t.Run("Spent at", func(t *testing.T) {
+		got, err := expn.MergePatch([]byte(`{"spent_at": "2022-11-10T09:30:00+07:00"}`))
+
+		assert.NoError(t, err)
+		if assert.NotNil(t, got.SpentAt) {
+			assert.Equal(t, time.Date(2022, time.November, 10, 2, 30, 0, 0, time.UTC), got.SpentAt.UTC())
+		}
+	})
+
+	t.Run("Unknown member", func(t *testing.T) {
+		_, err := expn.MergePatch([]byte(`{"id": 2}`))
+
+		assert.ErrorIs(t, err, expn.ErrInvalidPatch)
+	})
+
+	t.Run("Invalid type", func(t *testing.T) {
+		_, err := expn.MergePatch([]byte(`{"amount": true}`))
+
+		assert.ErrorIs(t, err, expn.ErrInvalidPatch)
+	})
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	in := expn.Expense{
+		ID:     1,
+		Title:  "strawberry smoothie",
+		Amount: expn.Money{Minor: 7900, Currency: "THB"},
+		Note:   "night market promotion discount 10 bath",
+		Tags:   []string{"food", "beverage"},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		ops, err := expn.ParseJSONPatch([]byte(`[
+			{"op": "test", "path": "/amount", "value": "79.00"},
+			{"op": "replace", "path": "/amount", "value": 90},
+			{"op": "remove", "path": "/tags/0"},
+			{"op": "add", "path": "/tags/-", "value": "dessert"},
+			{"op": "copy", "from": "/title", "path": "/note"}
+		]`))
+		assert.NoError(t, err)
+
+		got, err := expn.ApplyJSONPatch(in, ops)
+
+		assert.NoError(t, err)
+		assert.Equal(t, in.ID, got.ID)
+		assert.Equal(t, expn.Money{Minor: 9000, Currency: "THB"}, got.Amount)
+		assert.Equal(t, []string{"beverage", "dessert"}, got.Tags)
+		assert.Equal(t, in.Title, got.Note)
+		assert.Equal(t, []string{"food", "beverage"}, in.Tags)
+	})
+
+	t.Run("Test failed", func(t *testing.T) {
+		ops, err := expn.ParseJSONPatch([]byte(`[{"op": "test", "path": "/title", "value": "apple"}]`))
+		assert.NoError(t, err)
+
+		_, err = expn.ApplyJSONPatch(in, ops)
+
+		assert.ErrorIs(t, err, expn.ErrPatchTestFailed)
+	})
+
+	t.Run("Change id", func(t *testing.T) {
+		ops, err := expn.ParseJSONPatch([]byte(`[{"op": "replace", "path": "/id", "value": 2}]`))
+		assert.NoError(t, err)
+
+		_, err = expn.ApplyJSONPatch(in, ops)
+
+		assert.ErrorIs(t, err, expn.ErrInvalidPatch)
+	})
+
+	t.Run("Change created_at", func(t *testing.T) {
+		ops, err := expn.ParseJSONPatch([]byte(`[{"op": "replace", "path": "/created_at", "value": "2022-11-10T09:30:00Z"}]`))
+		assert.NoError(t, err)
+
+		_, err = expn.ApplyJSONPatch(in, ops)
+
+		assert.ErrorIs(t, err, expn.ErrInvalidPatch)
+	})
+
+	t.Run("Missing path", func(t *testing.T) {
+		ops, err := expn.ParseJSONPatch([]byte(`[{"op": "remove", "path": "/tags/5"}]`))
+		assert.NoError(t, err)
+
+		_, err = expn.ApplyJSONPatch(in, ops)
+
+		assert.ErrorIs(t, err, expn.ErrInvalidPatch)
+	})
+
+	t.Run("Unknown op", func(t *testing.T) {
+		_, err := expn.ParseJSONPatch([]byte(`[{"op": "merge", "path": "/title"}]`))
+
+		assert.ErrorIs(t, err, expn.ErrInvalidPatch)
+	})
+}
@@ -0,0 +1,322 @@
+package expense
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidRate = errors.New("invalid exchange rate")
+	ErrMissingRate = errors.New("missing exchange rate")
+)
+
+// dateLayout is the layout of a calendar date such as an exchange rate date.
+const dateLayout = "2006-01-02"
+
+// Rate says one unit of Base is worth Value units of Quote from Date on,
+// until a rate with a later date is loaded.
+type Rate struct {
+	Date  time.Time
+	Base  string
+	Quote string
+	// Value is an exact decimal such as "35.1234".
+	Value string
+}
+
+// Conversion is an amount converted into another currency.
+type Conversion struct {
+	Amount   Money  `json:"amount"`
+	Currency string `json:"currency"`
+	Rate     string `json:"rate"`
+	RateDate string `json:"rate_date"`
+}
+
+// MissingRateError lists the currencies and dates for which no exchange rate is loaded.
+type MissingRateError struct {
+	To      string
+	Missing []string
+}
+
+func (e *MissingRateError) Error() string {
+	return fmt.Sprintf("%v into %s: %s", ErrMissingRate, e.To, strings.Join(e.Missing, ", "))
+}
+
+func (e *MissingRateError) Unwrap() error {
+	return ErrMissingRate
+}
+
+// ParseRatesCSV reads rates from a CSV with the header date,base,quote,rate,
+// e.g. "2026-10-16,USD,THB,36.52".
+func ParseRatesCSV(r io.Reader) ([]Rate, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 4
+	cr.TrimLeadingSpace = true
+
+	var out []Rate
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRate, err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "date") {
+			continue
+		}
+
+		rate, err := newRate(record[0], record[1], record[2], record[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		out = append(out, rate)
+	}
+	return out, nil
+}
+
+// ParseRatesECB reads the euro foreign exchange reference rates in the XML format published by the
+// European Central Bank, e.g. a saved copy of eurofxref-hist.xml.
+func ParseRatesECB(r io.Reader) ([]Rate, error) {
+	var doc struct {
+		Days []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string `xml:"currency,attr"`
+				Rate     string `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube>Cube"`
+	}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRate, err)
+	}
+
+	var out []Rate
+	for _, day := range doc.Days {
+		for _, r := range day.Rates {
+			rate, err := newRate(day.Time, "EUR", r.Currency, r.Rate)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, rate)
+		}
+	}
+	return out, nil
+}
+
+func newRate(date, base, quote, value string) (Rate, error) {
+	d, err := time.Parse(dateLayout, strings.TrimSpace(date))
+	if err != nil {
+		return Rate{}, fmt.Errorf("%w: date %q must be YYYY-MM-DD", ErrInvalidRate, date)
+	}
+	base, quote = strings.ToUpper(strings.TrimSpace(base)), strings.ToUpper(strings.TrimSpace(quote))
+	for _, c := range []string{base, quote} {
+		if _, err := Exponent(c); err != nil {
+			return Rate{}, fmt.Errorf("%w: %v", ErrInvalidRate, err)
+		}
+	}
+	// the value is stored as given, so only plain decimals are taken, not the fractions
+	// and exponents big.Rat also reads.
+	value = strings.TrimSpace(value)
+	v, ok := new(big.Rat).SetString(value)
+	if !ok || v.Sign() <= 0 || strings.Trim(value, "0123456789.") != "" || strings.Count(value, ".") > 1 {
+		return Rate{}, fmt.Errorf("%w: rate %q must be a positive decimal", ErrInvalidRate, value)
+	}
+	return Rate{Date: d, Base: base, Quote: quote, Value: value}, nil
+}
+
+// ImportRates stores the rates, replacing a rate already loaded for the same date and currency pair.
+func (s *Service) ImportRates(ctx context.Context, rates []Rate) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("ImportRates(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO exchange_rates(date, base, quote, rate) VALUES($1, $2, $3, $4) ON CONFLICT (base, quote, date) DO UPDATE SET rate = EXCLUDED.rate`)
+	if err != nil {
+		return 0, fmt.Errorf("ImportRates(): db prepare context failure: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range rates {
+		if _, err := stmt.ExecContext(ctx, r.Date, r.Base, r.Quote, r.Value); err != nil {
+			return 0, fmt.Errorf("ImportRates(): db exec context: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("ImportRates(): db commit: %w", err)
+	}
+	return len(rates), nil
+}
+
+// rateOn returns the rate converting from into to effective on date. It uses the pair as loaded,
+// its inverse, or a cross rate through a common base such as EUR for the ECB rates.
+func (s *Service) rateOn(ctx context.Context, from, to string, date time.Time) (*big.Rat, time.Time, error) {
+	if from == to {
+		return big.NewRat(1, 1), date, nil
+	}
+
+	query := `SELECT DISTINCT ON (base, quote) date, base, quote, rate::TEXT from exchange_rates
+		where date <= $1 AND (base = ANY($2) OR quote = ANY($2))
+		ORDER BY base, quote, date DESC`
+	rows, err := s.db.QueryContext(ctx, query, date, pq.Array([]string{from, to}))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("rateOn(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	type pair struct{ base, quote string }
+	rates := make(map[pair]Rate)
+	for rows.Next() {
+		var r Rate
+		if err := rows.Scan(&r.Date, &r.Base, &r.Quote, &r.Value); err != nil {
+			return nil, time.Time{}, fmt.Errorf("rateOn(): db scan row: %w", err)
+		}
+		rates[pair{r.Base, r.Quote}] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("rateOn(): db rows: %w", err)
+	}
+
+	// quoted returns how many units of currency one unit of base is worth.
+	quoted := func(base, currency string) (*big.Rat, time.Time, bool) {
+		if base == currency {
+			return big.NewRat(1, 1), date, true
+		}
+		if r, ok := rates[pair{base, currency}]; ok {
+			v, _ := new(big.Rat).SetString(r.Value)
+			return v, r.Date, true
+		}
+		if r, ok := rates[pair{currency, base}]; ok {
+			v, _ := new(big.Rat).SetString(r.Value)
+			return v.Inv(v), r.Date, true
+		}
+		return nil, time.Time{}, false
+	}
+
+	bases := []string{from}
+	for p := range rates {
+		bases = append(bases, p.base, p.quote)
+	}
+	sort.Strings(bases)
+	for _, base := range bases {
+		f, fd, ok := quoted(base, from)
+		if !ok {
+			continue
+		}
+		t, td, ok := quoted(base, to)
+		if !ok {
+			continue
+		}
+		// the rate is as old as the oldest rate it is made of.
+		if td.Before(fd) {
+			fd = td
+		}
+		return t.Quo(t, f), fd, nil
+	}
+
+	return nil, time.Time{}, ErrMissingRate
+}
+
+// convert rounds m times rate into the minor units of currency, halves away from zero.
+func convert(m Money, rate *big.Rat, currency string) (Money, error) {
+	fromExp, err := Exponent(m.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	toExp, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	v := new(big.Rat).SetInt64(m.Minor)
+	v.Mul(v, rate)
+	v.Mul(v, new(big.Rat).SetFrac(pow10(toExp), pow10(fromExp)))
+
+	num, den := new(big.Int).Abs(v.Num()), v.Denom()
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Mul(r, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if v.Sign() < 0 {
+		q.Neg(q)
+	}
+	if !q.IsInt64() {
+		return Money{}, fmt.Errorf("%w: converted amount is out of range", ErrInvalidAmount)
+	}
+	return Money{Minor: q.Int64(), Currency: currency}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// rateDate is the date whose exchange rate applies to an expense.
+func rateDate(e Expense) time.Time {
+	return e.SpentAt
+}
+
+// convertAll sets Converted on every expense, all the missing rates are reported together.
+func (s *Service) convertAll(ctx context.Context, expenses []Expense, to string) error {
+	if _, err := Exponent(to); err != nil {
+		return err
+	}
+
+	type key struct {
+		currency string
+		date     string
+	}
+	type found struct {
+		rate *big.Rat
+		date time.Time
+	}
+	cache := make(map[key]*found)
+	missing := &MissingRateError{To: to}
+
+	for i, e := range expenses {
+		date := rateDate(e)
+		k := key{e.Amount.Currency, date.Format(dateLayout)}
+		f, ok := cache[k]
+		if !ok {
+			rate, rd, err := s.rateOn(ctx, k.currency, to, date)
+			if errors.Is(err, ErrMissingRate) {
+				missing.Missing = append(missing.Missing, fmt.Sprintf("%s on %s", k.currency, k.date))
+			} else if err != nil {
+				return err
+			} else {
+				f = &found{rate, rd}
+			}
+			cache[k] = f
+		}
+		if f == nil {
+			continue
+		}
+
+		amount, err := convert(e.Amount, f.rate, to)
+		if err != nil {
+			return err
+		}
+		expenses[i].Converted = &Conversion{
+			Amount:   amount,
+			Currency: to,
+			Rate:     strings.TrimSuffix(strings.TrimRight(f.rate.FloatString(10), "0"), "."),
+			RateDate: f.date.Format(dateLayout),
+		}
+	}
+
+	if len(missing.Missing) > 0 {
+		return missing
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package expense_test
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestParseRatesCSV(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		got, err := expn.ParseRatesCSV(strings.NewReader("date,base,quote,rate\n2026-10-16,usd,THB,36.52\n2026-10-16, JPY, THB, 0.2411\n"))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []expn.Rate{
+			{Date: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), Base: "USD", Quote: "THB", Value: "36.52"},
+			{Date: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), Base: "JPY", Quote: "THB", Value: "0.2411"},
+		}, got)
+	})
+
+	t.Run("Invalid rate", func(t *testing.T) {
+		_, err := expn.ParseRatesCSV(strings.NewReader("2026-10-16,USD,THB,-1\n"))
+
+		assert.ErrorIs(t, err, expn.ErrInvalidRate)
+	})
+
+	t.Run("Fraction rate", func(t *testing.T) {
+		for _, rate := range []string{"1/3", "1e3", "0x10"} {
+			_, err := expn.ParseRatesCSV(strings.NewReader("2026-10-16,USD,THB," + rate + "\n"))
+
+			assert.ErrorIs(t, err, expn.ErrInvalidRate, rate)
+		}
+	})
+
+	t.Run("Unknown currency", func(t *testing.T) {
+		_, err := expn.ParseRatesCSV(strings.NewReader("2026-10-16,USD,XXX,1\n"))
+
+		assert.ErrorIs(t, err, expn.ErrInvalidRate)
+	})
+}
+
+func TestParseRatesECB(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2026-10-16">
+			<Cube currency="USD" rate="1.0812"/>
+			<Cube currency="THB" rate="39.485"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+	got, err := expn.ParseRatesECB(strings.NewReader(doc))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []expn.Rate{
+		{Date: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), Base: "EUR", Quote: "USD", Value: "1.0812"},
+		{Date: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), Base: "EUR", Quote: "THB", Value: "39.485"},
+	}, got)
+}
+
+func TestExpenseCurrencyJSON(t *testing.T) {
+	var e expn.Expense
+	err := json.Unmarshal([]byte(`{"amount":"1200","currency":"JPY"}`), &e)
+	assert.NoError(t, err)
+	assert.Equal(t, expn.Money{Minor: 1200, Currency: "JPY"}, e.Amount)
+
+	raw, err := json.Marshal(e)
+	assert.NoError(t, err)
+	assert.Contains(t, string(raw), `"amount":"1200"`)
+	assert.Contains(t, string(raw), `"currency":"JPY"`)
+
+	err = json.Unmarshal([]byte(`{"amount":"12.5","currency":"JPY"}`), &e)
+	assert.ErrorIs(t, err, expn.ErrInvalidAmount)
+}
+
+func TestListExpensesConvert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rateDate := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Cross rate", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses`)).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(1, "hotel", 10000, "USD", "", pq.Array([]string{"travel"}), at, at, at).
+					AddRow(2, "noodle", 5000, "THB", "", pq.Array([]string{"food"}), at, at, at),
+			)
+		mock.ExpectQuery(regexp.QuoteMeta(`from exchange_rates`)).
+			WithArgs(sqlmock.AnyArg(), pq.Array([]string{"USD", "THB"})).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"date", "base", "quote", "rate"}).
+					AddRow(rateDate, "EUR", "THB", "39.485").
+					AddRow(rateDate, "EUR", "USD", "1.0812"),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.List(ctx, expn.ListOptions{ConvertTo: "THB"})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		if assert.Equal(t, 2, len(got.Expenses)) {
+			// 100 USD * 39.485 / 1.0812 = 3651.9608 THB
+			assert.Equal(t, &expn.Conversion{Amount: expn.Money{Minor: 365196, Currency: "THB"}, Currency: "THB", Rate: "36.5196078431", RateDate: "2026-10-16"}, got.Expenses[0].Converted)
+			assert.Equal(t, expn.Money{Minor: 5000, Currency: "THB"}, got.Expenses[1].Converted.Amount)
+		}
+	})
+
+	t.Run("Missing rate", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at from expenses`)).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(1, "sushi", 1200, "JPY", "", pq.Array([]string{"food"}), at, at, at),
+			)
+		mock.ExpectQuery(regexp.QuoteMeta(`from exchange_rates`)).
+			WillReturnRows(sqlmock.NewRows([]string{"date", "base", "quote", "rate"}))
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.List(ctx, expn.ListOptions{ConvertTo: "THB"})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrMissingRate)
+		assert.Contains(t, err.Error(), "JPY on ")
+	})
+}
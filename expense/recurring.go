@@ -0,0 +1,419 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/dakeeChv/assessment/auth"
+)
+
+var (
+	ErrNoRecurring       = errors.New("no recurring expense")
+	ErrInvalidRecurring  = errors.New("invalid recurring expense")
+	ErrInvalidOccurrence = errors.New("invalid occurrence")
+)
+
+// MaxOccurrences is the most occurrences previewed at once.
+const MaxOccurrences = 100
+
+// MaxMaterialise is the most expenses one run of Materialise creates, the occurrences past it,
+// such as the catch-up after a long downtime, are created by the next runs.
+const MaxMaterialise = 1000
+
+// Recurring is the template of an expense created on every occurrence of its rule, such as the rent.
+type Recurring struct {
+	ID     int64    `json:"id"`
+	Title  string   `json:"title"`
+	Amount Money    `json:"amount"`
+	Note   string   `json:"note"`
+	Tags   []string `json:"tags"`
+
+	Rule     Rule      `json:"rule"`
+	StartsAt time.Time `json:"starts_at"`
+	// TimeZone is the IANA time zone whose calendar the rule follows, UTC by default.
+	TimeZone string `json:"time_zone"`
+	// NextAt is the next occurrence to create, nil once the rule ended.
+	NextAt *time.Time `json:"next_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// recurringJSON is Recurring without its json methods.
+type recurringJSON Recurring
+
+// MarshalJSON writes the amount and its currency as an Expense does.
+func (r Recurring) MarshalJSON() ([]byte, error) {
+	return marshalWithCurrency(recurringJSON(r), r.Amount)
+}
+
+// UnmarshalJSON reads the amount and its currency as an Expense does.
+func (r *Recurring) UnmarshalJSON(b []byte) error {
+	return unmarshalWithCurrency(b, (*recurringJSON)(r), &r.Amount)
+}
+
+// expense is the expense created on the occurrence at.
+func (r Recurring) expense(at time.Time) Expense {
+	return Expense{Title: r.Title, Amount: r.Amount, Note: r.Note, Tags: r.Tags, SpentAt: at}
+}
+
+// Occurrence is a time a recurring expense is due.
+type Occurrence struct {
+	At      time.Time `json:"at"`
+	Skipped bool      `json:"skipped"`
+}
+
+const recurringColumns = `id, title, amount, currency, note, tags, rule, starts_at, time_zone, next_at, created_at, updated_at`
+
+// scanRecurring scans the recurringColumns of a row, its occurrences are in its time zone.
+func scanRecurring(scan func(dest ...interface{}) error) (Recurring, error) {
+	var r Recurring
+	var rule string
+	err := scan(&r.ID, &r.Title, &r.Amount.Minor, &r.Amount.Currency, &r.Note, pq.Array(&r.Tags), &rule, &r.StartsAt, &r.TimeZone, &r.NextAt, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return Recurring{}, err
+	}
+	if r.Rule, err = ParseRule(rule); err != nil {
+		return Recurring{}, err
+	}
+	loc, err := time.LoadLocation(r.TimeZone)
+	if err != nil {
+		return Recurring{}, err
+	}
+	r.StartsAt = r.StartsAt.In(loc)
+	if r.NextAt != nil {
+		next := r.NextAt.In(loc)
+		r.NextAt = &next
+	}
+	return r, nil
+}
+
+// CreateRecurring adds a recurring expense of the caller, its first occurrence is StartsAt.
+func (s *Service) CreateRecurring(ctx context.Context, in Recurring) (Recurring, error) {
+	e := in.expense(in.StartsAt)
+	if err := validate(&e); err != nil {
+		return Recurring{}, err
+	}
+	if err := in.Rule.Validate(); err != nil {
+		return Recurring{}, err
+	}
+	if in.StartsAt.IsZero() {
+		return Recurring{}, fmt.Errorf("%w: starts_at is required", ErrInvalidRecurring)
+	}
+	if in.TimeZone == "" {
+		in.TimeZone = "UTC"
+	}
+	loc, err := time.LoadLocation(in.TimeZone)
+	if err != nil {
+		return Recurring{}, fmt.Errorf("%w: unknown time_zone %q", ErrInvalidRecurring, in.TimeZone)
+	}
+	uid, err := owner(ctx)
+	if err != nil {
+		return Recurring{}, err
+	}
+
+	// postgres keeps microseconds, the occurrences must compare equal once stored.
+	start := in.StartsAt.In(loc).Truncate(time.Microsecond)
+	var next *time.Time
+	if at, ok := in.Rule.Next(start, start); ok {
+		next = &at
+	}
+
+	query := `INSERT INTO recurring_expenses(owner_id, title, amount, currency, note, tags, rule, starts_at, time_zone, next_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING ` + recurringColumns
+	row := s.db.QueryRowContext(ctx, query, uid, e.Title, e.Amount.Minor, e.Amount.Currency, e.Note, pq.Array(e.Tags), in.Rule.String(), start, in.TimeZone, next)
+	out, err := scanRecurring(row.Scan)
+	if err != nil {
+		return Recurring{}, fmt.Errorf("CreateRecurring(): db scan row: %w", err)
+	}
+
+	return out, nil
+}
+
+// ListRecurring returns the recurring expenses of the caller.
+func (s *Service) ListRecurring(ctx context.Context) ([]Recurring, error) {
+	uid, err := owner(ctx)
+	if err != nil {
+		return []Recurring{}, err
+	}
+	query := `SELECT ` + recurringColumns + ` from recurring_expenses where owner_id=$1 ORDER BY id`
+
+	out := make([]Recurring, 0)
+	rows, err := s.db.QueryContext(ctx, query, uid)
+	if err != nil {
+		return []Recurring{}, fmt.Errorf("ListRecurring(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanRecurring(rows.Scan)
+		if err != nil {
+			return []Recurring{}, fmt.Errorf("ListRecurring(): db scan row: %w", err)
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return []Recurring{}, fmt.Errorf("ListRecurring(): db rows: %w", err)
+	}
+
+	return out, nil
+}
+
+func (s *Service) GetRecurring(ctx context.Context, id int64) (Recurring, error) {
+	uid, err := owner(ctx)
+	if err != nil {
+		return Recurring{}, err
+	}
+	query := `SELECT ` + recurringColumns + ` from recurring_expenses where id=$1 AND owner_id=$2`
+
+	out, err := scanRecurring(s.db.QueryRowContext(ctx, query, id, uid).Scan)
+	if err == sql.ErrNoRows {
+		return Recurring{}, ErrNoRecurring
+	}
+	if err != nil {
+		return Recurring{}, fmt.Errorf("GetRecurring(): db scan row: %w", err)
+	}
+
+	return out, nil
+}
+
+// DeleteRecurring stops a recurring expense, the expenses it already created are kept.
+func (s *Service) DeleteRecurring(ctx context.Context, id int64) error {
+	uid, err := owner(ctx)
+	if err != nil {
+		return err
+	}
+	query := `DELETE FROM recurring_expenses WHERE id=$1 AND owner_id=$2`
+
+	res, err := s.db.ExecContext(ctx, query, id, uid)
+	if err != nil {
+		return fmt.Errorf("DeleteRecurring(): db exec context: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("DeleteRecurring(): db rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecurring
+	}
+
+	return nil
+}
+
+// Occurrences previews the next n occurrences of a recurring expense which are not created yet.
+func (s *Service) Occurrences(ctx context.Context, id int64, n int) ([]Occurrence, error) {
+	if n < 1 || n > MaxOccurrences {
+		return []Occurrence{}, fmt.Errorf("%w: preview between 1 and %d occurrences", ErrInvalidOccurrence, MaxOccurrences)
+	}
+	r, err := s.GetRecurring(ctx, id)
+	if err != nil {
+		return []Occurrence{}, err
+	}
+	out := make([]Occurrence, 0, n)
+	if r.NextAt == nil {
+		return out, nil
+	}
+
+	query := `SELECT occurs_at from recurring_occurrences where recurring_id=$1 AND skipped AND occurs_at >= $2`
+	rows, err := s.db.QueryContext(ctx, query, id, *r.NextAt)
+	if err != nil {
+		return []Occurrence{}, fmt.Errorf("Occurrences(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	var skipped []time.Time
+	for rows.Next() {
+		var at time.Time
+		if err := rows.Scan(&at); err != nil {
+			return []Occurrence{}, fmt.Errorf("Occurrences(): db scan row: %w", err)
+		}
+		skipped = append(skipped, at)
+	}
+	if err := rows.Err(); err != nil {
+		return []Occurrence{}, fmt.Errorf("Occurrences(): db rows: %w", err)
+	}
+
+	r.Rule.each(r.StartsAt, *r.NextAt, func(at time.Time) bool {
+		o := Occurrence{At: at}
+		for _, s := range skipped {
+			o.Skipped = o.Skipped || s.Equal(at)
+		}
+		out = append(out, o)
+		return len(out) < n
+	})
+	return out, nil
+}
+
+// Skip keeps a single future occurrence of a recurring expense from creating an expense.
+func (s *Service) Skip(ctx context.Context, id int64, at time.Time) error {
+	uid, err := owner(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Skip(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the row is locked so the scheduler can not create the occurrence meanwhile.
+	query := `SELECT ` + recurringColumns + ` from recurring_expenses where id=$1 AND owner_id=$2 FOR UPDATE`
+	r, err := scanRecurring(tx.QueryRowContext(ctx, query, id, uid).Scan)
+	if err == sql.ErrNoRows {
+		return ErrNoRecurring
+	}
+	if err != nil {
+		return fmt.Errorf("Skip(): db scan row: %w", err)
+	}
+	if !r.Rule.Includes(r.StartsAt, at) {
+		return fmt.Errorf("%w: %s is not an occurrence of %s", ErrInvalidOccurrence, at.Format(time.RFC3339), r.Rule)
+	}
+	if r.NextAt == nil || at.Before(*r.NextAt) {
+		return fmt.Errorf("%w: %s is already created", ErrInvalidOccurrence, at.Format(time.RFC3339))
+	}
+
+	query = `INSERT INTO recurring_occurrences(recurring_id, occurs_at, skipped) VALUES($1, $2, true) ON CONFLICT DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, id, at); err != nil {
+		return fmt.Errorf("Skip(): db exec context: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Skip(): db commit: %w", err)
+	}
+	return nil
+}
+
+// Materialise creates the expenses of every occurrence due by now which is neither created nor skipped,
+// at most MaxMaterialise of them. Each recurring expense is materialised in its own transaction holding
+// its row, so each occurrence is created exactly once even when several replicas run it, and one failing
+// is logged and skipped rather than holding back the others.
+func (s *Service) Materialise(ctx context.Context, now time.Time) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id from recurring_expenses where next_at <= $1 ORDER BY id`, now)
+	if err != nil {
+		return 0, fmt.Errorf("Materialise(): db query context: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("Materialise(): db scan row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("Materialise(): db rows: %w", err)
+	}
+
+	n := 0
+	for _, id := range ids {
+		if n == MaxMaterialise {
+			break
+		}
+		created, err := s.materialise(ctx, id, now, MaxMaterialise-n)
+		if err != nil {
+			log.Printf("Materialise(): skipped recurring expense %d: %v", id, err)
+			continue
+		}
+		n += created
+	}
+	return n, nil
+}
+
+// materialise creates at most limit due occurrences of a recurring expense and moves its next_at past them.
+// A recurring expense another replica is materialising, or which is no longer due, is left alone.
+func (s *Service) materialise(ctx context.Context, id int64, now time.Time, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("materialise(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner string
+	query := `SELECT owner_id, ` + recurringColumns + ` from recurring_expenses where id=$1 AND next_at <= $2 FOR UPDATE SKIP LOCKED`
+	r, err := scanRecurring(func(dest ...interface{}) error {
+		return tx.QueryRowContext(ctx, query, id, now).Scan(append([]interface{}{&owner}, dest...)...)
+	})
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("materialise(): db scan row: %w", err)
+	}
+
+	done, err := s.occurred(ctx, tx, r.ID, *r.NextAt, now)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	var next *time.Time
+	r.Rule.each(r.StartsAt, *r.NextAt, func(at time.Time) bool {
+		if at.After(now) || n == limit {
+			next = &at
+			return false
+		}
+		for _, o := range done {
+			if o.Equal(at) {
+				return true
+			}
+		}
+
+		// the expense is created as its owner.
+		octx := auth.NewContext(ctx, auth.Principal{ID: owner})
+		var e Expense
+		if e, err = s.create(octx, tx, r.expense(at)); err != nil {
+			return false
+		}
+		query := `INSERT INTO recurring_occurrences(recurring_id, occurs_at, expense_id) VALUES($1, $2, $3)`
+		if _, err = tx.ExecContext(ctx, query, r.ID, at, e.ID); err != nil {
+			err = fmt.Errorf("materialise(): db exec context: %w", err)
+			return false
+		}
+		n++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	query = `UPDATE recurring_expenses SET next_at=$1, updated_at=now() WHERE id=$2`
+	if _, err := tx.ExecContext(ctx, query, next, r.ID); err != nil {
+		return 0, fmt.Errorf("materialise(): db exec context: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("materialise(): db commit: %w", err)
+	}
+	return n, nil
+}
+
+// occurred returns the occurrences of a recurring expense between from and to which are created or skipped.
+func (s *Service) occurred(ctx context.Context, tx *sql.Tx, id int64, from, to time.Time) ([]time.Time, error) {
+	query := `SELECT occurs_at from recurring_occurrences where recurring_id=$1 AND occurs_at >= $2 AND occurs_at <= $3`
+	rows, err := tx.QueryContext(ctx, query, id, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("occurred(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	var out []time.Time
+	for rows.Next() {
+		var at time.Time
+		if err := rows.Scan(&at); err != nil {
+			return nil, fmt.Errorf("occurred(): db scan row: %w", err)
+		}
+		out = append(out, at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("occurred(): db rows: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,263 @@
+package expense_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+var recurringColumns = []string{"id", "title", "amount", "currency", "note", "tags", "rule", "starts_at", "time_zone", "next_at", "created_at", "updated_at"}
+
+func TestMaterialise(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	start := time.Date(2026, time.August, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	september := start.AddDate(0, 1, 0)
+	october := start.AddDate(0, 2, 0)
+
+	dueQuery := regexp.QuoteMeta(`SELECT id from recurring_expenses where next_at <= $1 ORDER BY id`)
+	lockQuery := regexp.QuoteMeta(`SELECT owner_id, id, title, amount, currency, note, tags, rule, starts_at, time_zone, next_at, created_at, updated_at from recurring_expenses where id=$1 AND next_at <= $2 FOR UPDATE SKIP LOCKED`)
+	expectCreate := func(id int64, title string, at time.Time, tags ...string) *sqlmock.ExpectedQuery {
+		if tags == nil {
+			tags = []string{}
+		}
+		q := mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id)`)).
+			ExpectQuery().
+			WithArgs(title, 1500000, "THB", "", pq.Array(tags), at, "bob")
+		q.WillReturnRows(
+			sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+				AddRow(id, title, 1500000, "THB", "", pq.Array(tags), at, now, now),
+		)
+		return q
+	}
+	expectOccurrence := func(recurringID, id int64, at time.Time, tags ...string) {
+		if len(tags) > 0 {
+			expectTags(mock, id, tags...)
+		}
+		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expense_history(expense_id, revision, actor, action, changes, snapshot, reverted_to)`)).
+			ExpectExec().
+			WithArgs(id, int64(1), "bob", expn.ActionCreate, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO recurring_occurrences(recurring_id, occurs_at, expense_id) VALUES($1, $2, $3)`)).
+			WithArgs(recurringID, at, id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	t.Run("Creates due occurrences once", func(t *testing.T) {
+		mock.ExpectQuery(dueQuery).
+			WithArgs(now).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(3), now).
+			WillReturnRows(
+				sqlmock.NewRows(append([]string{"owner_id"}, recurringColumns...)).
+					AddRow("bob", 3, "rent", 1500000, "THB", "", pq.Array([]string{"home"}), "FREQ=MONTHLY", start, "UTC", september, at, at),
+			)
+		// September was skipped, October is due.
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT occurs_at from recurring_occurrences where recurring_id=$1 AND occurs_at >= $2 AND occurs_at <= $3`)).
+			WithArgs(3, september, now).
+			WillReturnRows(sqlmock.NewRows([]string{"occurs_at"}).AddRow(september))
+		expectCreate(11, "rent", october, "home")
+		expectOccurrence(3, 11, october, "home")
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE recurring_expenses SET next_at=$1, updated_at=now() WHERE id=$2`)).
+			WithArgs(start.AddDate(0, 3, 0), 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		expense, _ := expn.NewService(context.Background(), db)
+
+		n, err := expense.Materialise(context.Background(), now)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("Another replica holds it", func(t *testing.T) {
+		mock.ExpectQuery(dueQuery).
+			WithArgs(now).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(3), now).
+			WillReturnRows(sqlmock.NewRows(append([]string{"owner_id"}, recurringColumns...)))
+		mock.ExpectRollback()
+
+		expense, _ := expn.NewService(context.Background(), db)
+
+		n, err := expense.Materialise(context.Background(), now)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("A failing one is skipped", func(t *testing.T) {
+		mock.ExpectQuery(dueQuery).
+			WithArgs(now).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(4))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(3), now).
+			WillReturnRows(
+				sqlmock.NewRows(append([]string{"owner_id"}, recurringColumns...)).
+					AddRow("bob", 3, "rent", 1500000, "THB", "", pq.Array([]string{}), "FREQ=MONTHLY", start, "UTC", october, at, at),
+			)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT occurs_at from recurring_occurrences`)).
+			WithArgs(3, october, now).
+			WillReturnRows(sqlmock.NewRows([]string{"occurs_at"}))
+		expectCreate(0, "rent", october).WillReturnError(errors.New("some error"))
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(4), now).
+			WillReturnRows(
+				sqlmock.NewRows(append([]string{"owner_id"}, recurringColumns...)).
+					AddRow("bob", 4, "office", 1500000, "THB", "", pq.Array([]string{}), "FREQ=MONTHLY", start, "UTC", october, at, at),
+			)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT occurs_at from recurring_occurrences`)).
+			WithArgs(4, october, now).
+			WillReturnRows(sqlmock.NewRows([]string{"occurs_at"}))
+		expectCreate(12, "office", october)
+		expectOccurrence(4, 12, october)
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE recurring_expenses SET next_at=$1, updated_at=now() WHERE id=$2`)).
+			WithArgs(start.AddDate(0, 3, 0), 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		expense, _ := expn.NewService(context.Background(), db)
+
+		n, err := expense.Materialise(context.Background(), now)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("Catch-up is capped", func(t *testing.T) {
+		daily := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery(dueQuery).
+			WithArgs(now).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5).AddRow(6))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(int64(5), now).
+			WillReturnRows(
+				sqlmock.NewRows(append([]string{"owner_id"}, recurringColumns...)).
+					AddRow("bob", 5, "lunch", 1500000, "THB", "", pq.Array([]string{}), "FREQ=DAILY", daily, "UTC", daily, at, at),
+			)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT occurs_at from recurring_occurrences`)).
+			WithArgs(5, daily, now).
+			WillReturnRows(sqlmock.NewRows([]string{"occurs_at"}))
+		for i := 0; i < expn.MaxMaterialise; i++ {
+			day := daily.AddDate(0, 0, i)
+			expectCreate(int64(100+i), "lunch", day)
+			expectOccurrence(5, int64(100+i), day)
+		}
+		// the rest are left to the next runs, the recurring expense 6 included.
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE recurring_expenses SET next_at=$1, updated_at=now() WHERE id=$2`)).
+			WithArgs(daily.AddDate(0, 0, expn.MaxMaterialise), 5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		expense, _ := expn.NewService(context.Background(), db)
+
+		n, err := expense.Materialise(context.Background(), now)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, expn.MaxMaterialise, n)
+	})
+}
+
+func TestSkip(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	start := time.Date(2026, time.August, 1, 0, 0, 0, 0, time.UTC)
+	next := start.AddDate(0, 3, 0)
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(recurringColumns).
+			AddRow(3, "rent", 1500000, "THB", "", pq.Array([]string{"home"}), "FREQ=MONTHLY", start, "UTC", next, at, at)
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`from recurring_expenses where id=$1 AND owner_id=$2 FOR UPDATE`)).
+			WithArgs(3, alice.ID).
+			WillReturnRows(rows())
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO recurring_occurrences(recurring_id, occurs_at, skipped) VALUES($1, $2, true) ON CONFLICT DO NOTHING`)).
+			WithArgs(3, next.AddDate(0, 1, 0)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		err := expense.Skip(ctx, 3, next.AddDate(0, 1, 0))
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+	})
+
+	tests := []struct {
+		name string
+		at   time.Time
+	}{
+		{"Not an occurrence", next.AddDate(0, 0, 1)},
+		{"Already created", start},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(`from recurring_expenses where id=$1 AND owner_id=$2 FOR UPDATE`)).
+				WithArgs(3, alice.ID).
+				WillReturnRows(rows())
+			mock.ExpectRollback()
+
+			ctx := auth.NewContext(context.Background(), alice)
+			expense, _ := expn.NewService(ctx, db)
+
+			err := expense.Skip(ctx, 3, tt.at)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+
+			assert.ErrorIs(t, err, expn.ErrInvalidOccurrence)
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package expense
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+// Freq is how often a rule repeats.
+type Freq string
+
+const (
+	Daily   Freq = "DAILY"
+	Weekly  Freq = "WEEKLY"
+	Monthly Freq = "MONTHLY"
+	Yearly  Freq = "YEARLY"
+)
+
+// untilLayout is the UTC date-time layout of UNTIL, e.g. "20271231T000000Z".
+const untilLayout = "20060102T150405Z"
+
+// Rule is the subset of an iCalendar RRULE expenses repeat with, e.g. "FREQ=MONTHLY;INTERVAL=1;COUNT=12".
+// A rule repeats from a start time, every Interval units of Freq, until Count occurrences or Until.
+// Like an RRULE, a monthly or yearly rule skips the months without the day of its start, e.g. the 31st.
+type Rule struct {
+	Freq     Freq
+	Interval int
+	// Count ends the rule after that many occurrences, zero means no limit.
+	Count int
+	// Until ends the rule after the last occurrence not after it, nil means no limit.
+	Until *time.Time
+}
+
+// ParseRule parses a rule such as "FREQ=WEEKLY;INTERVAL=2;UNTIL=20271231T000000Z",
+// an optional "RRULE:" prefix is ignored. COUNT and UNTIL can not both be set.
+func ParseRule(s string) (Rule, error) {
+	r := Rule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return Rule{}, fmt.Errorf("%w: %q is not NAME=VALUE", ErrInvalidRule, part)
+		}
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			r.Freq = Freq(strings.ToUpper(value))
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return Rule{}, fmt.Errorf("%w: INTERVAL %q must be a positive integer", ErrInvalidRule, value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return Rule{}, fmt.Errorf("%w: COUNT %q must be a positive integer", ErrInvalidRule, value)
+			}
+			r.Count = n
+		case "UNTIL":
+			until, err := time.Parse(untilLayout, value)
+			if err != nil {
+				until, err = time.Parse("20060102", value)
+			}
+			if err != nil {
+				return Rule{}, fmt.Errorf("%w: UNTIL %q must be YYYYMMDD or YYYYMMDDTHHMMSSZ", ErrInvalidRule, value)
+			}
+			r.Until = &until
+		default:
+			return Rule{}, fmt.Errorf("%w: %s is not supported", ErrInvalidRule, name)
+		}
+	}
+	return r, r.Validate()
+}
+
+// Validate reports the first inconsistent part of the rule.
+func (r Rule) Validate() error {
+	switch r.Freq {
+	case Daily, Weekly, Monthly, Yearly:
+	default:
+		return fmt.Errorf("%w: FREQ %q must be one of DAILY, WEEKLY, MONTHLY or YEARLY", ErrInvalidRule, r.Freq)
+	}
+	if r.Interval < 1 {
+		return fmt.Errorf("%w: INTERVAL must be a positive integer", ErrInvalidRule)
+	}
+	if r.Count > 0 && r.Until != nil {
+		return fmt.Errorf("%w: COUNT and UNTIL must not both be set", ErrInvalidRule)
+	}
+	return nil
+}
+
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilLayout))
+	}
+	return strings.Join(parts, ";")
+}
+
+// MarshalText writes the rule in its RRULE form.
+func (r Rule) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText reads a rule in its RRULE form.
+func (r *Rule) UnmarshalText(b []byte) error {
+	out, err := ParseRule(string(b))
+	if err != nil {
+		return err
+	}
+	*r = out
+	return nil
+}
+
+// each calls yield with the occurrences of the rule started at start which are not before from,
+// in order, until the rule ends or yield returns false.
+func (r Rule) each(start, from time.Time, yield func(time.Time) bool) {
+	n := 0
+	for i := 0; ; i++ {
+		step := i * r.Interval
+		var at time.Time
+		switch r.Freq {
+		case Daily:
+			at = start.AddDate(0, 0, step)
+		case Weekly:
+			at = start.AddDate(0, 0, 7*step)
+		case Monthly:
+			at = start.AddDate(0, step, 0)
+		case Yearly:
+			at = start.AddDate(step, 0, 0)
+		default:
+			return
+		}
+		// AddDate normalises Jan 31 plus a month into March, that month has no occurrence.
+		if (r.Freq == Monthly || r.Freq == Yearly) && at.Day() != start.Day() {
+			continue
+		}
+		if r.Until != nil && at.After(*r.Until) {
+			return
+		}
+		n++
+		if r.Count > 0 && n > r.Count {
+			return
+		}
+		if at.Before(from) {
+			continue
+		}
+		if !yield(at) {
+			return
+		}
+	}
+}
+
+// Next returns the first occurrence of the rule started at start which is not before from,
+// false when the rule ends before.
+func (r Rule) Next(start, from time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	r.each(start, from, func(at time.Time) bool {
+		next, found = at, true
+		return false
+	})
+	return next, found
+}
+
+// Includes reports whether at is an occurrence of the rule started at start.
+func (r Rule) Includes(start, at time.Time) bool {
+	next, ok := r.Next(start, at)
+	return ok && next.Equal(at)
+}
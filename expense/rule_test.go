@@ -0,0 +1,79 @@
+package expense_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestParseRule(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		got, err := expn.ParseRule("RRULE:FREQ=weekly;INTERVAL=2;UNTIL=20271231T000000Z")
+
+		until := time.Date(2027, time.December, 31, 0, 0, 0, 0, time.UTC)
+		assert.NoError(t, err)
+		assert.Equal(t, expn.Rule{Freq: expn.Weekly, Interval: 2, Until: &until}, got)
+		assert.Equal(t, "FREQ=WEEKLY;INTERVAL=2;UNTIL=20271231T000000Z", got.String())
+	})
+
+	tests := []struct {
+		name string
+		rule string
+	}{
+		{"Unknown freq", "FREQ=HOURLY"},
+		{"Zero interval", "FREQ=DAILY;INTERVAL=0"},
+		{"Count and until", "FREQ=DAILY;COUNT=2;UNTIL=20271231"},
+		{"Unsupported part", "FREQ=MONTHLY;BYDAY=MO"},
+		{"Not name value", "FREQ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := expn.ParseRule(tt.rule)
+
+			assert.ErrorIs(t, err, expn.ErrInvalidRule)
+		})
+	}
+}
+
+func TestRuleNext(t *testing.T) {
+	bangkok, _ := time.LoadLocation("Asia/Bangkok")
+
+	t.Run("Monthly skips short months", func(t *testing.T) {
+		start := time.Date(2026, time.January, 31, 9, 0, 0, 0, bangkok)
+		r := expn.Rule{Freq: expn.Monthly, Interval: 1}
+
+		got, ok := r.Next(start, start.AddDate(0, 0, 1))
+
+		assert.True(t, ok)
+		assert.Equal(t, time.Date(2026, time.March, 31, 9, 0, 0, 0, bangkok), got)
+		assert.False(t, r.Includes(start, time.Date(2026, time.March, 3, 9, 0, 0, 0, bangkok)))
+	})
+
+	t.Run("Count ends", func(t *testing.T) {
+		start := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+		r := expn.Rule{Freq: expn.Daily, Interval: 2, Count: 3}
+
+		last, ok := r.Next(start, start.AddDate(0, 0, 3))
+		_, after := r.Next(start, last.Add(time.Second))
+
+		assert.True(t, ok)
+		assert.Equal(t, time.Date(2026, time.October, 5, 0, 0, 0, 0, time.UTC), last)
+		assert.False(t, after)
+	})
+
+	t.Run("Until ends", func(t *testing.T) {
+		start := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+		until := time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)
+		r := expn.Rule{Freq: expn.Weekly, Interval: 1, Until: &until}
+
+		got, ok := r.Next(start, start.AddDate(0, 0, 1))
+		_, after := r.Next(start, until.Add(time.Second))
+
+		assert.True(t, ok)
+		assert.Equal(t, time.Date(2026, time.October, 8, 0, 0, 0, 0, time.UTC), got)
+		assert.False(t, after)
+	})
+}
@@ -0,0 +1,105 @@
+package expense
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// SearchResult is an expense matching a search, most relevant first.
+type SearchResult struct {
+	Expense
+	Rank      float64   `json:"rank"`
+	Highlight Highlight `json:"highlight"`
+}
+
+// MarshalJSON writes the expense with its rank and highlight, the promoted
+// Expense.MarshalJSON would leave them out.
+func (r SearchResult) MarshalJSON() ([]byte, error) {
+	return marshalWithCurrency(struct {
+		expenseJSON
+		Rank      float64   `json:"rank"`
+		Highlight Highlight `json:"highlight"`
+	}{expenseJSON(r.Expense), r.Rank, r.Highlight}, r.Amount)
+}
+
+// Highlight holds the matched fields with the search terms wrapped in <mark></mark>,
+// the text around them is HTML-escaped so it can be rendered as HTML.
+type Highlight struct {
+	Title string `json:"title"`
+	Note  string `json:"note"`
+}
+
+// escapedHTML is the SQL expression of a text column escaped like html.EscapeString does,
+// it is applied before ts_headline wraps the matches in <mark></mark>.
+func escapedHTML(column string) string {
+	return `replace(replace(replace(replace(replace(coalesce(` + column + `, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+}
+
+// searchQuery turns free text into a prefix matching tsquery, every word must match.
+// Only letters and digits are kept so the text can not inject tsquery operators.
+func searchQuery(q string) (string, error) {
+	var terms []string
+	for _, word := range strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	}) {
+		terms = append(terms, word+":*")
+	}
+	if len(terms) == 0 {
+		return "", fmt.Errorf("%w: q must contain a word", ErrInvalidQuery)
+	}
+	return strings.Join(terms, " & "), nil
+}
+
+// Search ranks the expenses by relevance of their title and note to q,
+// a match in the title weights more than a match in the note.
+func (s *Service) Search(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	tsquery, err := searchQuery(q)
+	if err != nil {
+		return []SearchResult{}, err
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	uid, err := scope(ctx)
+	if err != nil {
+		return []SearchResult{}, err
+	}
+
+	query := `SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, ts_rank(search, query) AS rank,
+		ts_headline('simple', ` + escapedHTML("title") + `, query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+		ts_headline('simple', ` + escapedHTML("note") + `, query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2')
+		from expenses, to_tsquery('simple', $1) query
+		where owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL AND search @@ query
+		ORDER BY rank DESC, id LIMIT $3`
+
+	out := make([]SearchResult, 0)
+	rows, err := s.db.QueryContext(ctx, query, tsquery, uid, limit)
+	if err != nil {
+		return []SearchResult{}, fmt.Errorf("Search(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r SearchResult
+		err := rows.Scan(&r.ID, &r.Title, &r.Amount.Minor, &r.Amount.Currency, &r.Note, pq.Array(&r.Tags), &r.SpentAt, &r.CreatedAt, &r.UpdatedAt, &r.Rank, &r.Highlight.Title, &r.Highlight.Note)
+		if err != nil {
+			return []SearchResult{}, fmt.Errorf("Search(): db scan row: %w", err)
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return []SearchResult{}, fmt.Errorf("Search(): db rows: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,107 @@
+package expense_test
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestSearchExpenses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`from expenses, to_tsquery('simple', $1) query`)).
+			WithArgs("straw:* & smooth:*", alice.ID, expn.DefaultPageSize).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "rank", "title", "note"}).
+					AddRow(1, "strawberry smoothie", 7900, "THB", "night market", pq.Array([]string{"food"}), at, at, at, 0.6, "<mark>strawberry</mark> <mark>smoothie</mark>", "night market"),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Search(ctx, "straw' | smooth!", 0)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		if assert.Equal(t, 1, len(got)) {
+			assert.Equal(t, int64(1), got[0].ID)
+			assert.Equal(t, 0.6, got[0].Rank)
+			assert.Equal(t, "<mark>strawberry</mark> <mark>smoothie</mark>", got[0].Highlight.Title)
+		}
+	})
+
+	t.Run("Highlight is HTML-escaped", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`ts_headline('simple', replace(replace(replace(replace(replace(coalesce(title, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;'), query`)).
+			WithArgs("img:*", alice.ID, expn.DefaultPageSize).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "rank", "title", "note"}).
+					AddRow(2, `<img src=x onerror=alert(1)>`, 100, "THB", "", pq.Array([]string{}), at, at, at, 0.1, `&lt;<mark>img</mark> src=x onerror=alert(1)&gt;`, ""),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Search(ctx, "img", 0)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		if assert.Equal(t, 1, len(got)) {
+			assert.Equal(t, `<img src=x onerror=alert(1)>`, got[0].Title)
+			assert.Equal(t, `&lt;<mark>img</mark> src=x onerror=alert(1)&gt;`, got[0].Highlight.Title)
+		}
+	})
+
+	t.Run("Empty query", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Search(ctx, " & ! ", 0)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrInvalidQuery)
+		assert.Equal(t, 0, len(got))
+	})
+}
+
+func TestSearchResultJSON(t *testing.T) {
+	r := expn.SearchResult{
+		Expense:   expn.Expense{ID: 1, Title: "a", Amount: expn.Money{Minor: 100, Currency: "THB"}},
+		Rank:      0.5,
+		Highlight: expn.Highlight{Title: "<mark>a</mark>"},
+	}
+
+	raw, err := json.Marshal(r)
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(raw), `"id":1`)
+	assert.Contains(t, string(raw), `"amount":"1.00"`)
+	assert.Contains(t, string(raw), `"currency":"THB"`)
+	assert.Contains(t, string(raw), `"rank":0.5`)
+	var got struct {
+		Highlight expn.Highlight `json:"highlight"`
+	}
+	assert.NoError(t, json.Unmarshal(raw, &got))
+	assert.Equal(t, r.Highlight, got.Highlight)
+}
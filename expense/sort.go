@@ -0,0 +1,120 @@
+package expense
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+// sortFields is the whitelist of sortable fields, the key is the json name of the field.
+var sortFields = map[string]struct {
+	column string
+	value  func(Expense) interface{}
+}{
+	"id":     {"id", func(e Expense) interface{} { return e.ID }},
+	"title":  {"title", func(e Expense) interface{} { return e.Title }},
+	"amount": {"amount", func(e Expense) interface{} { return e.Amount.Minor }},
+	"note":   {"note", func(e Expense) interface{} { return e.Note }},
+
+	"spent_at":   {"spent_at", func(e Expense) interface{} { return e.SpentAt }},
+	"created_at": {"created_at", func(e Expense) interface{} { return e.CreatedAt }},
+	"updated_at": {"updated_at", func(e Expense) interface{} { return e.UpdatedAt }},
+}
+
+// SortFields returns the names of the sortable fields.
+func SortFields() []string {
+	names := make([]string, 0, len(sortFields))
+	for name := range sortFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SortKey orders the listed expenses by a field.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses a comma separated sort specification such as "-amount,title",
+// a leading minus sorts the field in descending order.
+func ParseSort(spec string) ([]SortKey, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	var keys []SortKey
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		key := SortKey{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if _, ok := sortFields[key.Field]; !ok {
+			return nil, fmt.Errorf("%w: unknown field %q, allowed fields are %s", ErrInvalidSort, key.Field, strings.Join(SortFields(), ", "))
+		}
+		if seen[key.Field] {
+			return nil, fmt.Errorf("%w: field %q is given more than once", ErrInvalidSort, key.Field)
+		}
+		seen[key.Field] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// normalizeSort validates keys and appends id as the tiebreak, so the order is total.
+func normalizeSort(keys []SortKey) ([]SortKey, error) {
+	out := make([]SortKey, 0, len(keys)+1)
+	for _, key := range keys {
+		if _, ok := sortFields[key.Field]; !ok {
+			return nil, fmt.Errorf("%w: unknown field %q, allowed fields are %s", ErrInvalidSort, key.Field, strings.Join(SortFields(), ", "))
+		}
+		out = append(out, key)
+		if key.Field == "id" {
+			return out, nil
+		}
+	}
+	return append(out, SortKey{Field: "id"}), nil
+}
+
+func sortString(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key.Field
+		if key.Desc {
+			parts[i] = "-" + key.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func orderBy(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = sortFields[key.Field].column
+		if key.Desc {
+			parts[i] += " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// seek adds the keyset condition selecting the rows ordered after values.
+func seek(w *where, keys []SortKey, values []interface{}) {
+	var ors []string
+	for i, key := range keys {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, fmt.Sprintf("%s = %s", sortFields[keys[j].Field].column, w.arg(values[j])))
+		}
+		op := ">"
+		if key.Desc {
+			op = "<"
+		}
+		ands = append(ands, fmt.Sprintf("%s %s %s", sortFields[key.Field].column, op, w.arg(values[i])))
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	w.conds = append(w.conds, "("+strings.Join(ors, " OR ")+")")
+}
@@ -0,0 +1,85 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidStatement = errors.New("invalid statement")
+
+// StatementLine is an expense read from a bank or card statement,
+// FITID is the bank's ID of the transaction and is unique within the account.
+type StatementLine struct {
+	Account string
+	FITID   string
+	Expense Expense
+}
+
+// StatementImport tells which lines of a statement became expenses.
+type StatementImport struct {
+	Imported int `json:"imported"`
+	// Duplicates are the lines imported before, by an earlier upload of the statement or an overlapping one.
+	Duplicates int       `json:"duplicates"`
+	Expenses   []Expense `json:"expenses"`
+}
+
+// ImportStatement creates the expenses of the statement lines in a single transaction, skipping every line
+// whose account and FITID were imported before, so importing the same statement again creates nothing.
+func (s *Service) ImportStatement(ctx context.Context, lines []StatementLine) (StatementImport, error) {
+	for i, l := range lines {
+		if l.FITID == "" {
+			return StatementImport{}, fmt.Errorf("%w: line %d has no FITID", ErrInvalidStatement, i+1)
+		}
+	}
+	uid, err := owner(ctx)
+	if err != nil {
+		return StatementImport{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return StatementImport{}, fmt.Errorf("ImportStatement(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	prepared := &preparedOnce{tx: tx, stmts: make(map[string]*sql.Stmt)}
+	out := StatementImport{Expenses: []Expense{}}
+	for _, l := range lines {
+		claim, err := prepared.PrepareContext(ctx, `INSERT INTO statement_transactions(owner_id, account, fitid) VALUES($1, $2, $3) ON CONFLICT DO NOTHING`)
+		if err != nil {
+			return StatementImport{}, fmt.Errorf("ImportStatement(): db prepare context failure: %w", err)
+		}
+		res, err := claim.ExecContext(ctx, uid, l.Account, l.FITID)
+		if err != nil {
+			return StatementImport{}, fmt.Errorf("ImportStatement(): db exec: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return StatementImport{}, fmt.Errorf("ImportStatement(): db rows affected: %w", err)
+		} else if n == 0 {
+			out.Duplicates++
+			continue
+		}
+
+		created, err := s.create(ctx, prepared, l.Expense)
+		if err != nil {
+			return StatementImport{}, fmt.Errorf("ImportStatement(): FITID %s: %w", l.FITID, err)
+		}
+
+		link, err := prepared.PrepareContext(ctx, `UPDATE statement_transactions SET expense_id=$1 WHERE owner_id=$2 AND account=$3 AND fitid=$4`)
+		if err != nil {
+			return StatementImport{}, fmt.Errorf("ImportStatement(): db prepare context failure: %w", err)
+		}
+		if _, err := link.ExecContext(ctx, created.ID, uid, l.Account, l.FITID); err != nil {
+			return StatementImport{}, fmt.Errorf("ImportStatement(): db exec: %w", err)
+		}
+		out.Imported++
+		out.Expenses = append(out.Expenses, created)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return StatementImport{}, fmt.Errorf("ImportStatement(): db commit: %w", err)
+	}
+	return out, nil
+}
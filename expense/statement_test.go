@@ -0,0 +1,68 @@
+package expense_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestImportStatement(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	lines := []expn.StatementLine{
+		{Account: "123", FITID: "T1", Expense: expn.Expense{Title: "Tops", Amount: expn.Money{Minor: 6000, Currency: "THB"}, SpentAt: at}},
+		{Account: "123", FITID: "T0", Expense: expn.Expense{Title: "Lotus", Amount: expn.Money{Minor: 100, Currency: "THB"}, SpentAt: at}},
+	}
+
+	t.Run("Skips imported before", func(t *testing.T) {
+		mock.ExpectBegin()
+		claim := mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO statement_transactions(owner_id, account, fitid) VALUES($1, $2, $3) ON CONFLICT DO NOTHING`))
+		claim.ExpectExec().WithArgs(alice.ID, "123", "T1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id)`)).
+			ExpectQuery().
+			WithArgs("Tops", 6000, "THB", "", pq.Array([]string(nil)), at, alice.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+				AddRow(7, "Tops", 6000, "THB", "", pq.Array([]string{}), at, at, at))
+		expectRecord(mock, 7, 1, expn.ActionCreate)
+		mock.ExpectPrepare(regexp.QuoteMeta(`UPDATE statement_transactions SET expense_id=$1 WHERE owner_id=$2 AND account=$3 AND fitid=$4`)).
+			ExpectExec().WithArgs(7, alice.ID, "123", "T1").WillReturnResult(sqlmock.NewResult(0, 1))
+		claim.ExpectExec().WithArgs(alice.ID, "123", "T0").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.ImportStatement(ctx, lines)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, got.Imported)
+		assert.Equal(t, 1, got.Duplicates)
+		if assert.Equal(t, 1, len(got.Expenses)) {
+			assert.Equal(t, int64(7), got.Expenses[0].ID)
+		}
+	})
+
+	t.Run("Missing FITID", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.ImportStatement(ctx, []expn.StatementLine{{Account: "123"}})
+
+		assert.ErrorIs(t, err, expn.ErrInvalidStatement)
+	})
+}
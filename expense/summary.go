@@ -0,0 +1,230 @@
+package expense
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var ErrInvalidSummary = errors.New("invalid summary")
+
+// Allocation is how the amount of an expense with several tags is counted when grouping by tag.
+type Allocation string
+
+const (
+	// AllocateFull counts the whole amount in the bucket of every tag, so the buckets add up to more than was spent.
+	AllocateFull Allocation = "full"
+	// AllocateEven splits the amount evenly across the tags, the minor units left over go to the first tags,
+	// so the buckets add up to exactly what was spent.
+	AllocateEven Allocation = "even"
+)
+
+// periods are the group_by values bucketing expenses by the date they were spent, in UTC.
+var periods = map[string]string{
+	"day":   dateLayout,
+	"week":  dateLayout,
+	"month": monthLayout,
+	"year":  "2006",
+}
+
+// SummaryOptions selects the buckets of a summary.
+type SummaryOptions struct {
+	Filter ListFilter
+	// GroupBy holds "tag" and at most one period of day, week, month or year.
+	// The buckets are always split by currency too, unless ConvertTo is set.
+	GroupBy []string
+	// Allocation applies when grouping by tag, AllocateFull by default.
+	Allocation Allocation
+	// ConvertTo totals the buckets in the currency, with the rate of the day each expense was spent.
+	ConvertTo string
+}
+
+// Bucket sums the expenses sharing a tag, a period and a currency.
+type Bucket struct {
+	// Tag is nil for the untagged expenses, and when not grouping by tag.
+	Tag *string `json:"tag,omitempty"`
+	// Period is the day, week (starting Monday), month or year, such as "2026-10".
+	Period   string `json:"period,omitempty"`
+	Currency string `json:"currency"`
+
+	Count   int64 `json:"count"`
+	Total   Money `json:"total"`
+	Average Money `json:"average"`
+	Min     Money `json:"min"`
+	Max     Money `json:"max"`
+}
+
+// Summary is the buckets of a summary with the way they were computed.
+type Summary struct {
+	GroupBy    []string   `json:"group_by"`
+	Allocation Allocation `json:"allocation"`
+	Buckets    []Bucket   `json:"buckets"`
+}
+
+// Validate reports the first invalid option.
+func (o *SummaryOptions) Validate() error {
+	seen := map[string]bool{}
+	period := ""
+	for _, g := range o.GroupBy {
+		if _, ok := periods[g]; ok {
+			if period != "" {
+				return fmt.Errorf("%w: group_by can hold only one of day, week, month or year", ErrInvalidSummary)
+			}
+			period = g
+		} else if g != "tag" {
+			return fmt.Errorf("%w: group_by %q must be tag, day, week, month or year", ErrInvalidSummary, g)
+		}
+		if seen[g] {
+			return fmt.Errorf("%w: group_by %q is repeated", ErrInvalidSummary, g)
+		}
+		seen[g] = true
+	}
+	switch o.Allocation {
+	case "":
+		o.Allocation = AllocateFull
+	case AllocateFull, AllocateEven:
+	default:
+		return fmt.Errorf("%w: allocation %q must be full or even", ErrInvalidSummary, o.Allocation)
+	}
+	if o.ConvertTo != "" {
+		if _, err := Exponent(o.ConvertTo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Summarize totals, counts, averages and min/max the expenses per bucket, in SQL.
+// When grouping by tag each tag of an expense is a bucket, and the untagged expenses have a bucket of their own.
+func (s *Service) Summarize(ctx context.Context, opts SummaryOptions) (Summary, error) {
+	if err := opts.Validate(); err != nil {
+		return Summary{}, err
+	}
+	w, err := filtered(ctx, opts.Filter)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	byTag := false
+	period := ""
+	for _, g := range opts.GroupBy {
+		if g == "tag" {
+			byTag = true
+		} else {
+			period = g
+		}
+	}
+
+	from := "expenses"
+	tag := "NULL::TEXT"
+	share := "amount"
+	if byTag {
+		// an untagged expense unnests into a single NULL tag, so it is still counted.
+		from = "expenses CROSS JOIN LATERAL unnest(COALESCE(NULLIF(tags, '{}'), ARRAY[NULL]::TEXT[])) WITH ORDINALITY AS t(tag, ord)"
+		tag = "tag"
+		if opts.Allocation == AllocateEven {
+			share = "amount / GREATEST(cardinality(tags), 1) + CASE WHEN ord <= abs(amount % GREATEST(cardinality(tags), 1)) THEN sign(amount)::BIGINT ELSE 0 END"
+		}
+	}
+	periodCol := "NULL::TIMESTAMP"
+	if period != "" {
+		periodCol = fmt.Sprintf("date_trunc('%s', spent_at AT TIME ZONE 'UTC')", period)
+	}
+	// converting needs the day of every sum, the rows of a bucket are merged once converted.
+	day := "NULL::DATE"
+	if opts.ConvertTo != "" {
+		day = "(spent_at AT TIME ZONE 'UTC')::DATE"
+	}
+
+	query := fmt.Sprintf(`SELECT tag, period, currency, day, COUNT(*), SUM(share)::BIGINT, MIN(share), MAX(share) from (
+		SELECT %s AS tag, %s AS period, currency, %s AS day, %s AS share from %s where %s
+	) s GROUP BY tag, period, currency, day ORDER BY tag NULLS LAST, period, currency, day`, tag, periodCol, day, share, from, w)
+
+	rows, err := s.db.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return Summary{}, fmt.Errorf("Summarize(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	type partial struct {
+		bucket Bucket
+		day    *time.Time
+	}
+	var parts []partial
+	for rows.Next() {
+		var p partial
+		var periodAt *time.Time
+		if err := rows.Scan(&p.bucket.Tag, &periodAt, &p.bucket.Currency, &p.day, &p.bucket.Count, &p.bucket.Total.Minor, &p.bucket.Min.Minor, &p.bucket.Max.Minor); err != nil {
+			return Summary{}, fmt.Errorf("Summarize(): db scan row: %w", err)
+		}
+		if periodAt != nil {
+			p.bucket.Period = periodAt.Format(periods[period])
+		}
+		p.bucket.Total.Currency, p.bucket.Min.Currency, p.bucket.Max.Currency = p.bucket.Currency, p.bucket.Currency, p.bucket.Currency
+		parts = append(parts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return Summary{}, fmt.Errorf("Summarize(): db rows: %w", err)
+	}
+
+	out := Summary{GroupBy: opts.GroupBy, Allocation: opts.Allocation, Buckets: make([]Bucket, 0, len(parts))}
+	if out.GroupBy == nil {
+		out.GroupBy = []string{}
+	}
+	if opts.ConvertTo == "" {
+		for _, p := range parts {
+			out.Buckets = append(out.Buckets, average(p.bucket))
+		}
+		return out, nil
+	}
+
+	// the totals, minimums and maximums convert with the rate of their day, and a positive rate keeps their order.
+	sums := make([]Expense, 0, 3*len(parts))
+	for _, p := range parts {
+		for _, m := range []Money{p.bucket.Total, p.bucket.Min, p.bucket.Max} {
+			sums = append(sums, Expense{Amount: m, SpentAt: *p.day})
+		}
+	}
+	if err := s.convertAll(ctx, sums, opts.ConvertTo); err != nil {
+		return Summary{}, err
+	}
+
+	index := make(map[string]int)
+	for i, p := range parts {
+		total, min, max := sums[3*i].Converted.Amount, sums[3*i+1].Converted.Amount, sums[3*i+2].Converted.Amount
+		b := p.bucket
+		key := fmt.Sprintf("%v\x00%s", b.Tag != nil, b.Period)
+		if b.Tag != nil {
+			key += "\x00" + *b.Tag
+		}
+		j, ok := index[key]
+		if !ok {
+			index[key] = len(out.Buckets)
+			out.Buckets = append(out.Buckets, Bucket{Tag: b.Tag, Period: b.Period, Currency: opts.ConvertTo, Count: b.Count, Total: total, Min: min, Max: max})
+			continue
+		}
+		merged := &out.Buckets[j]
+		merged.Count += b.Count
+		if merged.Total, err = merged.Total.Add(total); err != nil {
+			return Summary{}, err
+		}
+		if min.Minor < merged.Min.Minor {
+			merged.Min = min
+		}
+		if max.Minor > merged.Max.Minor {
+			merged.Max = max
+		}
+	}
+	for i := range out.Buckets {
+		out.Buckets[i] = average(out.Buckets[i])
+	}
+	return out, nil
+}
+
+// average sets the average of the bucket, rounded half away from zero.
+func average(b Bucket) Bucket {
+	b.Average, _ = convert(b.Total, big.NewRat(1, b.Count), b.Total.Currency)
+	return b
+}
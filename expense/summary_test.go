@@ -0,0 +1,105 @@
+package expense_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+func TestSummarize(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	october := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	food := "food"
+
+	t.Run("By tag and month evenly", func(t *testing.T) {
+		from := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT tag, period, currency, day, COUNT(*), SUM(share)::BIGINT, MIN(share), MAX(share) from (
+		SELECT tag AS tag, date_trunc('month', spent_at AT TIME ZONE 'UTC') AS period, currency, NULL::DATE AS day, amount / GREATEST(cardinality(tags), 1) + CASE WHEN ord <= abs(amount % GREATEST(cardinality(tags), 1)) THEN sign(amount)::BIGINT ELSE 0 END AS share from expenses CROSS JOIN LATERAL unnest(COALESCE(NULLIF(tags, '{}'), ARRAY[NULL]::TEXT[])) WITH ORDINALITY AS t(tag, ord) where owner_id = $1 AND deleted_at IS NULL AND spent_at >= $2
+	) s GROUP BY tag, period, currency, day ORDER BY tag NULLS LAST, period, currency, day`)).
+			WithArgs(alice.ID, from).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"tag", "period", "currency", "day", "count", "sum", "min", "max"}).
+					AddRow("food", october, "THB", nil, 3, 10000, 1000, 5001).
+					AddRow(nil, october, "THB", nil, 1, 2500, 2500, 2500),
+			)
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Summarize(ctx, expn.SummaryOptions{
+			Filter:     expn.ListFilter{From: &from},
+			GroupBy:    []string{"tag", "month"},
+			Allocation: expn.AllocateEven,
+		})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, expn.AllocateEven, got.Allocation)
+		if assert.Equal(t, 2, len(got.Buckets)) {
+			assert.Equal(t, expn.Bucket{
+				Tag: &food, Period: "2026-10", Currency: "THB", Count: 3,
+				Total:   expn.Money{Minor: 10000, Currency: "THB"},
+				Average: expn.Money{Minor: 3333, Currency: "THB"},
+				Min:     expn.Money{Minor: 1000, Currency: "THB"},
+				Max:     expn.Money{Minor: 5001, Currency: "THB"},
+			}, got.Buckets[0])
+			assert.Nil(t, got.Buckets[1].Tag)
+		}
+	})
+
+	t.Run("Converted", func(t *testing.T) {
+		day := time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT NULL::TEXT AS tag, NULL::TIMESTAMP AS period, currency, (spent_at AT TIME ZONE 'UTC')::DATE AS day, amount AS share from expenses where`)).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"tag", "period", "currency", "day", "count", "sum", "min", "max"}).
+					AddRow(nil, nil, "THB", day, 2, 20000, 5000, 15000).
+					AddRow(nil, nil, "USD", day, 1, 1000, 1000, 1000),
+			)
+		mock.ExpectQuery(regexp.QuoteMeta(`from exchange_rates`)).
+			WillReturnRows(sqlmock.NewRows([]string{"date", "base", "quote", "rate"}).AddRow(day, "USD", "THB", "36.5"))
+
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.Summarize(ctx, expn.SummaryOptions{ConvertTo: "THB"})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		if assert.Equal(t, 1, len(got.Buckets)) {
+			// 200 THB + 10 USD * 36.5 = 565 THB over 3 expenses.
+			b := got.Buckets[0]
+			assert.Equal(t, int64(3), b.Count)
+			assert.Equal(t, expn.Money{Minor: 56500, Currency: "THB"}, b.Total)
+			assert.Equal(t, expn.Money{Minor: 18833, Currency: "THB"}, b.Average)
+			assert.Equal(t, expn.Money{Minor: 5000, Currency: "THB"}, b.Min)
+			assert.Equal(t, expn.Money{Minor: 36500, Currency: "THB"}, b.Max)
+		}
+	})
+
+	t.Run("Invalid group by", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), alice)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.Summarize(ctx, expn.SummaryOptions{GroupBy: []string{"month", "year"}})
+
+		assert.ErrorIs(t, err, expn.ErrInvalidSummary)
+	})
+}
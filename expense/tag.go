@@ -0,0 +1,307 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNoTag      = errors.New("no tag")
+	ErrTagExists  = errors.New("tag already exists")
+	ErrInvalidTag = errors.New("invalid tag")
+)
+
+// Tag is a tag shared by the expenses of every user, Expenses counts those tagged with it.
+type Tag struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Expenses int64  `json:"expenses"`
+}
+
+// CanonicalTag is the form a tag is stored in, lower case with single spaces, so "Food " and "food" are one tag.
+func CanonicalTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// CanonicalTags canonicalises tags in their order, dropping the blank and repeated ones.
+func CanonicalTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = CanonicalTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// syncTags links an expense to its tags in expense_tags, creating the tags not seen before.
+// The tags column of the expense keeps a copy of them, which the list filters and summaries read.
+func syncTags(ctx context.Context, db preparer, id int64, tags []string) error {
+	stmt, err := db.PrepareContext(ctx, `WITH names AS (SELECT unnest($2::text[]) AS name),
+		tagged AS (INSERT INTO tags(name) SELECT name FROM names ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id),
+		untagged AS (DELETE FROM expense_tags WHERE expense_id = $1 AND tag_id NOT IN (SELECT id FROM tagged))
+		INSERT INTO expense_tags(expense_id, tag_id) SELECT $1, id FROM tagged ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("syncTags(): db prepare context failure: %w", err)
+	}
+	if _, err := stmt.ExecContext(ctx, id, pq.Array(tags)); err != nil {
+		return fmt.Errorf("syncTags(): db exec: %w", err)
+	}
+	return nil
+}
+
+// replaceTags returns tags with those in from replaced by into, keeping the first place of into.
+func replaceTags(tags []string, from []string, into string) []string {
+	replaced := make([]string, len(tags))
+	for i, tag := range tags {
+		replaced[i] = tag
+		for _, f := range from {
+			if tag == f {
+				replaced[i] = into
+			}
+		}
+	}
+	return CanonicalTags(replaced)
+}
+
+// Tags lists every tag by name.
+func (s *Service) Tags(ctx context.Context) ([]Tag, error) {
+	query := `SELECT t.id, t.name, count(et.expense_id) from tags t LEFT JOIN expense_tags et ON et.tag_id = t.id GROUP BY t.id ORDER BY t.name`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("Tags(): db query context: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]Tag, 0)
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Expenses); err != nil {
+			return nil, fmt.Errorf("Tags(): db scan row: %w", err)
+		}
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Tags(): db rows: %w", err)
+	}
+	return out, nil
+}
+
+// RenameTag renames a tag on the expenses, recurring expenses and budgets of every user at once.
+// Renaming to a tag which exists or has budgets is refused with ErrTagExists, MergeTags joins them instead.
+func (s *Service) RenameTag(ctx context.Context, from, to string) (Tag, error) {
+	from, to = CanonicalTag(from), CanonicalTag(to)
+	if from == "" || to == "" {
+		return Tag{}, fmt.Errorf("%w: tag must not be empty", ErrInvalidTag)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Tag{}, fmt.Errorf("RenameTag(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id from tags where name=$1 FOR UPDATE`, from).Scan(&id)
+	if err == sql.ErrNoRows {
+		return Tag{}, fmt.Errorf("%w: %q", ErrNoTag, from)
+	}
+	if err != nil {
+		return Tag{}, fmt.Errorf("RenameTag(): db scan row: %w", err)
+	}
+	if from != to {
+		_, err = tx.ExecContext(ctx, `UPDATE tags SET name=$1 WHERE id=$2`, to, id)
+		if uniqueViolation(err) {
+			return Tag{}, fmt.Errorf("%w: %q, merge the tags instead", ErrTagExists, to)
+		}
+		if err != nil {
+			return Tag{}, fmt.Errorf("RenameTag(): db exec: %w", err)
+		}
+		// budgets are not linked to the tags, a user's budget on to would be lost to the one on from.
+		var budgeted bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 from budgets where tag=$1)`, to).Scan(&budgeted); err != nil {
+			return Tag{}, fmt.Errorf("RenameTag(): db scan row: %w", err)
+		}
+		if budgeted {
+			return Tag{}, fmt.Errorf("%w: %q has budgets, merge the tags instead", ErrTagExists, to)
+		}
+		if err := s.retag(ctx, tx, []string{from}, to); err != nil {
+			return Tag{}, err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE budgets SET tag=$1, updated_at=now() WHERE tag=$2`, to, from)
+		if uniqueViolation(err) {
+			return Tag{}, fmt.Errorf("%w: %q has budgets, merge the tags instead", ErrTagExists, to)
+		}
+		if err != nil {
+			return Tag{}, fmt.Errorf("RenameTag(): db exec: %w", err)
+		}
+	}
+
+	out, err := countTag(ctx, tx, id, to)
+	if err != nil {
+		return Tag{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Tag{}, fmt.Errorf("RenameTag(): db commit: %w", err)
+	}
+	return out, nil
+}
+
+// MergeTags replaces the tags from by into on the expenses, recurring expenses and budgets of every user
+// and removes them, all at once. into is created when it does not exist. A user with budgets on several
+// of the merged tags keeps the one on into, or else the oldest.
+func (s *Service) MergeTags(ctx context.Context, from []string, into string) (Tag, error) {
+	into = CanonicalTag(into)
+	if into == "" {
+		return Tag{}, fmt.Errorf("%w: tag must not be empty", ErrInvalidTag)
+	}
+	var sources []string
+	for _, tag := range CanonicalTags(from) {
+		if tag != into {
+			sources = append(sources, tag)
+		}
+	}
+	if len(sources) == 0 {
+		return Tag{}, fmt.Errorf("%w: no tag to merge into %q", ErrInvalidTag, into)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Tag{}, fmt.Errorf("MergeTags(): db begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, name from tags where name = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(sources))
+	if err != nil {
+		return Tag{}, fmt.Errorf("MergeTags(): db query context: %w", err)
+	}
+	var ids []int64
+	found := make(map[string]bool)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return Tag{}, fmt.Errorf("MergeTags(): db scan row: %w", err)
+		}
+		ids = append(ids, id)
+		found[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Tag{}, fmt.Errorf("MergeTags(): db rows: %w", err)
+	}
+	var missing []string
+	for _, tag := range sources {
+		if !found[tag] {
+			missing = append(missing, fmt.Sprintf("%q", tag))
+		}
+	}
+	if len(missing) > 0 {
+		return Tag{}, fmt.Errorf("%w: %s", ErrNoTag, strings.Join(missing, ", "))
+	}
+
+	var id int64
+	query := `INSERT INTO tags(name) VALUES($1) ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, into).Scan(&id); err != nil {
+		return Tag{}, fmt.Errorf("MergeTags(): db scan row: %w", err)
+	}
+	query = `INSERT INTO expense_tags(expense_id, tag_id) SELECT expense_id, $1 FROM expense_tags WHERE tag_id = ANY($2) ON CONFLICT DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, id, pq.Array(ids)); err != nil {
+		return Tag{}, fmt.Errorf("MergeTags(): db exec: %w", err)
+	}
+	if err := s.retag(ctx, tx, sources, into); err != nil {
+		return Tag{}, err
+	}
+	query = `DELETE FROM budgets b WHERE b.tag = ANY($1) AND EXISTS (
+			SELECT 1 FROM budgets o WHERE o.owner_id = b.owner_id AND (o.tag = $2 OR (o.tag = ANY($1) AND o.id < b.id))
+		)`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(sources), into); err != nil {
+		return Tag{}, fmt.Errorf("MergeTags(): db exec: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE budgets SET tag=$1, updated_at=now() WHERE tag = ANY($2)`, into, pq.Array(sources)); err != nil {
+		return Tag{}, fmt.Errorf("MergeTags(): db exec: %w", err)
+	}
+	// the links of the merged tags go with them.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return Tag{}, fmt.Errorf("MergeTags(): db exec: %w", err)
+	}
+
+	out, err := countTag(ctx, tx, id, into)
+	if err != nil {
+		return Tag{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Tag{}, fmt.Errorf("MergeTags(): db commit: %w", err)
+	}
+	return out, nil
+}
+
+// retag replaces the tags from by into in the tags columns of the expenses, as a new revision of each,
+// and of the recurring expenses. The budgets are left to the caller.
+func (s *Service) retag(ctx context.Context, tx *sql.Tx, from []string, into string) error {
+	query := `SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at, version from expenses where tags && $1 ORDER BY id FOR UPDATE`
+	rows, err := tx.QueryContext(ctx, query, pq.Array(from))
+	if err != nil {
+		return fmt.Errorf("retag(): db query context: %w", err)
+	}
+	var tagged []Expense
+	for rows.Next() {
+		var e Expense
+		if err := rows.Scan(&e.ID, &e.Title, &e.Amount.Minor, &e.Amount.Currency, &e.Note, pq.Array(&e.Tags), &e.SpentAt, &e.CreatedAt, &e.UpdatedAt, &e.DeletedAt, &e.Version); err != nil {
+			rows.Close()
+			return fmt.Errorf("retag(): db scan row: %w", err)
+		}
+		tagged = append(tagged, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("retag(): db rows: %w", err)
+	}
+
+	prepared := &preparedOnce{tx: tx, stmts: make(map[string]*sql.Stmt)}
+	for _, before := range tagged {
+		after := before
+		after.Tags = replaceTags(before.Tags, from, into)
+		stmt, err := prepared.PrepareContext(ctx, `UPDATE expenses SET tags=$1, updated_at=now(), version=version+1 WHERE id=$2 RETURNING updated_at, version`)
+		if err != nil {
+			return fmt.Errorf("retag(): db prepare context failure: %w", err)
+		}
+		if err := stmt.QueryRowContext(ctx, pq.Array(after.Tags), after.ID).Scan(&after.UpdatedAt, &after.Version); err != nil {
+			return fmt.Errorf("retag(): db scan row: %w", err)
+		}
+		if err := s.record(ctx, prepared, ActionUpdate, &before, after, nil); err != nil {
+			return err
+		}
+	}
+
+	query = `UPDATE recurring_expenses SET tags = ARRAY(
+			SELECT r.tag FROM unnest(tags) WITH ORDINALITY u(tag, ord), LATERAL (SELECT CASE WHEN u.tag = ANY($1) THEN $2 ELSE u.tag END) r(tag)
+			GROUP BY r.tag ORDER BY min(u.ord)
+		), updated_at=now() WHERE tags && $1`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(from), into); err != nil {
+		return fmt.Errorf("retag(): db exec: %w", err)
+	}
+	return nil
+}
+
+func countTag(ctx context.Context, tx *sql.Tx, id int64, name string) (Tag, error) {
+	out := Tag{ID: id, Name: name}
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) from expense_tags where tag_id=$1`, id).Scan(&out.Expenses); err != nil {
+		return Tag{}, fmt.Errorf("countTag(): db scan row: %w", err)
+	}
+	return out, nil
+}
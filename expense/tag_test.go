@@ -0,0 +1,281 @@
+package expense_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// admin may rename and merge the tags of everyone.
+var admin = auth.Principal{ID: "root", Role: "admin"}
+
+// expectTags expects a write to link an expense to its tags.
+func expectTags(mock sqlmock.Sqlmock, id int64, tags ...string) {
+	mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expense_tags(expense_id, tag_id)`)).
+		ExpectExec().
+		WithArgs(id, pq.Array(tags)).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(tags))))
+}
+
+func TestCanonicalTags(t *testing.T) {
+	assert.Equal(t, []string{"food", "street food", "อาหาร"}, expn.CanonicalTags([]string{"Food", " street  FOOD ", "food", "", "อาหาร"}))
+	assert.Nil(t, expn.CanonicalTags(nil))
+}
+
+func TestCreateCanonicalisesTags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id)`)).
+		ExpectQuery().
+		WithArgs("coffee", 4500, "THB", "", pq.Array([]string{"food", "coffee shop"}), nil, alice.ID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+				AddRow(1, "coffee", 4500, "THB", "", pq.Array([]string{"food", "coffee shop"}), at, at, at),
+		)
+	expectTags(mock, 1, "food", "coffee shop")
+	expectRecord(mock, 1, 1, expn.ActionCreate)
+	expectBudgets(mock, 1, "food", "coffee shop")
+	mock.ExpectCommit()
+
+	ctx := auth.NewContext(context.Background(), alice)
+	expense, _ := expn.NewService(ctx, db)
+
+	got, err := expense.Create(ctx, expn.Expense{Title: "coffee", Amount: expn.Money{Minor: 4500, Currency: "THB"}, Tags: []string{"Food", "Coffee  Shop", "FOOD"}})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"food", "coffee shop"}, got.Tags)
+}
+
+// expectRetag expects the expense 7 of alice tagged foods and food to be retagged by admin, and then
+// the recurring expenses.
+func expectRetag(mock sqlmock.Sqlmock, from []string, into string, after []string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at, version from expenses where tags && $1 ORDER BY id FOR UPDATE`)).
+		WithArgs(pq.Array(from)).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "deleted_at", "version"}).
+				AddRow(7, "ข้าวมันไก่", 6000, "THB", "", pq.Array([]string{"foods", "lunch", "food"}), at, at, at, nil, 2),
+		)
+	mock.ExpectPrepare(regexp.QuoteMeta(`UPDATE expenses SET tags=$1, updated_at=now(), version=version+1 WHERE id=$2 RETURNING updated_at, version`)).
+		ExpectQuery().
+		WithArgs(pq.Array(after), int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at", "version"}).AddRow(at, 3))
+	mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expense_history`)).
+		ExpectExec().
+		WithArgs(int64(7), int64(3), admin.ID, expn.ActionUpdate, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE recurring_expenses SET tags = ARRAY(`)).
+		WithArgs(pq.Array(from), into).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestRenameTag(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	budgeted := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 from budgets where tag=$1)`)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id from tags where name=$1 FOR UPDATE`)).
+			WithArgs("foods").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE tags SET name=$1 WHERE id=$2`)).
+			WithArgs("meals", int64(4)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(budgeted).
+			WithArgs("meals").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		expectRetag(mock, []string{"foods"}, "meals", []string{"meals", "lunch", "food"})
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE budgets SET tag=$1, updated_at=now() WHERE tag=$2`)).
+			WithArgs("meals", "foods").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) from expense_tags where tag_id=$1`)).
+			WithArgs(int64(4)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), admin)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.RenameTag(ctx, "Foods", "Meals")
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, expn.Tag{ID: 4, Name: "meals", Expenses: 1}, got)
+	})
+
+	t.Run("Tag exists", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id from tags where name=$1 FOR UPDATE`)).
+			WithArgs("foods").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE tags SET name=$1 WHERE id=$2`)).
+			WithArgs("food", int64(4)).
+			WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), admin)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.RenameTag(ctx, "foods", "food")
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrTagExists)
+	})
+
+	t.Run("Budget on the new name", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id from tags where name=$1 FOR UPDATE`)).
+			WithArgs("foods").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE tags SET name=$1 WHERE id=$2`)).
+			WithArgs("meals", int64(4)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(budgeted).
+			WithArgs("meals").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), admin)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.RenameTag(ctx, "foods", "meals")
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrTagExists)
+	})
+
+	t.Run("Blank tag", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), admin)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.RenameTag(ctx, "foods", "  ")
+
+		assert.ErrorIs(t, err, expn.ErrInvalidTag)
+	})
+}
+
+func TestMergeTags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	sources := regexp.QuoteMeta(`SELECT id, name from tags where name = ANY($1) ORDER BY id FOR UPDATE`)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sources).
+			WithArgs(pq.Array([]string{"foods"})).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(4, "foods"))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO tags(name) VALUES($1) ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id`)).
+			WithArgs("food").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_tags(expense_id, tag_id) SELECT expense_id, $1 FROM expense_tags WHERE tag_id = ANY($2) ON CONFLICT DO NOTHING`)).
+			WithArgs(int64(1), pq.Array([]int64{4})).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectRetag(mock, []string{"foods"}, "food", []string{"food", "lunch"})
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM budgets b WHERE b.tag = ANY($1)`)).
+			WithArgs(pq.Array([]string{"foods"}), "food").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE budgets SET tag=$1, updated_at=now() WHERE tag = ANY($2)`)).
+			WithArgs("food", pq.Array([]string{"foods"})).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM tags WHERE id = ANY($1)`)).
+			WithArgs(pq.Array([]int64{4})).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) from expense_tags where tag_id=$1`)).
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+		mock.ExpectCommit()
+
+		ctx := auth.NewContext(context.Background(), admin)
+		expense, _ := expn.NewService(ctx, db)
+
+		got, err := expense.MergeTags(ctx, []string{"Foods", "FOOD"}, "food")
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, expn.Tag{ID: 1, Name: "food", Expenses: 12}, got)
+	})
+
+	t.Run("Unknown tag", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sources).
+			WithArgs(pq.Array([]string{"foods", "eats"})).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(4, "foods"))
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), admin)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.MergeTags(ctx, []string{"foods", "eats"}, "food")
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, expn.ErrNoTag)
+		assert.Contains(t, err.Error(), `"eats"`)
+	})
+
+	t.Run("Nothing to merge", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), admin)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.MergeTags(ctx, []string{"Food"}, "food")
+
+		assert.ErrorIs(t, err, expn.ErrInvalidTag)
+	})
+
+	t.Run("Some error", func(t *testing.T) {
+		errwant := errors.New("some error")
+		mock.ExpectBegin()
+		mock.ExpectQuery(sources).WillReturnError(errwant)
+		mock.ExpectRollback()
+
+		ctx := auth.NewContext(context.Background(), admin)
+		expense, _ := expn.NewService(ctx, db)
+
+		_, err := expense.MergeTags(ctx, []string{"foods"}, "food")
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, errwant)
+	})
+}
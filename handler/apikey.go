@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/dakeeChv/assessment/apikey"
+	"github.com/dakeeChv/assessment/auth"
+)
+
+// apiKeySecret is a key together with its secret, which is only ever shown in this response.
+type apiKeySecret struct {
+	apikey.Key
+	Secret string `json:"secret"`
+}
+
+func (h *Handler) CreateAPIKey(c echo.Context) error {
+	var req struct {
+		Name    string   `json:"name"`
+		OwnerID string   `json:"owner_id"`
+		Scopes  []string `json:"scopes"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding json body, Please pass a valid json body",
+		})
+	}
+	ctx := c.Request().Context()
+	if req.OwnerID == "" {
+		p, _ := auth.FromContext(ctx)
+		req.OwnerID = p.ID
+	}
+
+	key, secret, err := h.apikeys.Create(ctx, req.Name, req.OwnerID, req.Scopes)
+	if errors.Is(err, apikey.ErrInvalidScope) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": err.Error(),
+		})
+	}
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, apiKeySecret{Key: key, Secret: secret})
+}
+
+func (h *Handler) ListAPIKeys(c echo.Context) error {
+	ctx := c.Request().Context()
+	resp, err := h.apikeys.List(ctx)
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) RotateAPIKey(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	key, secret, err := h.apikeys.Rotate(ctx, id)
+	if errors.Is(err, apikey.ErrNoKey) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, an active api key with ID: %d", id),
+		})
+	}
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, apiKeySecret{Key: key, Secret: secret})
+}
+
+func (h *Handler) RevokeAPIKey(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	err = h.apikeys.Revoke(ctx, id)
+	if errors.Is(err, apikey.ErrNoKey) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, an active api key with ID: %d", id),
+		})
+	}
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
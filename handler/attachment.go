@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/dakeeChv/assessment/blob"
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// maxAttachmentBody leaves room for the multipart headers around the largest attachment.
+const maxAttachmentBody = expn.MaxAttachmentSize + 64<<10
+
+// attachmentParams reads the expense id and, when the route has one, the attachment id.
+func attachmentParams(c echo.Context) (expenseID, id int64, err error) {
+	expenseID, err = strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if raw := c.Param("attachment_id"); raw != "" {
+		id, err = strconv.ParseInt(raw, 10, 64)
+	}
+	return expenseID, id, err
+}
+
+// CreateAttachment keeps the multipart "file" with an expense, a photo or PDF of its receipt.
+func (h *Handler) CreateAttachment(c echo.Context) error {
+	expenseID, _, err := attachmentParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	tooLarge := func() error {
+		return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{
+			"code":    413,
+			"status":  "Request Entity Too Large",
+			"Message": fmt.Sprintf("failed to binding request body, the file must be at most %d bytes", expn.MaxAttachmentSize),
+		})
+	}
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxAttachmentBody)
+	fh, err := c.FormFile("file")
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return tooLarge()
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding request body, %v", err),
+		})
+	}
+	if fh.Size > expn.MaxAttachmentSize {
+		return tooLarge()
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return internalError(c, err)
+	}
+	defer f.Close()
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.Attach(ctx, expenseID, fh.Filename, f)
+	if errors.Is(err, expn.ErrNoExpense) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, an expense with ID: %d", expenseID),
+		})
+	}
+	if errors.Is(err, expn.ErrAttachmentTooLarge) {
+		return tooLarge()
+	}
+	if errors.Is(err, expn.ErrUnsupportedAttachment) {
+		return c.JSON(http.StatusUnsupportedMediaType, echo.Map{
+			"code":    415,
+			"status":  "Unsupported Media Type",
+			"Message": err.Error(),
+		})
+	}
+	if errors.Is(err, expn.ErrInvalidAttachment) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding request body, %v", err),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// ListAttachments lists the attachments of an expense.
+func (h *Handler) ListAttachments(c echo.Context) error {
+	expenseID, _, err := attachmentParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.Attachments(ctx, expenseID)
+	if errors.Is(err, expn.ErrNoExpense) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, an expense with ID: %d", expenseID),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetAttachment downloads an attachment with its content type. Range requests are served,
+// and the content hash is the ETag for If-None-Match and If-Range.
+func (h *Handler) GetAttachment(c echo.Context) error {
+	expenseID, id, err := attachmentParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	a, f, err := h.expense.OpenAttachment(ctx, expenseID, id)
+	if errors.Is(err, expn.ErrNoAttachment) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, attachment %d of the expense with ID: %d", id, expenseID),
+		})
+	}
+	if errors.Is(err, blob.ErrNotFound) {
+		// the attachment is recorded but its content is gone from the blob store.
+		log.Printf("attachment %d of expense %d has no content: %v", id, expenseID, err)
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, the content of attachment %d of the expense with ID: %d", id, expenseID),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+	defer f.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, a.ContentType)
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	header.Set(HeaderETag, `"`+a.SHA256+`"`)
+	header.Set("Cache-Control", "private, max-age=0, must-revalidate")
+	http.ServeContent(c.Response(), c.Request(), a.Name, a.CreatedAt, f)
+	return nil
+}
+
+// DeleteAttachment removes an attachment of an expense.
+func (h *Handler) DeleteAttachment(c echo.Context) error {
+	expenseID, id, err := attachmentParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	err = h.expense.DeleteAttachment(ctx, expenseID, id)
+	if errors.Is(err, expn.ErrNoAttachment) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, attachment %d of the expense with ID: %d", id, expenseID),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
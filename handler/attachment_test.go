@@ -0,0 +1,52 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/blob"
+	expn "github.com/dakeeChv/assessment/expense"
+	"github.com/dakeeChv/assessment/handler"
+)
+
+func TestGetAttachmentContentGone(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	store, _ := blob.NewDisk(t.TempDir())
+	expense, _ := expn.NewService(ctx, db, expn.WithBlobStore(store))
+	h, _ := handler.NewHandler(ctx, expense, handler.WithLegacyDateAuth())
+	e := echo.New()
+	h.SetupRoute(e)
+
+	at := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	sum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT a.id, a.expense_id, a.name, a.content_type, a.size, a.sha256, a.created_at from attachments a`)).
+		WithArgs(int64(5), int64(1), handler.DefaultOwner).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "expense_id", "name", "content_type", "size", "sha256", "created_at"}).
+				AddRow(5, 1, "receipt.png", "image/png", 4, sum, at),
+		)
+
+	req := httptest.NewRequest(http.MethodGet, "/expenses/1/attachments/5", nil)
+	req.Header.Set(echo.HeaderAuthorization, "November 10, 2009")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// batchItem is the outcome of an expense of a batch, with the status its own POST /expenses would have answered.
+type batchItem struct {
+	Index   int           `json:"index"`
+	Status  int           `json:"status"`
+	Expense *expn.Expense `json:"expense,omitempty"`
+	Message string        `json:"message,omitempty"`
+}
+
+type batchResponse struct {
+	Atomic  bool        `json:"atomic"`
+	Results []batchItem `json:"results"`
+}
+
+// CreateExpenses creates a json array of expenses at once. By default the batch is atomic: it is created
+// as a whole or, when an expense is invalid, not at all and answered 400. With ?atomic=false the valid
+// expenses are created regardless, answered 207 with the status of every expense.
+func (h *Handler) CreateExpenses(c echo.Context) error {
+	var raw []json.RawMessage
+	if err := c.Bind(&raw); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding json body, Please pass a json array of expenses",
+		})
+	}
+	if len(raw) == 0 || len(raw) > expn.MaxBatch {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding json body, a batch holds 1 to %d expenses", expn.MaxBatch),
+		})
+	}
+	atomic := c.QueryParam("atomic") != "false"
+
+	resp := batchResponse{Atomic: atomic, Results: make([]batchItem, len(raw))}
+	var batch []expn.Expense
+	var index []int
+	for i, b := range raw {
+		resp.Results[i].Index = i
+		var e expn.Expense
+		if err := json.Unmarshal(b, &e); err != nil {
+			resp.Results[i].Status = http.StatusBadRequest
+			resp.Results[i].Message = bindMessage(err)
+			continue
+		}
+		batch = append(batch, e)
+		index = append(index, i)
+	}
+	if atomic && len(batch) < len(raw) {
+		return c.JSON(http.StatusBadRequest, rejected(resp))
+	}
+
+	if len(batch) > 0 {
+		ctx := c.Request().Context()
+		results, err := h.expense.CreateMany(ctx, batch, atomic)
+		if err != nil && !errors.Is(err, expn.ErrBatchRejected) {
+			return internalError(c, err)
+		}
+		for n, r := range results {
+			item := &resp.Results[index[n]]
+			if r.Err != nil {
+				item.Status = http.StatusBadRequest
+				item.Message = r.Err.Error()
+				continue
+			}
+			if r.Expense != nil {
+				item.Status = http.StatusCreated
+				item.Expense = r.Expense
+				h.warnOverspent(c, *r.Expense)
+			}
+		}
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, rejected(resp))
+		}
+	}
+
+	if !atomic {
+		return c.JSON(http.StatusMultiStatus, resp)
+	}
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// rejected marks the valid expenses of a rejected atomic batch as failing on the invalid ones.
+func rejected(resp batchResponse) batchResponse {
+	for i := range resp.Results {
+		if resp.Results[i].Status == 0 {
+			resp.Results[i].Status = http.StatusFailedDependency
+			resp.Results[i].Message = "not created, another expense of the batch is invalid"
+		}
+	}
+	return resp
+}
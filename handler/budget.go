@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// HeaderBudgetWarning is added for every budget an expense write pushes over its limit.
+const HeaderBudgetWarning = "X-Budget-Warning"
+
+// warnOverspent adds a warning header for each budget the write of e pushed over its limit.
+func (h *Handler) warnOverspent(c echo.Context, e expn.Expense) {
+	for _, st := range e.Overspent {
+		c.Response().Header().Add(HeaderBudgetWarning, fmt.Sprintf("tag=%s; month=%s; spent=%s; limit=%s; currency=%s; percent_used=%.1f",
+			strconv.QuoteToASCII(st.Budget.Tag), st.Month, st.Spent, st.Budget.Amount, st.Budget.Amount.Currency, st.PercentUsed))
+	}
+}
+
+// budgetError answers the errors of the budget service.
+func budgetError(c echo.Context, err error, id int64) error {
+	if errors.Is(err, expn.ErrInvalidBudget) || errors.Is(err, expn.ErrUnknownCurrency) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": err.Error(),
+		})
+	}
+	if errors.Is(err, expn.ErrBudgetExists) {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"code":    409,
+			"status":  "Conflict",
+			"Message": err.Error(),
+		})
+	}
+	if errors.Is(err, expn.ErrNoBudget) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, a budget with ID: %d", id),
+		})
+	}
+	if errors.Is(err, expn.ErrMissingRate) {
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{
+			"code":    422,
+			"status":  "Unprocessable Entity",
+			"Message": err.Error(),
+		})
+	}
+	return internalError(c, err)
+}
+
+func (h *Handler) CreateBudget(c echo.Context) error {
+	var req expn.Budget
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": bindMessage(err),
+		})
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.CreateBudget(ctx, req)
+	if err != nil {
+		return budgetError(c, err, 0)
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
+
+func (h *Handler) ListBudgets(c echo.Context) error {
+	ctx := c.Request().Context()
+	resp, err := h.expense.ListBudgets(ctx)
+	if err != nil {
+		return budgetError(c, err, 0)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) GetBudget(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.GetBudget(ctx, id)
+	if err != nil {
+		return budgetError(c, err, id)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) UpdateBudget(c echo.Context) error {
+	var req expn.Budget
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": bindMessage(err),
+		})
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+	req.ID = id
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.UpdateBudget(ctx, req)
+	if err != nil {
+		return budgetError(c, err, id)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) DeleteBudget(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	if err := h.expense.DeleteBudget(ctx, id); err != nil {
+		return budgetError(c, err, id)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// BudgetStatus reports the budgets of the month query parameter, e.g. month=2026-10, the current month by default.
+func (h *Handler) BudgetStatus(c echo.Context) error {
+	month := time.Now()
+	if raw := c.QueryParam("month"); raw != "" {
+		m, err := expn.ParseMonth(raw)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"code":    400,
+				"status":  "Bad Request",
+				"Message": fmt.Sprintf("failed to binding query, %v", err),
+			})
+		}
+		month = m
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.BudgetStatus(ctx, month)
+	if err != nil {
+		return budgetError(c, err, 0)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
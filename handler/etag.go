@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+const (
+	HeaderETag    = "ETag"
+	HeaderIfMatch = "If-Match"
+)
+
+// WithRequiredIfMatch makes PUT /expenses/:id answer 428 without an If-Match header,
+// so no client can overwrite an expense it has not read. If-Match is optional otherwise.
+func WithRequiredIfMatch() Option {
+	return func(h *Handler) {
+		h.requireIfMatch = true
+	}
+}
+
+// etag is the strong entity tag of an expense version, e.g. "3".
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func setETag(c echo.Context, e expn.Expense) {
+	c.Response().Header().Set(HeaderETag, etag(e.Version))
+}
+
+var errIfMatch = errors.New(`If-Match must be "*" or a list of ETags of the expense`)
+
+// ifMatch reads the versions an If-Match header lists, wildcard is true for "*" which matches any version.
+// Weak tags are left out as If-Match compares strongly, so a list of them matches no version.
+func ifMatch(header string) (wildcard bool, versions []int64, err error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return true, nil, nil
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		raw, err := strconv.Unquote(tag)
+		if err != nil {
+			return false, nil, errIfMatch
+		}
+		version, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || version < 1 {
+			return false, nil, errIfMatch
+		}
+		versions = append(versions, version)
+	}
+	return false, versions, nil
+}
+
+// matchVersion is the version an update must still be at for the If-Match header to hold, 0 for any
+// and -1 for none. Of a list, that is the current version of the expense when it is listed, Update
+// then checks it has not changed since.
+func (h *Handler) matchVersion(c echo.Context, id int64, header string) (int64, error) {
+	wildcard, versions, err := ifMatch(header)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case wildcard:
+		return 0, nil
+	case len(versions) == 0:
+		return -1, nil
+	case len(versions) == 1:
+		return versions[0], nil
+	}
+
+	cur, err := h.expense.Get(c.Request().Context(), id)
+	if errors.Is(err, expn.ErrNoExpense) {
+		// Update answers the missing expense.
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range versions {
+		if v == cur.Version {
+			return v, nil
+		}
+	}
+	return -1, nil
+}
+
+// preconditionFailed answers a stale If-Match with the current expense and its ETag.
+func (h *Handler) preconditionFailed(c echo.Context, id int64) error {
+	cur, err := h.expense.Get(c.Request().Context(), id)
+	if err != nil {
+		return internalError(c, err)
+	}
+	setETag(c, cur)
+	return c.JSON(http.StatusPreconditionFailed, cur)
+}
@@ -0,0 +1,105 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	expn "github.com/dakeeChv/assessment/expense"
+	"github.com/dakeeChv/assessment/handler"
+)
+
+func TestUpdateExpenseIfMatchList(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	expense, _ := expn.NewService(ctx, db)
+	h, _ := handler.NewHandler(ctx, expense, handler.WithLegacyDateAuth())
+	e := echo.New()
+	h.SetupRoute(e)
+
+	at := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	columns := []string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "version"}
+	get := regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, version from expenses where id=$1`)
+	lock := regexp.QuoteMeta(`SELECT id, title, amount, currency, note, tags, spent_at, created_at, updated_at, deleted_at, version from expenses where id=$1 AND owner_id=COALESCE($2, owner_id) AND deleted_at IS NULL FOR UPDATE`)
+	expectCurrent := func(version int64) {
+		mock.ExpectQuery(get).
+			WithArgs(int64(1), handler.DefaultOwner).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "coffee", 4500, "THB", "", pq.Array([]string{}), at, at, at, version))
+	}
+	expectLock := func(version int64) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lock).
+			WithArgs(int64(1), handler.DefaultOwner).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at", "deleted_at", "version"}).
+					AddRow(1, "coffee", 4500, "THB", "", pq.Array([]string{}), at, at, at, nil, version),
+			)
+	}
+	put := func(match string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/expenses/1", strings.NewReader(`{"title":"latte","amount":"55.00"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "November 10, 2009")
+		req.Header.Set(handler.HeaderIfMatch, match)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Any listed ETag matches", func(t *testing.T) {
+		expectCurrent(4)
+		expectLock(4)
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE expenses SET title=$1`)).
+			WithArgs("latte", 5500, "THB", "", sqlmock.AnyArg(), nil, int64(1)).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "latte", 5500, "THB", "", pq.Array([]string{}), at, at, at, 5))
+		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expense_tags(expense_id, tag_id)`)).
+			ExpectExec().
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expense_history`)).
+			ExpectExec().
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rec := put(`"3", W/"4", "4"`)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"5"`, rec.Header().Get(handler.HeaderETag))
+	})
+
+	t.Run("No listed ETag matches", func(t *testing.T) {
+		expectCurrent(4)
+		expectLock(4)
+		mock.ExpectRollback()
+		expectCurrent(4)
+
+		rec := put(`"2", "3"`)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+		assert.Equal(t, `"4"`, rec.Header().Get(handler.HeaderETag))
+	})
+
+	t.Run("Invalid list", func(t *testing.T) {
+		rec := put(`"3", *`)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
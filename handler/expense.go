@@ -3,11 +3,13 @@ package handler
 import (
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 
 	expn "github.com/dakeeChv/assessment/expense"
@@ -19,25 +21,37 @@ func (h *Handler) CreateExpense(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{
 			"code":    400,
 			"status":  "Bad Request",
-			"Message": "failed to binding json body, Please pass a valid json body",
+			"Message": bindMessage(err),
 		})
 	}
 
 	ctx := c.Request().Context()
 	resp, err := h.expense.Create(ctx, req)
-	if err != nil {
-		ref := uuid.New()
-		log.Printf("\nlogId: %s, %v\n", ref, err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"code":    500,
-			"status":  "Internal Server Error",
-			"Message": fmt.Sprintf("failed to processing request, refer: %s", ref),
+	if errors.Is(err, expn.ErrInvalidAmount) || errors.Is(err, expn.ErrUnknownCurrency) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": err.Error(),
 		})
 	}
 
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	setETag(c, resp)
+	h.warnOverspent(c, resp)
 	return c.JSON(http.StatusCreated, resp)
 }
 
+// bindMessage explains a failed json body binding, naming the invalid amount when there is one.
+func bindMessage(err error) string {
+	if errors.Is(err, expn.ErrInvalidAmount) || errors.Is(err, expn.ErrUnknownCurrency) {
+		return fmt.Sprintf("failed to binding json body, %v", errors.Unwrap(err))
+	}
+	return "failed to binding json body, Please pass a valid json body"
+}
+
 func (h *Handler) GetExpense(c echo.Context) error {
 	rid, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -60,25 +74,22 @@ func (h *Handler) GetExpense(c echo.Context) error {
 	}
 
 	if err != nil {
-		ref := uuid.New()
-		log.Printf("\nlogId: %s, %v\n", ref, err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"code":    500,
-			"status":  "Internal Server Error",
-			"Message": fmt.Sprintf("failed to processing request, refer: %s", ref),
-		})
+		return internalError(c, err)
 	}
 
+	setETag(c, resp)
 	return c.JSON(http.StatusOK, resp)
 }
 
+// UpdateExpense overwrites an expense. With If-Match it must still be at the version of one of its ETags,
+// or the current expense is answered 412 so the caller can merge its change into it.
 func (h *Handler) UpdateExpense(c echo.Context) error {
 	var req expn.Expense
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{
 			"code":    400,
 			"status":  "Bad Request",
-			"Message": "failed to binding json body, Please pass a valid json body",
+			"Message": bindMessage(err),
 		})
 	}
 
@@ -92,8 +103,41 @@ func (h *Handler) UpdateExpense(c echo.Context) error {
 	}
 	req.ID = int64(rid)
 
+	match := c.Request().Header.Get(HeaderIfMatch)
+	if match == "" && h.requireIfMatch {
+		return c.JSON(http.StatusPreconditionRequired, echo.Map{
+			"code":    428,
+			"status":  "Precondition Required",
+			"Message": "If-Match is required, pass the ETag of the expense as read",
+		})
+	}
+	if match != "" {
+		req.Version, err = h.matchVersion(c, req.ID, match)
+		if errors.Is(err, errIfMatch) {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"code":    400,
+				"status":  "Bad Request",
+				"Message": err.Error(),
+			})
+		}
+		if err != nil {
+			return internalError(c, err)
+		}
+	}
+
 	ctx := c.Request().Context()
 	resp, err := h.expense.Update(ctx, req)
+	if errors.Is(err, expn.ErrStaleVersion) {
+		return h.preconditionFailed(c, req.ID)
+	}
+	if errors.Is(err, expn.ErrInvalidAmount) || errors.Is(err, expn.ErrUnknownCurrency) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": err.Error(),
+		})
+	}
+
 	if errors.Is(err, expn.ErrNoExpense) {
 		return c.JSON(http.StatusNotFound, echo.Map{
 			"code":    404,
@@ -103,30 +147,396 @@ func (h *Handler) UpdateExpense(c echo.Context) error {
 	}
 
 	if err != nil {
-		ref := uuid.New()
-		log.Printf("\nlogId: %s, %v\n", ref, err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"code":    500,
-			"status":  "Internal Server Error",
-			"Message": fmt.Sprintf("failed to processing request, refer: %s", ref),
+		return internalError(c, err)
+	}
+
+	setETag(c, resp)
+	h.warnOverspent(c, resp)
+	return c.JSON(http.StatusOK, resp)
+}
+
+const (
+	HeaderNextCursor = "X-Next-Cursor"
+
+	MIMEApplicationMergePatch = "application/merge-patch+json"
+	MIMEApplicationJSONPatch  = "application/json-patch+json"
+)
+
+func (h *Handler) PatchExpense(c echo.Context) error {
+	rid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
 		})
 	}
 
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to read body, Please pass a valid patch document",
+		})
+	}
+
+	var id int64 = int64(rid)
+	ctx := c.Request().Context()
+	var resp expn.Expense
+	mediatype, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	switch mediatype {
+	case MIMEApplicationMergePatch:
+		var p expn.Patch
+		if p, err = expn.MergePatch(body); err == nil {
+			resp, err = h.expense.Patch(ctx, id, p)
+		}
+	case MIMEApplicationJSONPatch:
+		var ops []expn.Operation
+		if ops, err = expn.ParseJSONPatch(body); err == nil {
+			resp, err = h.expense.PatchJSON(ctx, id, ops)
+		}
+	default:
+		c.Response().Header().Set("Accept-Patch", MIMEApplicationMergePatch+", "+MIMEApplicationJSONPatch)
+		return c.JSON(http.StatusUnsupportedMediaType, echo.Map{
+			"code":    415,
+			"status":  "Unsupported Media Type",
+			"Message": fmt.Sprintf("Content-Type must be %s or %s", MIMEApplicationMergePatch, MIMEApplicationJSONPatch),
+		})
+	}
+
+	if errors.Is(err, expn.ErrInvalidPatch) || errors.Is(err, expn.ErrInvalidAmount) || errors.Is(err, expn.ErrUnknownCurrency) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": err.Error(),
+		})
+	}
+
+	if errors.Is(err, expn.ErrPatchTestFailed) {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"code":    409,
+			"status":  "Conflict",
+			"Message": err.Error(),
+		})
+	}
+
+	if errors.Is(err, expn.ErrNoExpense) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, a expense with ID: %d", id),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	setETag(c, resp)
+	h.warnOverspent(c, resp)
 	return c.JSON(http.StatusOK, resp)
 }
 
 func (h *Handler) ListExpenses(c echo.Context) error {
+	var opts expn.ListOptions
+	if raw := c.QueryParam("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > expn.MaxPageSize {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"code":    400,
+				"status":  "Bad Request",
+				"Message": fmt.Sprintf("failed to binding query, limit must be between 1 and %d", expn.MaxPageSize),
+			})
+		}
+		opts.Limit = limit
+	}
+	opts.Cursor = c.QueryParam("cursor")
+
+	filter, err := bindListFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, %v", err),
+		})
+	}
+	opts.Filter = filter
+
+	sort, err := expn.ParseSort(c.QueryParam("sort"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, %v", err),
+		})
+	}
+	opts.Sort = sort
+	opts.ConvertTo = strings.ToUpper(c.QueryParam("convert_to"))
+
 	ctx := c.Request().Context()
-	resp, err := h.expense.List(ctx)
+	resp, err := h.expense.List(ctx, opts)
+	if errors.Is(err, expn.ErrInvalidFilter) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, %v", err),
+		})
+	}
+
+	if errors.Is(err, expn.ErrUnknownCurrency) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, convert_to: %v", err),
+		})
+	}
+
+	if errors.Is(err, expn.ErrMissingRate) {
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{
+			"code":    422,
+			"status":  "Unprocessable Entity",
+			"Message": err.Error(),
+		})
+	}
+
+	if errors.Is(err, expn.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding query, Please pass a cursor returned by the previous page",
+		})
+	}
+
 	if err != nil {
-		ref := uuid.New()
-		log.Printf("\nlogId: %s, %v\n", ref, err)
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"code":    500,
-			"status":  "Internal Server Error",
-			"Message": fmt.Sprintf("failed to processing request, refer: %s", ref),
+		return internalError(c, err)
+	}
+
+	if resp.NextCursor != "" {
+		next := *c.Request().URL
+		query := next.Query()
+		query.Set("cursor", resp.NextCursor)
+		next.RawQuery = query.Encode()
+		c.Response().Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+		c.Response().Header().Set(HeaderNextCursor, resp.NextCursor)
+	}
+
+	return c.JSON(http.StatusOK, resp.Expenses)
+}
+
+func (h *Handler) SearchExpenses(c echo.Context) error {
+	var limit int
+	if raw := c.QueryParam("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > expn.MaxPageSize {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"code":    400,
+				"status":  "Bad Request",
+				"Message": fmt.Sprintf("failed to binding query, limit must be between 1 and %d", expn.MaxPageSize),
+			})
+		}
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.Search(ctx, c.QueryParam("q"), limit)
+	if errors.Is(err, expn.ErrInvalidQuery) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, %v", err),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// SummarizeExpenses totals the expenses matching the list filter per bucket,
+// e.g. ?group_by=tag,month&allocation=even&from=2026-01-01&convert_to=THB.
+func (h *Handler) SummarizeExpenses(c echo.Context) error {
+	filter, err := bindListFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, %v", err),
+		})
+	}
+	opts := expn.SummaryOptions{
+		Filter:     filter,
+		Allocation: expn.Allocation(c.QueryParam("allocation")),
+		ConvertTo:  strings.ToUpper(c.QueryParam("convert_to")),
+	}
+	if raw := c.QueryParam("group_by"); raw != "" {
+		for _, g := range strings.Split(raw, ",") {
+			opts.GroupBy = append(opts.GroupBy, strings.TrimSpace(g))
+		}
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.Summarize(ctx, opts)
+	if errors.Is(err, expn.ErrInvalidFilter) || errors.Is(err, expn.ErrInvalidSummary) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, %v", err),
+		})
+	}
+
+	if errors.Is(err, expn.ErrUnknownCurrency) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, convert_to: %v", err),
+		})
+	}
+
+	if errors.Is(err, expn.ErrMissingRate) {
+		return c.JSON(http.StatusUnprocessableEntity, echo.Map{
+			"code":    422,
+			"status":  "Unprocessable Entity",
+			"Message": err.Error(),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// bindListFilter reads the list filter from the query string,
+// e.g. ?tags_any=food,beverage&min_amount=50&title=smoothie&from=2026-10-01&to=2026-11-01.
+// The amount range is in the currency parameter, DefaultCurrency when it is missing.
+func bindListFilter(c echo.Context) (expn.ListFilter, error) {
+	var f expn.ListFilter
+	var errs []string
+
+	f.Currency = strings.ToUpper(c.QueryParam("currency"))
+	currency := f.Currency
+	if currency == "" {
+		currency = expn.DefaultCurrency
+	}
+
+	split := func(name string) []string {
+		var out []string
+		for _, v := range c.QueryParams()[name] {
+			for _, tag := range strings.Split(v, ",") {
+				out = append(out, strings.TrimSpace(tag))
+			}
+		}
+		return out
+	}
+	amount := func(name string) *expn.Money {
+		raw := c.QueryParam(name)
+		if raw == "" {
+			return nil
+		}
+		v, err := expn.ParseMoney(raw, currency)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			return nil
+		}
+		return &v
+	}
+	date := func(name string) *time.Time {
+		raw := c.QueryParam(name)
+		if raw == "" {
+			return nil
+		}
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if v, err := time.Parse(layout, raw); err == nil {
+				return &v
+			}
+		}
+		errs = append(errs, fmt.Sprintf("%s: %q must be an RFC 3339 time or YYYY-MM-DD", name, raw))
+		return nil
+	}
+
+	f.TagsAny = split("tags_any")
+	f.TagsAll = split("tags_all")
+	f.MinAmount = amount("min_amount")
+	f.MaxAmount = amount("max_amount")
+	f.Title = c.QueryParam("title")
+	f.Note = c.QueryParam("note")
+	f.From = date("from")
+	f.To = date("to")
+
+	if len(errs) > 0 {
+		return expn.ListFilter{}, errors.New(strings.Join(errs, ", "))
+	}
+	return f, nil
+}
+
+func (h *Handler) DeleteExpense(c echo.Context) error {
+	rid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	var id int64 = int64(rid)
+	ctx := c.Request().Context()
+	err = h.expense.Delete(ctx, id)
+	if errors.Is(err, expn.ErrNoExpense) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, a expense with ID: %d", id),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) ListTrash(c echo.Context) error {
+	ctx := c.Request().Context()
+	resp, err := h.expense.Trash(ctx)
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) RestoreExpense(c echo.Context) error {
+	rid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
 		})
 	}
 
+	var id int64 = int64(rid)
+	ctx := c.Request().Context()
+	resp, err := h.expense.Restore(ctx, id)
+	if errors.Is(err, expn.ErrNoExpense) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, a deleted expense with ID: %d", id),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	setETag(c, resp)
+	h.warnOverspent(c, resp)
 	return c.JSON(http.StatusOK, resp)
 }
@@ -41,7 +41,7 @@ func TestCreateExpense(t *testing.T) {
 	}
 
 	expense, _ := expn.NewService(ctx, db)
-	h, _ := handler.NewHandler(ctx, expense)
+	h, _ := handler.NewHandler(ctx, expense, handler.WithLegacyDateAuth())
 	h.SetupRoute(e)
 
 	go func() {
@@ -110,7 +110,7 @@ func TestGetExpense(t *testing.T) {
 	}
 
 	expense, _ := expn.NewService(ctx, db)
-	h, _ := handler.NewHandler(ctx, expense)
+	h, _ := handler.NewHandler(ctx, expense, handler.WithLegacyDateAuth())
 	h.SetupRoute(e)
 
 	go func() {
@@ -183,7 +183,7 @@ func TestUpdateExpenes(t *testing.T) {
 	}
 
 	expense, _ := expn.NewService(ctx, db)
-	h, _ := handler.NewHandler(ctx, expense)
+	h, _ := handler.NewHandler(ctx, expense, handler.WithLegacyDateAuth())
 	h.SetupRoute(e)
 
 	go func() {
@@ -245,7 +245,7 @@ func TestListExpenses(t *testing.T) {
 	}
 
 	expense, _ := expn.NewService(ctx, db)
-	h, _ := handler.NewHandler(ctx, expense)
+	h, _ := handler.NewHandler(ctx, expense, handler.WithLegacyDateAuth())
 	h.SetupRoute(e)
 
 	go func() {
@@ -279,3 +279,70 @@ func TestListExpenses(t *testing.T) {
 	err = e.Shutdown(ctx)
 	assert.NoError(t, err)
 }
+
+func TestDeleteAndRestoreExpense(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	e := echo.New()
+	db, err := sql.Open("postgres", pgdns)
+	if err != nil {
+		log.Printf("failed to db open: %v\n", err)
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		log.Printf("failed to db connect: %v\n", err)
+	}
+
+	expense, _ := expn.NewService(ctx, db)
+	h, _ := handler.NewHandler(ctx, expense, handler.WithLegacyDateAuth())
+	h.SetupRoute(e)
+
+	go func() {
+		e.Start(fmt.Sprintf(":%d", port))
+	}()
+
+	do := func(method, path string) *http.Response {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:%d%s", port, path), nil)
+		assert.NoError(t, err)
+
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "November 10, 2009")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		resp := do(http.MethodDelete, "/expenses/123")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp = do(http.MethodGet, "/expenses/123")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = do(http.MethodGet, "/expenses/trash")
+		got := []expn.Expense{}
+		err := json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if assert.NoError(t, err) && assert.NotEmpty(t, got) {
+			assert.Equal(t, int64(123), got[0].ID)
+			assert.NotNil(t, got[0].DeletedAt)
+		}
+
+		resp = do(http.MethodPost, "/expenses/123/restore")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = do(http.MethodGet, "/expenses/123")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = e.Shutdown(ctx)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// csvResponse sends the CSV headers on the first write, so an error before any row is still answered as json.
+type csvResponse struct {
+	c       echo.Context
+	started bool
+}
+
+func (r *csvResponse) Write(b []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		header := r.c.Response().Header()
+		header.Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		header.Set(echo.HeaderContentDisposition, `attachment; filename="expenses.csv"`)
+		r.c.Response().WriteHeader(http.StatusOK)
+	}
+	return r.c.Response().Write(b)
+}
+
+func (r *csvResponse) Flush() {
+	r.c.Response().Flush()
+}
+
+// parseDelimiter reads a single character or "tab", an empty delimiter is the default one
+// and anything else is utf8.RuneError, which the service rejects.
+func parseDelimiter(raw string) rune {
+	switch {
+	case raw == "":
+		return 0
+	case raw == "tab":
+		return '\t'
+	case utf8.RuneCountInString(raw) == 1:
+		r, _ := utf8.DecodeRuneInString(raw)
+		return r
+	default:
+		return utf8.RuneError
+	}
+}
+
+// ExportExpenses streams the expenses matching the list filter and sort as CSV,
+// e.g. ?tags_any=food&sort=-spent_at&delimiter=;&bom=true. The delimiter may also be "tab".
+func (h *Handler) ExportExpenses(c echo.Context) error {
+	filter, err := bindListFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, %v", err),
+		})
+	}
+	sort, err := expn.ParseSort(c.QueryParam("sort"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, %v", err),
+		})
+	}
+	opts := expn.ExportOptions{Filter: filter, Sort: sort, BOM: c.QueryParam("bom") == "true"}
+	opts.Comma = parseDelimiter(c.QueryParam("delimiter"))
+
+	ctx := c.Request().Context()
+	out := &csvResponse{c: c}
+	n, err := h.expense.ExportCSV(ctx, out, opts)
+	if err != nil && out.started {
+		// the status is already sent, the client sees a truncated file.
+		log.Printf("failed to export expenses after %d rows: %v", n, err)
+		return nil
+	}
+
+	if errors.Is(err, expn.ErrInvalidFilter) || errors.Is(err, expn.ErrInvalidSort) || errors.Is(err, expn.ErrInvalidExport) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding query, %v", err),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return nil
+}
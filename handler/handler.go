@@ -2,49 +2,211 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 
+	"github.com/dakeeChv/assessment/apikey"
+	"github.com/dakeeChv/assessment/auth"
 	expn "github.com/dakeeChv/assessment/expense"
+	"github.com/dakeeChv/assessment/idempotency"
+	"github.com/dakeeChv/assessment/policy"
 )
 
+// HeaderAPIKey carries the secret of an API key.
+const HeaderAPIKey = "X-API-Key"
+
+// DefaultOwner owns the expenses of callers authenticated with the legacy date header.
+const DefaultOwner = "default"
+
 // Handler manages http transports.
 type Handler struct {
-	expense *expn.Service
+	expense  *expn.Service
+	apikeys  *apikey.Service
+	verifier *auth.Verifier
+	legacy   bool
+	owner    string
+
+	requireIfMatch bool
+	idempotency    *idempotency.Service
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithVerifier authenticates callers with the JWT bearer tokens accepted by v.
+func WithVerifier(v *auth.Verifier) Option {
+	return func(h *Handler) {
+		h.verifier = v
+	}
+}
+
+// WithAPIKeys authenticates callers with the API keys of s in the X-API-Key header
+// and serves the /api-keys admin routes.
+func WithAPIKeys(s *apikey.Service) Option {
+	return func(h *Handler) {
+		h.apikeys = s
+	}
+}
+
+// WithLegacyDateAuth also accepts the old date Authorization header, e.g. "November 10, 2009",
+// for the clients which have not moved to bearer tokens yet.
+func WithLegacyDateAuth() Option {
+	return func(h *Handler) {
+		h.legacy = true
+	}
+}
+
+// WithDefaultOwner sets the user the legacy date header authenticates as, DefaultOwner otherwise.
+func WithDefaultOwner(id string) Option {
+	return func(h *Handler) {
+		h.owner = id
+	}
 }
 
 // NewHandler returns handler instance.
-func NewHandler(_ context.Context, expense *expn.Service) (*Handler, error) {
-	return &Handler{
+func NewHandler(_ context.Context, expense *expn.Service, opts ...Option) (*Handler, error) {
+	h := &Handler{
 		expense: expense,
-	}, nil
+		owner:   DefaultOwner,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h, nil
 }
 
-func (h *Handler) SetupRoute(e *echo.Echo) {
-	// Sample authentication with pare data value.
-	cmdw := func() echo.MiddlewareFunc {
-		return func(next echo.HandlerFunc) echo.HandlerFunc {
-			return func(c echo.Context) (err error) {
-				val := c.Request().Header.Get(echo.HeaderAuthorization)
-				_, err = time.Parse("January 02, 2006", val)
-				if err == nil {
-					next(c)
-				}
-				return &echo.HTTPError{
-					Code:     http.StatusUnauthorized,
-					Message:  "Unauthorized",
-					Internal: err,
-				}
+// lookupError is a failure to look the credentials up, such as the database being down,
+// rather than credentials which are wrong.
+type lookupError struct {
+	err error
+}
+
+func (e *lookupError) Error() string { return e.err.Error() }
+func (e *lookupError) Unwrap() error { return e.err }
+
+// authenticate puts the principal of the Authorization header into the request context.
+func (h *Handler) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p, err := h.principal(c)
+		var lookup *lookupError
+		if errors.As(err, &lookup) {
+			return internalError(c, err)
+		}
+		if err != nil {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return &echo.HTTPError{
+				Code:     http.StatusUnauthorized,
+				Message:  "Unauthorized",
+				Internal: err,
 			}
 		}
+		ctx := auth.NewContext(c.Request().Context(), p)
+		c.SetRequest(c.Request().WithContext(ctx))
+		return next(c)
+	}
+}
+
+func (h *Handler) principal(c echo.Context) (auth.Principal, error) {
+	if key := c.Request().Header.Get(HeaderAPIKey); key != "" && h.apikeys != nil {
+		p, err := h.apikeys.Authenticate(c.Request().Context(), key)
+		if err != nil && !errors.Is(err, apikey.ErrInvalidKey) {
+			return p, &lookupError{err}
+		}
+		return p, err
+	}
+
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	scheme, token, _ := strings.Cut(header, " ")
+	if strings.EqualFold(scheme, "Bearer") && h.verifier != nil {
+		return h.verifier.Verify(strings.TrimSpace(token))
+	}
+	if h.legacy {
+		if _, err := time.Parse("January 02, 2006", header); err != nil {
+			return auth.Principal{}, err
+		}
+		return auth.Principal{ID: h.owner}, nil
 	}
+	return auth.Principal{}, errors.New("missing bearer token")
+}
+
+// authorize answers 403 unless the policy lets the principal call the matched route.
+func authorize(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p, _ := auth.FromContext(c.Request().Context())
+		if err := policy.Authorize(p, c.Request().Method, c.Path()); err != nil {
+			return c.JSON(http.StatusForbidden, echo.Map{
+				"code":    403,
+				"status":  "Forbidden",
+				"Message": err.Error(),
+			})
+		}
+		return next(c)
+	}
+}
 
+// SetupRoute registers the routes, every one of them must be listed in policy.Routes.
+func (h *Handler) SetupRoute(e *echo.Echo) {
 	v1 := e.Group("")
-	v1.Use(cmdw())
-	v1.POST("/expenses", h.CreateExpense)
+	v1.Use(h.authenticate, authorize)
+	v1.POST("/expenses", h.CreateExpense, h.idempotent)
+	// the colon is escaped so echo does not read it as a path parameter.
+	v1.POST("/expenses\\:batch", h.CreateExpenses, h.idempotent)
 	v1.GET("/expenses/:id", h.GetExpense)
 	v1.PUT("/expenses/:id", h.UpdateExpense)
+	v1.PATCH("/expenses/:id", h.PatchExpense)
 	v1.GET("/expenses", h.ListExpenses)
+	v1.DELETE("/expenses/:id", h.DeleteExpense)
+	v1.GET("/expenses/trash", h.ListTrash)
+	v1.GET("/expenses/search", h.SearchExpenses)
+	v1.GET("/expenses/summary", h.SummarizeExpenses)
+	v1.GET("/expenses/export.csv", h.ExportExpenses)
+	v1.POST("/expenses/import", h.ImportExpenses)
+	v1.POST("/expenses/import/statement", h.ImportStatement)
+	v1.POST("/expenses/:id/restore", h.RestoreExpense)
+	v1.GET("/expenses/:id/history", h.ExpenseHistory)
+	v1.POST("/expenses/:id/revert", h.RevertExpense)
+	v1.POST("/expenses/:id/attachments", h.CreateAttachment)
+	v1.GET("/expenses/:id/attachments", h.ListAttachments)
+	v1.GET("/expenses/:id/attachments/:attachment_id", h.GetAttachment)
+	v1.DELETE("/expenses/:id/attachments/:attachment_id", h.DeleteAttachment)
+	v1.POST("/budgets", h.CreateBudget)
+	v1.GET("/budgets", h.ListBudgets)
+	v1.GET("/budgets/status", h.BudgetStatus)
+	v1.GET("/budgets/:id", h.GetBudget)
+	v1.PUT("/budgets/:id", h.UpdateBudget)
+	v1.DELETE("/budgets/:id", h.DeleteBudget)
+	v1.POST("/recurring-expenses", h.CreateRecurring)
+	v1.GET("/recurring-expenses", h.ListRecurring)
+	v1.GET("/recurring-expenses/:id", h.GetRecurring)
+	v1.DELETE("/recurring-expenses/:id", h.DeleteRecurring)
+	v1.GET("/recurring-expenses/:id/occurrences", h.ListOccurrences)
+	v1.POST("/recurring-expenses/:id/skip", h.SkipOccurrence)
+	v1.GET("/tags", h.ListTags)
+	v1.POST("/tags/rename", h.RenameTag)
+	v1.POST("/tags/merge", h.MergeTags)
+
+	if h.apikeys != nil {
+		v1.POST("/api-keys", h.CreateAPIKey)
+		v1.GET("/api-keys", h.ListAPIKeys)
+		v1.POST("/api-keys/:id/rotate", h.RotateAPIKey)
+		v1.DELETE("/api-keys/:id", h.RevokeAPIKey)
+	}
+}
+
+// internalError logs err under a reference the caller can quote.
+func internalError(c echo.Context, err error) error {
+	ref := uuid.New()
+	log.Printf("\nlogId: %s, %v\n", ref, err)
+	return c.JSON(http.StatusInternalServerError, echo.Map{
+		"code":    500,
+		"status":  "Internal Server Error",
+		"Message": fmt.Sprintf("failed to processing request, refer: %s", ref),
+	})
 }
@@ -0,0 +1,66 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/apikey"
+	expn "github.com/dakeeChv/assessment/expense"
+	"github.com/dakeeChv/assessment/handler"
+)
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	expense, _ := expn.NewService(ctx, db)
+	keys, _ := apikey.NewService(ctx, db)
+	h, _ := handler.NewHandler(ctx, expense, handler.WithAPIKeys(keys))
+	e := echo.New()
+	h.SetupRoute(e)
+
+	lookup := regexp.QuoteMeta(`SELECT id, owner_id, scopes, salt, hash from api_keys where prefix=$1 AND revoked_at IS NULL`)
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+		req.Header.Set(handler.HeaderAPIKey, "exk_prefix_secret")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Unknown key", func(t *testing.T) {
+		mock.ExpectQuery(lookup).WithArgs("prefix").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		rec := get()
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `Bearer error="invalid_token"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+	})
+
+	t.Run("Database down", func(t *testing.T) {
+		mock.ExpectQuery(lookup).WithArgs("prefix").WillReturnError(errors.New("connection refused"))
+
+		rec := get()
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Empty(t, rec.Header().Get(echo.HeaderWWWAuthenticate))
+	})
+}
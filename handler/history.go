@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// ExpenseHistory lists the revisions of an expense with the fields each one changed, oldest first.
+func (h *Handler) ExpenseHistory(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.History(ctx, id)
+	if errors.Is(err, expn.ErrNoExpense) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, an expense with ID: %d", id),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// RevertExpense sets an expense back to a revision of its history, e.g. ?to=2, which is recorded as a new revision.
+func (h *Handler) RevertExpense(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+	to, err := strconv.ParseInt(c.QueryParam("to"), 10, 64)
+	if err != nil || to < 1 {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding query, to must be a revision number",
+		})
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.Revert(ctx, id, to)
+	if errors.Is(err, expn.ErrNoExpense) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, an expense with ID: %d", id),
+		})
+	}
+	if errors.Is(err, expn.ErrNoRevision) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, revision %d of the expense with ID: %d", to, id),
+		})
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	setETag(c, resp)
+	h.warnOverspent(c, resp)
+	return c.JSON(http.StatusOK, resp)
+}
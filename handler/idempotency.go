@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/dakeeChv/assessment/auth"
+	"github.com/dakeeChv/assessment/idempotency"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderIdempotentReplayed marks a response replayed for a repeated Idempotency-Key.
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// replayedHeaders are the response headers stored to be replayed with the body.
+var replayedHeaders = []string{echo.HeaderContentType, echo.HeaderLocation, HeaderETag, HeaderBudgetWarning}
+
+// WithIdempotency stores the responses of the requests sent with an Idempotency-Key in s,
+// so a retried POST /expenses does not create the expense again.
+func WithIdempotency(s *idempotency.Service) Option {
+	return func(h *Handler) {
+		h.idempotency = s
+	}
+}
+
+// recorder holds back the response of a request until it is stored with its Idempotency-Key.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+// idempotent serves a request with an Idempotency-Key once: a repeat of it replays the first response
+// and the key sent with another request is answered 422. Server errors are not stored, so they can be retried.
+func (h *Handler) idempotent(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(HeaderIdempotencyKey)
+		if key == "" || h.idempotency == nil {
+			return next(c)
+		}
+
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"code":    400,
+				"status":  "Bad Request",
+				"Message": "failed to read body",
+			})
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request().Context()
+		p, _ := auth.FromContext(ctx)
+		req := c.Request()
+		claim, err := h.idempotency.Begin(ctx, p.ID, key, idempotency.Fingerprint(req.Method, req.URL.RequestURI(), body))
+		if errors.Is(err, idempotency.ErrInvalidKey) {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"code":    400,
+				"status":  "Bad Request",
+				"Message": err.Error(),
+			})
+		}
+		if errors.Is(err, idempotency.ErrKeyReused) {
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{
+				"code":    422,
+				"status":  "Unprocessable Entity",
+				"Message": "Idempotency-Key was already used for a request with another body",
+			})
+		}
+		if err != nil {
+			return internalError(c, err)
+		}
+
+		if r := claim.Replay; r != nil {
+			header := c.Response().Header()
+			for name, values := range r.Header {
+				header[http.CanonicalHeaderKey(name)] = values
+			}
+			header.Set(HeaderIdempotentReplayed, "true")
+			c.Response().WriteHeader(r.Status)
+			_, err := c.Response().Write(r.Body)
+			return err
+		}
+
+		w := c.Response().Writer
+		rec := &recorder{ResponseWriter: w}
+		c.Response().Writer = rec
+		err = next(c)
+		c.Response().Writer = w
+		if rec.status == 0 {
+			// the handler returned an error for echo to answer.
+			claim.Release()
+			return err
+		}
+
+		if rec.status < http.StatusInternalServerError {
+			stored := idempotency.Response{Status: rec.status, Header: make(http.Header), Body: rec.body.Bytes()}
+			for _, name := range replayedHeaders {
+				if values := c.Response().Header().Values(name); len(values) > 0 {
+					stored.Header[http.CanonicalHeaderKey(name)] = values
+				}
+			}
+			if err := claim.Save(stored); err != nil {
+				log.Printf("failed to store the response of idempotency key %q: %v", key, err)
+			}
+		} else {
+			claim.Release()
+		}
+
+		w.WriteHeader(rec.status)
+		_, werr := w.Write(rec.body.Bytes())
+		if err != nil {
+			return err
+		}
+		return werr
+	}
+}
@@ -0,0 +1,128 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	expn "github.com/dakeeChv/assessment/expense"
+	"github.com/dakeeChv/assessment/handler"
+	"github.com/dakeeChv/assessment/idempotency"
+)
+
+func TestIdempotent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	expense, _ := expn.NewService(ctx, db)
+	keys, _ := idempotency.NewService(ctx, db)
+	h, _ := handler.NewHandler(ctx, expense, handler.WithLegacyDateAuth(), handler.WithIdempotency(keys))
+	e := echo.New()
+	h.SetupRoute(e)
+
+	body := `{"title":"coffee","amount":"45.00"}`
+	fp := idempotency.Fingerprint(http.MethodPost, "/expenses", []byte(body))
+	claim := func(fingerprint []byte, status interface{}, header, stored []byte) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO idempotency_keys(owner_id, key, fingerprint, expires_at)`)).
+			WithArgs(handler.DefaultOwner, "k1", fp, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT fingerprint, status, header, body, expires_at <= now() from idempotency_keys where owner_id=$1 AND key=$2 FOR UPDATE`)).
+			WithArgs(handler.DefaultOwner, "k1").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "header", "body", "expired"}).AddRow(fingerprint, status, header, stored, false))
+	}
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "November 10, 2009")
+		req.Header.Set(handler.HeaderIdempotencyKey, "k1")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("First request is stored", func(t *testing.T) {
+		at := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+		claim(fp, nil, nil, nil)
+		mock.ExpectBegin()
+		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expenses(title, amount, currency, note, tags, spent_at, owner_id)`)).
+			ExpectQuery().
+			WithArgs("coffee", 4500, "THB", "", sqlmock.AnyArg(), nil, handler.DefaultOwner).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"id", "title", "amount", "currency", "note", "tags", "spent_at", "created_at", "updated_at"}).
+					AddRow(1, "coffee", 4500, "THB", "", pq.Array([]string{}), at, at, at),
+			)
+		mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO expense_history`)).
+			ExpectExec().
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE idempotency_keys SET status=$3, header=$4, body=$5 WHERE owner_id=$1 AND key=$2`)).
+			WithArgs(handler.DefaultOwner, "k1", http.StatusCreated, []byte(`{"Content-Type":["application/json; charset=UTF-8"],"Etag":["\"1\""]}`), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rec := post(body)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"title":"coffee"`)
+	})
+
+	t.Run("Repeat replays the stored response", func(t *testing.T) {
+		claim(fp, http.StatusCreated, []byte(`{"Content-Type":["application/json; charset=UTF-8"],"Etag":["\"1\""]}`), []byte(`{"id":1,"title":"coffee"}`))
+		mock.ExpectRollback()
+
+		rec := post(body)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "application/json; charset=UTF-8", rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `"1"`, rec.Header().Get(handler.HeaderETag))
+		assert.Equal(t, "true", rec.Header().Get(handler.HeaderIdempotentReplayed))
+		assert.Equal(t, `{"id":1,"title":"coffee"}`, rec.Body.String())
+	})
+
+	t.Run("Another body is refused", func(t *testing.T) {
+		claim([]byte("other"), http.StatusCreated, []byte(`{}`), []byte(`{"id":1}`))
+		mock.ExpectRollback()
+
+		rec := post(body)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	t.Run("Server error is not stored", func(t *testing.T) {
+		claim(fp, nil, nil, nil)
+		mock.ExpectBegin().WillReturnError(errors.New("some error"))
+		mock.ExpectRollback()
+
+		rec := post(body)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Empty(t, rec.Header().Get(handler.HeaderIdempotentReplayed))
+	})
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// ImportExpenses creates expenses from a multipart CSV upload: the file in "file" and, optionally,
+// the JSON column mapping in "mapping", e.g. {"title":"Description","amount":"Debit","spent_at":"Date"},
+// plus "delimiter", "currency" and "date_format". Either every row is imported or none is.
+// With ?dry_run=true the rows are only validated and reported.
+func (h *Handler) ImportExpenses(c echo.Context) error {
+	badRequest := func(err error) error {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding request body, %v", err),
+		})
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return badRequest(err)
+	}
+	opts := expn.ImportOptions{
+		Comma:      parseDelimiter(c.FormValue("delimiter")),
+		Currency:   c.FormValue("currency"),
+		DateFormat: c.FormValue("date_format"),
+	}
+	if raw := c.FormValue("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts.Mapping); err != nil {
+			return badRequest(fmt.Errorf("mapping: %w", err))
+		}
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return internalError(c, err)
+	}
+	defer f.Close()
+
+	ctx := c.Request().Context()
+	report, err := h.expense.ImportCSV(ctx, f, opts, c.QueryParam("dry_run") == "true")
+	if errors.Is(err, expn.ErrInvalidImport) {
+		return badRequest(err)
+	}
+
+	if errors.Is(err, expn.ErrImportRejected) {
+		return c.JSON(http.StatusUnprocessableEntity, report)
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	if report.DryRun {
+		return c.JSON(http.StatusOK, report)
+	}
+	return c.JSON(http.StatusCreated, report)
+}
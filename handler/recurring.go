@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// recurringError answers the errors of the recurring expense service.
+func recurringError(c echo.Context, err error, id int64) error {
+	if errors.Is(err, expn.ErrInvalidRecurring) || errors.Is(err, expn.ErrInvalidRule) || errors.Is(err, expn.ErrInvalidOccurrence) ||
+		errors.Is(err, expn.ErrInvalidAmount) || errors.Is(err, expn.ErrUnknownCurrency) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": err.Error(),
+		})
+	}
+	if errors.Is(err, expn.ErrNoRecurring) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": fmt.Sprintf("Not Found, a recurring expense with ID: %d", id),
+		})
+	}
+	return internalError(c, err)
+}
+
+// recurringBindMessage explains a failed json body binding, naming the invalid amount or rule when there is one.
+func recurringBindMessage(err error) string {
+	if errors.Is(err, expn.ErrInvalidRule) {
+		return fmt.Sprintf("failed to binding json body, %v", errors.Unwrap(err))
+	}
+	return bindMessage(err)
+}
+
+func (h *Handler) CreateRecurring(c echo.Context) error {
+	var req expn.Recurring
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": recurringBindMessage(err),
+		})
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.CreateRecurring(ctx, req)
+	if err != nil {
+		return recurringError(c, err, 0)
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
+
+func (h *Handler) ListRecurring(c echo.Context) error {
+	ctx := c.Request().Context()
+	resp, err := h.expense.ListRecurring(ctx)
+	if err != nil {
+		return recurringError(c, err, 0)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) GetRecurring(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.GetRecurring(ctx, id)
+	if err != nil {
+		return recurringError(c, err, id)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) DeleteRecurring(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+
+	ctx := c.Request().Context()
+	if err := h.expense.DeleteRecurring(ctx, id); err != nil {
+		return recurringError(c, err, id)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListOccurrences previews the next occurrences of a recurring expense, n of them by the n query parameter, 10 by default.
+func (h *Handler) ListOccurrences(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+	n := 10
+	if raw := c.QueryParam("n"); raw != "" {
+		if n, err = strconv.Atoi(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"code":    400,
+				"status":  "Bad Request",
+				"Message": fmt.Sprintf("failed to binding query, n must be between 1 and %d", expn.MaxOccurrences),
+			})
+		}
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.Occurrences(ctx, id, n)
+	if err != nil {
+		return recurringError(c, err, id)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// SkipOccurrence keeps the occurrence at of the body, e.g. {"at":"2026-11-01T00:00:00+07:00"}, from creating an expense.
+func (h *Handler) SkipOccurrence(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding param, Please pass a valid param",
+		})
+	}
+	var req struct {
+		At time.Time `json:"at"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding json body, Please pass a valid json body",
+		})
+	}
+
+	ctx := c.Request().Context()
+	if err := h.expense.Skip(ctx, id, req.At); err != nil {
+		return recurringError(c, err, id)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	expn "github.com/dakeeChv/assessment/expense"
+	"github.com/dakeeChv/assessment/statement"
+)
+
+type statementResponse struct {
+	expn.StatementImport
+	// Credits are the deposits and refunds of the statement, which are not expenses.
+	Credits int `json:"credits"`
+}
+
+// ImportStatement creates the expenses of the debits of an OFX or QIF statement uploaded as multipart "file",
+// with the optional form values "currency" and "account" for what the file does not say and "date_order" dmy
+// for QIF dates written day first. Transactions imported before are skipped.
+func (h *Handler) ImportStatement(c echo.Context) error {
+	badRequest := func(err error) error {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": fmt.Sprintf("failed to binding request body, %v", err),
+		})
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return badRequest(err)
+	}
+	opts := statement.Options{
+		Currency: c.FormValue("currency"),
+		Account:  c.FormValue("account"),
+	}
+	switch c.FormValue("date_order") {
+	case "", "mdy":
+	case "dmy":
+		opts.DayFirst = true
+	default:
+		return badRequest(fmt.Errorf("date_order must be mdy or dmy"))
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return internalError(c, err)
+	}
+	defer f.Close()
+
+	txns, err := statement.Parse(f, fh.Filename, opts)
+	if errors.Is(err, statement.ErrUnknownFormat) || errors.Is(err, statement.ErrInvalid) || errors.Is(err, expn.ErrUnknownCurrency) || errors.Is(err, expn.ErrInvalidAmount) {
+		return badRequest(err)
+	}
+	if err != nil {
+		return internalError(c, err)
+	}
+	lines, credits := statement.Lines(txns)
+
+	ctx := c.Request().Context()
+	imported, err := h.expense.ImportStatement(ctx, lines)
+	if errors.Is(err, expn.ErrInvalidStatement) || errors.Is(err, expn.ErrUnknownCurrency) {
+		return badRequest(err)
+	}
+
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, statementResponse{StatementImport: imported, Credits: credits})
+}
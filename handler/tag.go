@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// ListTags lists every tag with the number of expenses it is on.
+func (h *Handler) ListTags(c echo.Context) error {
+	ctx := c.Request().Context()
+	resp, err := h.expense.Tags(ctx)
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// RenameTag renames a tag everywhere, e.g. {"from": "foods", "to": "meals"}.
+func (h *Handler) RenameTag(c echo.Context) error {
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding json body, Please pass a valid json body",
+		})
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.RenameTag(ctx, req.From, req.To)
+	if err != nil {
+		return tagError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// MergeTags merges several tags into one everywhere, e.g. {"from": ["Food", "foods"], "into": "food"}.
+func (h *Handler) MergeTags(c echo.Context) error {
+	var req struct {
+		From []string `json:"from"`
+		Into string   `json:"into"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": "failed to binding json body, Please pass a valid json body",
+		})
+	}
+
+	ctx := c.Request().Context()
+	resp, err := h.expense.MergeTags(ctx, req.From, req.Into)
+	if err != nil {
+		return tagError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// tagError answers the errors of renaming and merging tags.
+func tagError(c echo.Context, err error) error {
+	if errors.Is(err, expn.ErrInvalidTag) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"code":    400,
+			"status":  "Bad Request",
+			"Message": err.Error(),
+		})
+	}
+	if errors.Is(err, expn.ErrNoTag) {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"code":    404,
+			"status":  "Not Found",
+			"Message": err.Error(),
+		})
+	}
+	if errors.Is(err, expn.ErrTagExists) {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"code":    409,
+			"status":  "Conflict",
+			"Message": err.Error(),
+		})
+	}
+	return internalError(c, err)
+}
@@ -0,0 +1,192 @@
+// Package idempotency stores the responses of requests sent with an Idempotency-Key,
+// so a client retrying a request gets the response of its first attempt instead of a second write.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid idempotency key")
+	ErrKeyReused  = errors.New("idempotency key reused for another request")
+)
+
+// MaxKeyLength is the longest key accepted, a UUID is recommended.
+const MaxKeyLength = 255
+
+// DefaultTTL is how long a key is remembered by default.
+const DefaultTTL = 24 * time.Hour
+
+// ClaimTimeout is the longest a key is held for a request, from Begin until its response is saved.
+const ClaimTimeout = time.Minute
+
+// Response is a stored response.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Service remembers the response of each key of each user for a while.
+type Service struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+// Option configures the idempotency service.
+type Option func(*Service)
+
+// WithTTL sets how long a key is remembered, DefaultTTL otherwise.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Service) {
+		s.ttl = ttl
+	}
+}
+
+// NewService returns service instance.
+func NewService(_ context.Context, db *sql.DB, opts ...Option) (*Service, error) {
+	s := &Service{db: db, ttl: DefaultTTL}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Fingerprint identifies a request, a key may only be sent again with the same one.
+func Fingerprint(method, path string, body []byte) []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, path)
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Claim is a key being used by a request. It either replays the response stored for the key,
+// or holds the key's row lock until the response is saved or released.
+type Claim struct {
+	// Replay is the response to send again, nil when the request is to be served.
+	Replay *Response
+
+	tx         *sql.Tx
+	ctx        context.Context
+	cancel     context.CancelFunc
+	owner, key string
+}
+
+// detached carries the values of a context without its cancellation, so a claim outlives the request
+// whose client went away: a response written before that is still saved, and the retry replays it.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// Begin claims the key of owner for the request with fingerprint. A request sent again with the key gets
+// the stored response to replay, and another request ErrKeyReused. A concurrent duplicate waits on the row
+// lock until the first request saves or releases the key. The claim is held for at most ClaimTimeout,
+// even once ctx is done.
+func (s *Service) Begin(ctx context.Context, owner, key string, fingerprint []byte) (*Claim, error) {
+	if key == "" || len(key) > MaxKeyLength {
+		return nil, fmt.Errorf("%w: a key has 1 to %d characters", ErrInvalidKey, MaxKeyLength)
+	}
+
+	ctx, cancel := context.WithTimeout(detached{ctx}, ClaimTimeout)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("Begin(): db begin tx: %w", err)
+	}
+	release := true
+	defer func() {
+		if release {
+			tx.Rollback()
+			cancel()
+		}
+	}()
+
+	expires := time.Now().Add(s.ttl)
+	query := `INSERT INTO idempotency_keys(owner_id, key, fingerprint, expires_at) VALUES($1, $2, $3, $4) ON CONFLICT (owner_id, key) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, owner, key, fingerprint, expires); err != nil {
+		return nil, fmt.Errorf("Begin(): db exec: %w", err)
+	}
+
+	var stored []byte
+	var status sql.NullInt64
+	var header, body []byte
+	var expired bool
+	query = `SELECT fingerprint, status, header, body, expires_at <= now() from idempotency_keys where owner_id=$1 AND key=$2 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, owner, key).Scan(&stored, &status, &header, &body, &expired)
+	if err != nil {
+		return nil, fmt.Errorf("Begin(): db scan row: %w", err)
+	}
+
+	switch {
+	case expired:
+		query := `UPDATE idempotency_keys SET fingerprint=$3, status=NULL, header=NULL, body=NULL, created_at=now(), expires_at=$4 WHERE owner_id=$1 AND key=$2`
+		if _, err := tx.ExecContext(ctx, query, owner, key, fingerprint, expires); err != nil {
+			return nil, fmt.Errorf("Begin(): db exec: %w", err)
+		}
+	case !bytes.Equal(stored, fingerprint):
+		return nil, ErrKeyReused
+	case status.Valid:
+		r := Response{Status: int(status.Int64), Body: body}
+		if err := json.Unmarshal(header, &r.Header); err != nil {
+			return nil, fmt.Errorf("Begin(): unmarshal header: %w", err)
+		}
+		return &Claim{Replay: &r}, nil
+	}
+	// a response is saved in the transaction which inserted the key, so status is only NULL for this one.
+
+	release = false
+	return &Claim{tx: tx, ctx: ctx, cancel: cancel, owner: owner, key: key}, nil
+}
+
+// Save stores the response of the request for its key. It runs in the claim's own context,
+// so it succeeds after the client of the request went away.
+func (c *Claim) Save(r Response) error {
+	defer c.cancel()
+	header, err := json.Marshal(r.Header)
+	if err != nil {
+		c.tx.Rollback()
+		return fmt.Errorf("Save(): marshal header: %w", err)
+	}
+	query := `UPDATE idempotency_keys SET status=$3, header=$4, body=$5 WHERE owner_id=$1 AND key=$2`
+	if _, err := c.tx.ExecContext(c.ctx, query, c.owner, c.key, r.Status, header, r.Body); err != nil {
+		c.tx.Rollback()
+		return fmt.Errorf("Save(): db exec: %w", err)
+	}
+	if err := c.tx.Commit(); err != nil {
+		return fmt.Errorf("Save(): db commit: %w", err)
+	}
+	return nil
+}
+
+// Release forgets the key without a response, so the request can be retried with it.
+func (c *Claim) Release() {
+	if c.tx != nil {
+		c.tx.Rollback()
+		c.cancel()
+	}
+}
+
+// Purge deletes the keys expired before now.
+func (s *Service) Purge(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("Purge(): db exec context: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Purge(): db rows affected: %w", err)
+	}
+	return n, nil
+}
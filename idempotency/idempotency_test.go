@@ -0,0 +1,155 @@
+package idempotency_test
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/idempotency"
+)
+
+func TestBegin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	fp := idempotency.Fingerprint(http.MethodPost, "/expenses", []byte(`{"title":"coffee","amount":"45"}`))
+	claim := func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO idempotency_keys(owner_id, key, fingerprint, expires_at) VALUES($1, $2, $3, $4) ON CONFLICT (owner_id, key) DO NOTHING`)).
+			WithArgs("alice", "k1", fp, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	stored := regexp.QuoteMeta(`SELECT fingerprint, status, header, body, expires_at <= now() from idempotency_keys where owner_id=$1 AND key=$2 FOR UPDATE`)
+
+	t.Run("First request is served and saved", func(t *testing.T) {
+		claim()
+		mock.ExpectQuery(stored).WithArgs("alice", "k1").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "header", "body", "expired"}).AddRow(fp, nil, nil, nil, false))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE idempotency_keys SET status=$3, header=$4, body=$5 WHERE owner_id=$1 AND key=$2`)).
+			WithArgs("alice", "k1", 201, []byte(`{"Content-Type":["application/json"]}`), []byte(`{"id":1}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		s, _ := idempotency.NewService(context.Background(), db)
+
+		c, err := s.Begin(context.Background(), "alice", "k1", fp)
+		assert.NoError(t, err)
+		assert.Nil(t, c.Replay)
+		err = c.Save(idempotency.Response{
+			Status: 201,
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   []byte(`{"id":1}`),
+		})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("Saved after the client went away", func(t *testing.T) {
+		claim()
+		mock.ExpectQuery(stored).WithArgs("alice", "k1").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "header", "body", "expired"}).AddRow(fp, nil, nil, nil, false))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE idempotency_keys SET status=$3, header=$4, body=$5 WHERE owner_id=$1 AND key=$2`)).
+			WithArgs("alice", "k1", 201, []byte(`null`), []byte(`{"id":1}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		s, _ := idempotency.NewService(context.Background(), db)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		c, err := s.Begin(ctx, "alice", "k1", fp)
+		assert.NoError(t, err)
+		cancel()
+		// give database/sql the time to roll back a transaction bound to ctx.
+		time.Sleep(10 * time.Millisecond)
+		err = c.Save(idempotency.Response{Status: 201, Body: []byte(`{"id":1}`)})
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("Repeat is replayed", func(t *testing.T) {
+		claim()
+		mock.ExpectQuery(stored).WithArgs("alice", "k1").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "header", "body", "expired"}).
+				AddRow(fp, 201, []byte(`{"Content-Type":["application/json"]}`), []byte(`{"id":1}`), false))
+		mock.ExpectRollback()
+
+		s, _ := idempotency.NewService(context.Background(), db)
+
+		c, err := s.Begin(context.Background(), "alice", "k1", fp)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.NoError(t, err)
+		if assert.NotNil(t, c.Replay) {
+			assert.Equal(t, 201, c.Replay.Status)
+			assert.Equal(t, "application/json", c.Replay.Header.Get("Content-Type"))
+			assert.Equal(t, `{"id":1}`, string(c.Replay.Body))
+		}
+	})
+
+	t.Run("Reused with another body", func(t *testing.T) {
+		claim()
+		mock.ExpectQuery(stored).WithArgs("alice", "k1").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "header", "body", "expired"}).
+				AddRow([]byte("other"), 201, []byte(`{}`), []byte(`{"id":1}`), false))
+		mock.ExpectRollback()
+
+		s, _ := idempotency.NewService(context.Background(), db)
+
+		_, err := s.Begin(context.Background(), "alice", "k1", fp)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+
+		assert.ErrorIs(t, err, idempotency.ErrKeyReused)
+	})
+
+	t.Run("Expired key is claimed again", func(t *testing.T) {
+		claim()
+		mock.ExpectQuery(stored).WithArgs("alice", "k1").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "header", "body", "expired"}).
+				AddRow([]byte("other"), 201, []byte(`{}`), []byte(`{"id":1}`), true))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE idempotency_keys SET fingerprint=$3, status=NULL, header=NULL, body=NULL, created_at=now(), expires_at=$4 WHERE owner_id=$1 AND key=$2`)).
+			WithArgs("alice", "k1", fp, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+
+		s, _ := idempotency.NewService(context.Background(), db)
+
+		c, err := s.Begin(context.Background(), "alice", "k1", fp)
+		assert.NoError(t, err)
+		assert.Nil(t, c.Replay)
+		c.Release()
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Invalid key", func(t *testing.T) {
+		s, _ := idempotency.NewService(context.Background(), db)
+
+		_, err := s.Begin(context.Background(), "alice", "", fp)
+
+		assert.ErrorIs(t, err, idempotency.ErrInvalidKey)
+	})
+}
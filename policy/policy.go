@@ -0,0 +1,130 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dakeeChv/assessment/auth"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Permission is an action a principal may be allowed to take.
+type Permission string
+
+const (
+	// ReadExpenses and WriteExpenses act on the caller's own expenses, they share the names of the API key scopes.
+	ReadExpenses  Permission = auth.ScopeExpensesRead
+	WriteExpenses Permission = auth.ScopeExpensesWrite
+	// AllExpenses lifts the owner restriction, reads and writes then apply to everyone's expenses.
+	AllExpenses Permission = "expenses:all"
+	// ManageUsers covers the user administration such as API keys.
+	ManageUsers Permission = "users:manage"
+	// ManageTags renames and merges the tags shared by everyone's expenses.
+	ManageTags Permission = "tags:manage"
+)
+
+// Role is a named set of permissions.
+type Role string
+
+const (
+	Viewer Role = "viewer"
+	Member Role = "member"
+	Admin  Role = "admin"
+)
+
+// DefaultRole is the role of a principal whose credentials do not name one.
+const DefaultRole = Member
+
+var roles = map[Role][]Permission{
+	Viewer: {ReadExpenses},
+	Member: {ReadExpenses, WriteExpenses},
+	Admin:  {ReadExpenses, WriteExpenses, AllExpenses, ManageUsers, ManageTags},
+}
+
+// Routes is the permission each route requires, keyed by method and echo path.
+var Routes = map[string]Permission{
+	"POST /expenses":                                  WriteExpenses,
+	"POST /expenses\\:batch":                          WriteExpenses,
+	"GET /expenses":                                   ReadExpenses,
+	"GET /expenses/:id":                               ReadExpenses,
+	"PUT /expenses/:id":                               WriteExpenses,
+	"PATCH /expenses/:id":                             WriteExpenses,
+	"DELETE /expenses/:id":                            WriteExpenses,
+	"GET /expenses/trash":                             ReadExpenses,
+	"GET /expenses/search":                            ReadExpenses,
+	"GET /expenses/summary":                           ReadExpenses,
+	"GET /expenses/export.csv":                        ReadExpenses,
+	"POST /expenses/import":                           WriteExpenses,
+	"POST /expenses/import/statement":                 WriteExpenses,
+	"POST /expenses/:id/restore":                      WriteExpenses,
+	"GET /expenses/:id/history":                       ReadExpenses,
+	"POST /expenses/:id/revert":                       WriteExpenses,
+	"POST /expenses/:id/attachments":                  WriteExpenses,
+	"GET /expenses/:id/attachments":                   ReadExpenses,
+	"GET /expenses/:id/attachments/:attachment_id":    ReadExpenses,
+	"DELETE /expenses/:id/attachments/:attachment_id": WriteExpenses,
+	"POST /budgets":                                   WriteExpenses,
+	"GET /budgets":                                    ReadExpenses,
+	"GET /budgets/status":                             ReadExpenses,
+	"GET /budgets/:id":                                ReadExpenses,
+	"PUT /budgets/:id":                                WriteExpenses,
+	"DELETE /budgets/:id":                             WriteExpenses,
+	"POST /recurring-expenses":                        WriteExpenses,
+	"GET /recurring-expenses":                         ReadExpenses,
+	"GET /recurring-expenses/:id":                     ReadExpenses,
+	"DELETE /recurring-expenses/:id":                  WriteExpenses,
+	"GET /recurring-expenses/:id/occurrences":         ReadExpenses,
+	"POST /recurring-expenses/:id/skip":               WriteExpenses,
+	"GET /tags":                                       ManageTags,
+	"POST /tags/rename":                               ManageTags,
+	"POST /tags/merge":                                ManageTags,
+	"POST /api-keys":                                  ManageUsers,
+	"GET /api-keys":                                   ManageUsers,
+	"POST /api-keys/:id/rotate":                       ManageUsers,
+	"DELETE /api-keys/:id":                            ManageUsers,
+}
+
+// DeniedError names the permission a principal is missing.
+type DeniedError struct {
+	Permission Permission
+}
+
+func (e *DeniedError) Error() string {
+	if e.Permission == "" {
+		return fmt.Sprintf("%v: route has no permission", ErrForbidden)
+	}
+	return fmt.Sprintf("%v: missing permission %s", ErrForbidden, e.Permission)
+}
+
+func (e *DeniedError) Unwrap() error {
+	return ErrForbidden
+}
+
+// Can reports whether p holds perm: its role grants it and, when the credentials are scoped, a scope allows it.
+func Can(p auth.Principal, perm Permission) bool {
+	role := Role(p.Role)
+	if role == "" {
+		role = DefaultRole
+	}
+	granted := false
+	for _, rp := range roles[role] {
+		granted = granted || rp == perm
+	}
+	if !granted {
+		return false
+	}
+	return p.Scopes == nil || p.HasScope(string(perm))
+}
+
+// Authorize checks p may call the route, a route missing from Routes is denied.
+func Authorize(p auth.Principal, method, path string) error {
+	perm, ok := Routes[method+" "+path]
+	if !ok {
+		return &DeniedError{}
+	}
+	if !Can(p, perm) {
+		return &DeniedError{Permission: perm}
+	}
+	return nil
+}
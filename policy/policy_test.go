@@ -0,0 +1,58 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dakeeChv/assessment/auth"
+	"github.com/dakeeChv/assessment/policy"
+)
+
+func TestAuthorize(t *testing.T) {
+	viewer := auth.Principal{ID: "alice", Role: "viewer"}
+	member := auth.Principal{ID: "alice"}
+	admin := auth.Principal{ID: "alice", Role: "admin"}
+	readOnlyKey := auth.Principal{ID: "alice", Scopes: []string{auth.ScopeExpensesRead}}
+
+	tests := []struct {
+		name   string
+		p      auth.Principal
+		method string
+		path   string
+		denied policy.Permission
+	}{
+		{"Viewer reads", viewer, "GET", "/expenses/:id", ""},
+		{"Viewer writes", viewer, "POST", "/expenses", policy.WriteExpenses},
+		{"Viewer writes a batch", viewer, "POST", `/expenses\:batch`, policy.WriteExpenses},
+		{"Member by default writes", member, "PUT", "/expenses/:id", ""},
+		{"Member manages keys", member, "POST", "/api-keys", policy.ManageUsers},
+		{"Admin manages keys", admin, "GET", "/api-keys", ""},
+		{"Scope narrows role", readOnlyKey, "DELETE", "/expenses/:id", policy.WriteExpenses},
+		{"Scope allows", readOnlyKey, "GET", "/expenses", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Authorize(tt.p, tt.method, tt.path)
+
+			if tt.denied == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, policy.ErrForbidden)
+			assert.EqualError(t, err, "forbidden: missing permission "+string(tt.denied))
+		})
+	}
+
+	t.Run("Unknown route", func(t *testing.T) {
+		err := policy.Authorize(admin, "GET", "/users")
+
+		assert.ErrorIs(t, err, policy.ErrForbidden)
+	})
+}
+
+func TestCan(t *testing.T) {
+	assert.True(t, policy.Can(auth.Principal{Role: "admin"}, policy.AllExpenses))
+	assert.False(t, policy.Can(auth.Principal{Role: "admin", Scopes: []string{auth.ScopeExpensesRead}}, policy.AllExpenses))
+	assert.False(t, policy.Can(auth.Principal{Role: "unknown"}, policy.ReadExpenses))
+}
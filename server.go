@@ -3,19 +3,26 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/labstack/echo/v4"
 	emdw "github.com/labstack/echo/v4/middleware"
 	_ "github.com/lib/pq"
 
+	"github.com/dakeeChv/assessment/apikey"
+	"github.com/dakeeChv/assessment/auth"
+	"github.com/dakeeChv/assessment/blob"
 	expn "github.com/dakeeChv/assessment/expense"
 	handler "github.com/dakeeChv/assessment/handler"
+	"github.com/dakeeChv/assessment/idempotency"
 )
 
 func GetEnv(key, fallback string) string {
@@ -26,36 +33,113 @@ func GetEnv(key, fallback string) string {
 }
 
 var (
-	PORT   = GetEnv("PORT", "2565")
-	PG_URL = os.Getenv("DATABASE_URL")
+	PORT      = GetEnv("PORT", "2565")
+	PG_URL    = os.Getenv("DATABASE_URL")
+	RETENTION = GetEnv("TRASH_RETENTION", "720h")
+	IDEM_TTL  = GetEnv("IDEMPOTENCY_TTL", "24h")
+	CURSOR    = os.Getenv("CURSOR_SECRET")
+	ATTACH    = GetEnv("ATTACHMENT_DIR", "attachments")
+	OWNER     = GetEnv("DEFAULT_OWNER", handler.DefaultOwner)
+
+	JWT_SECRET   = os.Getenv("JWT_SECRET")
+	JWT_KEYS     = os.Getenv("JWT_PUBLIC_KEYS")
+	JWT_JWKS     = os.Getenv("JWT_JWKS_FILE")
+	JWT_ISSUER   = os.Getenv("JWT_ISSUER")
+	JWT_AUDIENCE = os.Getenv("JWT_AUDIENCE")
+	LEGACY_AUTH  = os.Getenv("LEGACY_DATE_AUTH") == "true"
+
+	REQUIRE_IF_MATCH = os.Getenv("REQUIRE_IF_MATCH") == "true"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := command(os.Args[1:]); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	if err := execute(); err != nil {
 		log.Fatalf("execute(): %v", err)
 	}
 }
 
-func execute() error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
+// openDB connects the database and migrates its schema.
+func openDB(ctx context.Context) (*sql.DB, error) {
 	db, err := sql.Open("postgres", PG_URL)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %v", err)
+		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
 	if err := db.PingContext(ctx); err != nil {
-		return fmt.Errorf("failed to connect database: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to connect database: %v", err)
 	}
-	defer db.Close()
 
 	//Auto initial migration.
 	if err := migrateDB(ctx, db); err != nil {
-		return fmt.Errorf("failed to initialize db schema: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize db schema: %v", err)
 	}
+	return db, nil
+}
+
+func execute() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	expense, _ := expn.NewService(ctx, db)
-	h, _ := handler.NewHandler(ctx, expense)
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	retention, err := time.ParseDuration(RETENTION)
+	if err != nil {
+		return fmt.Errorf("failed to parse trash retention: %v", err)
+	}
+	idemTTL, err := time.ParseDuration(IDEM_TTL)
+	if err != nil {
+		return fmt.Errorf("failed to parse idempotency ttl: %v", err)
+	}
+
+	blobs, err := blob.NewDisk(ATTACH)
+	if err != nil {
+		return fmt.Errorf("failed to open attachment directory: %v", err)
+	}
+
+	opts := []expn.Option{expn.WithBlobStore(blobs)}
+	if CURSOR != "" {
+		opts = append(opts, expn.WithCursorKey([]byte(CURSOR)))
+	}
+
+	expense, err := expn.NewService(ctx, db, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create expense service: %v", err)
+	}
+	keys, _ := apikey.NewService(ctx, db)
+	idem, _ := idempotency.NewService(ctx, db, idempotency.WithTTL(idemTTL))
+	hopts := []handler.Option{handler.WithDefaultOwner(OWNER), handler.WithAPIKeys(keys), handler.WithIdempotency(idem)}
+	verifier, err := newVerifier()
+	if err != nil {
+		return fmt.Errorf("failed to load jwt keys: %v", err)
+	}
+	if verifier != nil {
+		hopts = append(hopts, handler.WithVerifier(verifier))
+	}
+	if LEGACY_AUTH {
+		log.Println("LEGACY_DATE_AUTH is on, the date Authorization header is accepted")
+		hopts = append(hopts, handler.WithLegacyDateAuth())
+	} else if verifier == nil {
+		return errors.New("no authentication configured, set JWT_SECRET, JWT_PUBLIC_KEYS or JWT_JWKS_FILE")
+	}
+	if REQUIRE_IF_MATCH {
+		hopts = append(hopts, handler.WithRequiredIfMatch())
+	}
+	h, _ := handler.NewHandler(ctx, expense, hopts...)
+
+	go purgeTrash(ctx, expense, retention)
+	go purgeIdempotencyKeys(ctx, idem)
+	go materialiseRecurring(ctx, expense)
 
 	e := newEchoServer()
 	h.SetupRoute(e)
@@ -83,6 +167,45 @@ func execute() error {
 	return nil
 }
 
+// newVerifier loads the JWT keys from the environment, it returns nil when none is configured.
+// JWT_PUBLIC_KEYS is a comma separated list of PEM files.
+func newVerifier() (*auth.Verifier, error) {
+	var keys []auth.Key
+	if JWT_SECRET != "" {
+		keys = append(keys, auth.HMACKey([]byte(JWT_SECRET)))
+	}
+	if JWT_KEYS != "" {
+		for _, name := range strings.Split(JWT_KEYS, ",") {
+			b, err := os.ReadFile(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			k, err := auth.ParsePublicKeyPEM(b)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			keys = append(keys, k)
+		}
+	}
+	if JWT_JWKS != "" {
+		f, err := os.Open(JWT_JWKS)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		set, err := auth.ParseJWKS(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", JWT_JWKS, err)
+		}
+		keys = append(keys, set...)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	return auth.NewVerifier(keys, auth.WithIssuer(JWT_ISSUER), auth.WithAudience(JWT_AUDIENCE))
+}
+
 func newEchoServer() *echo.Echo {
 	e := echo.New()
 	e.HideBanner = true
@@ -97,17 +220,241 @@ func newEchoServer() *echo.Echo {
 }
 
 func migrateDB(ctx context.Context, db *sql.DB) error {
-	query := `CREATE TABLE IF NOT EXISTS expenses (
-		id SERIAL PRIMARY KEY,
-		title TEXT,
-		amount FLOAT,
-		note TEXT,
-		tags TEXT[]
-	)`
-
-	_, err := db.ExecContext(ctx, query)
-	if err != nil {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS expenses (
+			id SERIAL PRIMARY KEY,
+			title TEXT,
+			amount FLOAT,
+			note TEXT,
+			tags TEXT[]
+		)`,
+		`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+		`CREATE INDEX IF NOT EXISTS expenses_deleted_at_idx ON expenses (deleted_at) WHERE deleted_at IS NOT NULL`,
+		`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+			setweight(to_tsvector('simple', coalesce(note, '')), 'B')
+		) STORED`,
+		`CREATE INDEX IF NOT EXISTS expenses_search_idx ON expenses USING GIN (search)`,
+		`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'THB'`,
+		// amount holds integer minor units of currency, existing rows are THB so 1 bath is 100 satang.
+		`DO $$
+		BEGIN
+			IF (SELECT data_type FROM information_schema.columns WHERE table_name = 'expenses' AND column_name = 'amount') = 'double precision' THEN
+				ALTER TABLE expenses ALTER COLUMN amount TYPE BIGINT USING round(amount * 100)::BIGINT;
+			END IF;
+		END $$`,
+		`CREATE TABLE IF NOT EXISTS exchange_rates (
+			date DATE NOT NULL,
+			base CHAR(3) NOT NULL,
+			quote CHAR(3) NOT NULL,
+			rate NUMERIC NOT NULL CHECK (rate > 0),
+			PRIMARY KEY (base, quote, date)
+		)`,
+		`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS spent_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+		`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+		`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+		`CREATE INDEX IF NOT EXISTS expenses_spent_at_idx ON expenses (spent_at)`,
+		`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS owner_id TEXT`,
+		`CREATE INDEX IF NOT EXISTS expenses_owner_id_idx ON expenses (owner_id)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			owner_id TEXT NOT NULL,
+			prefix TEXT NOT NULL UNIQUE,
+			salt BYTEA NOT NULL,
+			hash BYTEA NOT NULL,
+			scopes TEXT[] NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			last_used_at TIMESTAMPTZ,
+			revoked_at TIMESTAMPTZ
+		)`,
+		`CREATE TABLE IF NOT EXISTS budgets (
+			id SERIAL PRIMARY KEY,
+			owner_id TEXT NOT NULL,
+			tag TEXT NOT NULL,
+			amount BIGINT NOT NULL CHECK (amount > 0),
+			currency CHAR(3) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			UNIQUE (owner_id, tag)
+		)`,
+		`CREATE TABLE IF NOT EXISTS recurring_expenses (
+			id SERIAL PRIMARY KEY,
+			owner_id TEXT NOT NULL,
+			title TEXT,
+			amount BIGINT NOT NULL,
+			currency CHAR(3) NOT NULL,
+			note TEXT,
+			tags TEXT[],
+			rule TEXT NOT NULL,
+			starts_at TIMESTAMPTZ NOT NULL,
+			time_zone TEXT NOT NULL DEFAULT 'UTC',
+			next_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE INDEX IF NOT EXISTS recurring_expenses_next_at_idx ON recurring_expenses (next_at) WHERE next_at IS NOT NULL`,
+		`CREATE TABLE IF NOT EXISTS recurring_occurrences (
+			recurring_id INT NOT NULL REFERENCES recurring_expenses (id) ON DELETE CASCADE,
+			occurs_at TIMESTAMPTZ NOT NULL,
+			expense_id INT REFERENCES expenses (id) ON DELETE SET NULL,
+			skipped BOOLEAN NOT NULL DEFAULT false,
+			PRIMARY KEY (recurring_id, occurs_at)
+		)`,
+		`CREATE TABLE IF NOT EXISTS statement_transactions (
+			owner_id TEXT NOT NULL,
+			account TEXT NOT NULL,
+			fitid TEXT NOT NULL,
+			expense_id INT REFERENCES expenses (id) ON DELETE SET NULL,
+			imported_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (owner_id, account, fitid)
+		)`,
+		`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			owner_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			fingerprint BYTEA NOT NULL,
+			status INT,
+			header JSONB,
+			body BYTEA,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (owner_id, key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at)`,
+		`CREATE TABLE IF NOT EXISTS expense_history (
+			expense_id INT NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+			revision BIGINT NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			changes JSONB NOT NULL,
+			snapshot JSONB NOT NULL,
+			reverted_to BIGINT,
+			changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (expense_id, revision)
+		)`,
+		`CREATE TABLE IF NOT EXISTS attachments (
+			id SERIAL PRIMARY KEY,
+			expense_id INT NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			sha256 TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE INDEX IF NOT EXISTS attachments_expense_id_idx ON attachments (expense_id)`,
+		`CREATE INDEX IF NOT EXISTS attachments_sha256_idx ON attachments (sha256)`,
+		`CREATE OR REPLACE FUNCTION canonical_tag(tag TEXT) RETURNS TEXT AS $$
+			SELECT lower(btrim(regexp_replace(tag, '\s+', ' ', 'g')))
+		$$ LANGUAGE SQL IMMUTABLE`,
+		`CREATE TABLE IF NOT EXISTS tags (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE TABLE IF NOT EXISTS expense_tags (
+			expense_id INT NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+			tag_id INT NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+			PRIMARY KEY (expense_id, tag_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS expense_tags_tag_id_idx ON expense_tags (tag_id)`,
+		// the tags arrays stay as a copy of expense_tags for the list filters and summaries. Once, they are canonicalised
+		// and linked, of the budgets of a user on tags differing only by case the oldest is kept.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM expense_tags) THEN
+				UPDATE expenses SET tags = ARRAY(
+					SELECT canonical_tag(u.tag) FROM unnest(tags) WITH ORDINALITY u(tag, ord)
+					WHERE canonical_tag(u.tag) <> '' GROUP BY 1 ORDER BY min(u.ord)
+				) WHERE cardinality(tags) > 0;
+				UPDATE recurring_expenses SET tags = ARRAY(
+					SELECT canonical_tag(u.tag) FROM unnest(tags) WITH ORDINALITY u(tag, ord)
+					WHERE canonical_tag(u.tag) <> '' GROUP BY 1 ORDER BY min(u.ord)
+				) WHERE cardinality(tags) > 0;
+				DELETE FROM budgets b WHERE EXISTS (
+					SELECT 1 FROM budgets o WHERE o.owner_id = b.owner_id AND o.id < b.id AND canonical_tag(o.tag) = canonical_tag(b.tag)
+				);
+				UPDATE budgets SET tag = canonical_tag(tag) WHERE tag <> canonical_tag(tag);
+				INSERT INTO tags(name) SELECT DISTINCT unnest(tags) FROM expenses ON CONFLICT DO NOTHING;
+				INSERT INTO expense_tags(expense_id, tag_id) SELECT e.id, t.id FROM expenses e JOIN tags t ON t.name = ANY(e.tags) ON CONFLICT DO NOTHING;
+			END IF;
+		END $$`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	// Expenses created before ownership belong to the default owner.
+	if _, err := db.ExecContext(ctx, `UPDATE expenses SET owner_id=$1 WHERE owner_id IS NULL`, OWNER); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, `ALTER TABLE expenses ALTER COLUMN owner_id SET NOT NULL`); err != nil {
 		return err
 	}
 	return nil
 }
+
+// purgeIdempotencyKeys deletes the expired idempotency keys every hour until ctx is done.
+func purgeIdempotencyKeys(ctx context.Context, idem *idempotency.Service) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if _, err := idem.Purge(ctx, time.Now()); err != nil {
+			log.Printf("failed to purge idempotency keys: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeTrash hard deletes the expenses which stay in the trash longer than retention,
+// it runs until ctx is done.
+func purgeTrash(ctx context.Context, expense *expn.Service, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		n, err := expense.Purge(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("failed to purge trash: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d expenses from trash", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// materialiseRecurring creates the due occurrences of the recurring expenses every minute until ctx is done,
+// every replica runs it and the service makes sure each occurrence is created once.
+func materialiseRecurring(ctx context.Context, expense *expn.Service) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		n, err := expense.Materialise(ctx, time.Now())
+		if err != nil {
+			log.Printf("failed to create recurring expenses: %v", err)
+		} else if n > 0 {
+			log.Printf("created %d recurring expenses", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
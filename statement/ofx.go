@@ -0,0 +1,188 @@
+package statement
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// charsetHeader finds the charset of an OFX 1 header (CHARSET:1252) or an OFX 2 XML declaration (encoding="...").
+var charsetHeader = regexp.MustCompile(`(?i)(?:CHARSET:\s*|encoding=")([\w-]+)`)
+
+// ofxToken is a tag of an OFX file with the text which follows it,
+// the value of an element as OFX 1 (SGML) does not close the elements holding a value.
+type ofxToken struct {
+	name string
+	end  bool
+	text string
+}
+
+func ofxTokens(s string) ([]ofxToken, error) {
+	var out []ofxToken
+	for {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			return out, nil
+		}
+		s = s[i+1:]
+		if strings.HasPrefix(s, "!--") {
+			j := strings.Index(s, "-->")
+			if j < 0 {
+				return nil, fmt.Errorf("%w: unterminated comment", ErrInvalid)
+			}
+			s = s[j+3:]
+			continue
+		}
+		j := strings.IndexByte(s, '>')
+		if j < 0 {
+			return nil, fmt.Errorf("%w: unterminated tag", ErrInvalid)
+		}
+		tag := s[:j]
+		s = s[j+1:]
+		if strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") {
+			continue
+		}
+
+		t := ofxToken{end: strings.HasPrefix(tag, "/")}
+		t.name = strings.ToUpper(strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(tag, "/"), "/")))
+		if !t.end {
+			text := s
+			if k := strings.IndexByte(s, '<'); k >= 0 {
+				text = s[:k]
+			}
+			t.text = html.UnescapeString(strings.TrimSpace(text))
+		}
+		out = append(out, t)
+	}
+}
+
+// ofxNested are the aggregates inside a transaction, whose elements are not those of the transaction.
+var ofxNested = map[string]bool{"PAYEE": true, "BANKACCTTO": true, "CCACCTTO": true, "CURRENCY": true, "ORIGCURRENCY": true, "IMAGEDATA": true}
+
+// ParseOFX reads the bank and credit card statement transactions of an OFX file, either OFX 1 (SGML) or OFX 2 (XML).
+func ParseOFX(r io.Reader, opts Options) ([]Transaction, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxSize))
+	if err != nil {
+		return nil, err
+	}
+	start := strings.Index(string(data), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("%w: no <OFX> element", ErrInvalid)
+	}
+	var charset string
+	if m := charsetHeader.FindSubmatch(data[:start]); m != nil {
+		charset = string(m[1])
+	}
+	tokens, err := ofxTokens(toUTF8(data[start:], charset))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Transaction
+	account, currency := "", opts.Currency
+	inAccount := false
+	var trn map[string]string
+	nested := ""
+	for _, t := range tokens {
+		switch {
+		case trn != nil && t.end && t.name == "STMTTRN":
+			tx, err := ofxTransaction(trn, account, currency)
+			if err != nil {
+				return nil, fmt.Errorf("%w: transaction %d: %v", ErrInvalid, len(out)+1, err)
+			}
+			out = append(out, tx)
+			trn = nil
+		case trn != nil && nested != "":
+			if t.end && t.name == nested {
+				nested = ""
+			} else if !t.end {
+				trn[nested+"."+t.name] = t.text
+			}
+		case trn != nil && !t.end && ofxNested[t.name]:
+			nested = t.name
+		case trn != nil && !t.end:
+			trn[t.name] = t.text
+		case !t.end && t.name == "STMTTRN":
+			trn = make(map[string]string)
+		case !t.end && (t.name == "STMTRS" || t.name == "CCSTMTRS"):
+			account, currency = "", opts.Currency
+		case t.name == "BANKACCTFROM" || t.name == "CCACCTFROM":
+			inAccount = !t.end
+		case !t.end && t.name == "ACCTID" && inAccount:
+			account = t.text
+		case !t.end && t.name == "CURDEF" && t.text != "":
+			currency = strings.ToUpper(t.text)
+		}
+	}
+	if trn != nil {
+		return nil, fmt.Errorf("%w: transaction %d is not closed", ErrInvalid, len(out)+1)
+	}
+	return out, nil
+}
+
+func ofxTransaction(f map[string]string, account, currency string) (Transaction, error) {
+	if f["FITID"] == "" {
+		return Transaction{}, fmt.Errorf("no FITID")
+	}
+	posted, err := parseOFXTime(f["DTPOSTED"])
+	if err != nil {
+		return Transaction{}, err
+	}
+	// the amount is in the currency of a CURRENCY aggregate, if any, rather than the statement's.
+	if sym := f["CURRENCY.CURSYM"]; sym != "" {
+		currency = strings.ToUpper(sym)
+	}
+	// OFX writes either a decimal point or a decimal comma, never a thousands separator.
+	amount, err := expn.ParseMoney(strings.ReplaceAll(strings.TrimPrefix(f["TRNAMT"], "+"), ",", "."), currency)
+	if err != nil {
+		return Transaction{}, err
+	}
+	payee := f["NAME"]
+	if payee == "" {
+		payee = f["PAYEE.NAME"]
+	}
+	return Transaction{
+		Account: account,
+		FITID:   f["FITID"],
+		Posted:  posted,
+		Amount:  amount,
+		Payee:   payee,
+		Memo:    f["MEMO"],
+	}, nil
+}
+
+// parseOFXTime reads an OFX datetime, YYYYMMDD[HHMMSS[.XXX]][[offset:TZ]], e.g. 20221110093000.000[+7:ICT].
+// A datetime without an offset is in GMT.
+func parseOFXTime(s string) (time.Time, error) {
+	raw := s
+	loc := time.UTC
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		offset, name, _ := strings.Cut(strings.TrimSuffix(s[i+1:], "]"), ":")
+		hours, err := strconv.ParseFloat(offset, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not an OFX datetime", raw)
+		}
+		loc = time.FixedZone(name, int(hours*3600))
+		s = s[:i]
+	}
+	s, _, _ = strings.Cut(s, ".")
+	layouts := map[int]string{8: "20060102", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(s)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("%q is not an OFX datetime", raw)
+	}
+	t, err := time.ParseInLocation(layout, s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an OFX datetime", raw)
+	}
+	return t, nil
+}
@@ -0,0 +1,73 @@
+package statement_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	expn "github.com/dakeeChv/assessment/expense"
+	"github.com/dakeeChv/assessment/statement"
+)
+
+func TestParseOFX(t *testing.T) {
+	ict := time.FixedZone("ICT", 7*3600)
+
+	t.Run("SGML", func(t *testing.T) {
+		sgml := "OFXHEADER:100\r\nDATA:OFXSGML\r\nVERSION:102\r\nCHARSET:874\r\n\r\n" +
+			"<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS><CURDEF>THB\r\n" +
+			"<BANKACCTFROM><BANKID>014<ACCTID>123-4-56789<ACCTTYPE>SAVINGS</BANKACCTFROM>\r\n" +
+			"<BANKTRANLIST>\r\n" +
+			"<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20221110093000.000[+7:ICT]<TRNAMT>-60.00<FITID>T1<NAME>\xa2\xe9\xd2\xc7<MEMO>lunch &amp; tea</STMTTRN>\r\n" +
+			"<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20221111<TRNAMT>1500,50<FITID>T2<PAYEE><NAME>Salary</PAYEE></STMTTRN>\r\n" +
+			"</BANKTRANLIST></STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>"
+
+		got, err := statement.ParseOFX(strings.NewReader(sgml), statement.Options{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []statement.Transaction{
+			{Account: "123-4-56789", FITID: "T1", Posted: time.Date(2022, time.November, 10, 9, 30, 0, 0, ict), Amount: expn.Money{Minor: -6000, Currency: "THB"}, Payee: "ข้าว", Memo: "lunch & tea"},
+			{Account: "123-4-56789", FITID: "T2", Posted: time.Date(2022, time.November, 11, 0, 0, 0, 0, time.UTC), Amount: expn.Money{Minor: 150050, Currency: "THB"}, Payee: "Salary"},
+		}, got)
+	})
+
+	t.Run("XML", func(t *testing.T) {
+		xml := `<?xml version="1.0" encoding="UTF-8"?><?OFX OFXHEADER="200" VERSION="220"?>
+<OFX><CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS><CURDEF>THB</CURDEF>
+<CCACCTFROM><ACCTID>4111</ACCTID></CCACCTFROM>
+<BANKTRANLIST><STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20221112</DTPOSTED><TRNAMT>-12.5</TRNAMT><FITID>C1</FITID>
+<NAME>Amazon</NAME><CURRENCY><CURRATE>36.5</CURRATE><CURSYM>USD</CURSYM></CURRENCY></STMTTRN></BANKTRANLIST>
+</CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1></OFX>`
+
+		got, err := statement.ParseOFX(strings.NewReader(xml), statement.Options{})
+
+		assert.NoError(t, err)
+		if assert.Equal(t, 1, len(got)) {
+			assert.Equal(t, "4111", got[0].Account)
+			assert.Equal(t, expn.Money{Minor: -1250, Currency: "USD"}, got[0].Amount)
+			assert.Equal(t, "Amazon", got[0].Payee)
+		}
+	})
+
+	t.Run("Missing FITID", func(t *testing.T) {
+		_, err := statement.ParseOFX(strings.NewReader("<OFX><STMTTRN><DTPOSTED>20221112<TRNAMT>-1</STMTTRN></OFX>"), statement.Options{})
+
+		assert.ErrorIs(t, err, statement.ErrInvalid)
+	})
+}
+
+func TestLines(t *testing.T) {
+	posted := time.Date(2022, time.November, 10, 0, 0, 0, 0, time.UTC)
+	txns := []statement.Transaction{
+		{Account: "a", FITID: "1", Posted: posted, Amount: expn.Money{Minor: -6000, Currency: "THB"}, Memo: "7-Eleven", Category: "food"},
+		{Account: "a", FITID: "2", Posted: posted, Amount: expn.Money{Minor: 100, Currency: "THB"}, Payee: "refund"},
+	}
+
+	lines, credits := statement.Lines(txns)
+
+	assert.Equal(t, 1, credits)
+	assert.Equal(t, []expn.StatementLine{{Account: "a", FITID: "1", Expense: expn.Expense{
+		Title: "7-Eleven", Amount: expn.Money{Minor: 6000, Currency: "THB"}, Note: "7-Eleven", Tags: []string{"food"}, SpentAt: posted,
+	}}}, lines)
+}
@@ -0,0 +1,159 @@
+package statement
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+// qifTransactions are the !Type sections holding transactions rather than categories, classes or investments.
+var qifTransactions = map[string]bool{"bank": true, "ccard": true, "cash": true, "oth a": true, "oth l": true}
+
+// ParseQIF reads the transactions of the bank, card and cash sections of a QIF file, in the currency of opts.
+// As QIF has no transaction IDs, the FITID is a hash of the date, amount, payee, memo and number of the transaction
+// and of how many identical transactions come before it, the same for every download of the statement.
+func ParseQIF(r io.Reader, opts Options) ([]Transaction, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxSize))
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+
+	var out []Transaction
+	account := opts.Account
+	section := ""
+	record := make(map[byte]string)
+	seen := make(map[string]int)
+	sc := bufio.NewScanner(strings.NewReader(toUTF8(data, "")))
+	sc.Buffer(make([]byte, 0, 64*1024), MaxSize)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" {
+			continue
+		}
+		if line[0] == '!' {
+			header := strings.ToLower(line)
+			switch {
+			case strings.HasPrefix(header, "!type:"):
+				section = strings.TrimSpace(header[len("!type:"):])
+			case header == "!account":
+				section = "account"
+			case strings.HasPrefix(header, "!option:") || strings.HasPrefix(header, "!clear:"):
+			default:
+				section = ""
+			}
+			continue
+		}
+		if line[0] != '^' {
+			// the split lines S, E and $ repeat, only the totals are read.
+			if _, ok := record[line[0]]; !ok {
+				record[line[0]] = strings.TrimSpace(line[1:])
+			}
+			continue
+		}
+
+		switch {
+		case section == "account":
+			if opts.Account == "" && record['N'] != "" {
+				account = record['N']
+			}
+		case qifTransactions[section] && len(record) > 0:
+			t, err := qifTransaction(record, opts)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalid, n, err)
+			}
+			key := strings.Join([]string{t.Posted.Format("2006-01-02"), t.Amount.String(), t.Payee, t.Memo, record['N']}, "\x00")
+			sum := sha256.Sum256([]byte(key))
+			t.FITID = "qif-" + hex.EncodeToString(sum[:8]) + "-" + strconv.Itoa(seen[key])
+			seen[key]++
+			t.Account = account
+			out = append(out, t)
+		}
+		record = make(map[byte]string)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return out, nil
+}
+
+func qifTransaction(record map[byte]string, opts Options) (Transaction, error) {
+	if record['D'] == "" {
+		return Transaction{}, fmt.Errorf("no date")
+	}
+	posted, err := parseQIFDate(record['D'], opts.DayFirst)
+	if err != nil {
+		return Transaction{}, err
+	}
+	raw := record['T']
+	if raw == "" {
+		raw = record['U']
+	}
+	// unlike OFX, QIF amounts are written with thousands separators, e.g. -1,250.00.
+	amount, err := expn.ParseMoney(strings.ReplaceAll(raw, ",", ""), opts.Currency)
+	if err != nil {
+		return Transaction{}, err
+	}
+	// a category may name a class after a slash, and a transfer names the other account in brackets.
+	category, _, _ := strings.Cut(record['L'], "/")
+	if strings.HasPrefix(category, "[") {
+		category = ""
+	}
+	return Transaction{
+		Posted:   posted,
+		Amount:   amount,
+		Payee:    record['P'],
+		Memo:     record['M'],
+		Category: category,
+	}, nil
+}
+
+// parseQIFDate reads the dates of the many QIF dialects: 12/31/2022, 12/31'22, 12/31/22, 12-31-2022 and 2022-12-31.
+// Two digit years are in this century, unless later than 69, and years of the Buddhist era, such as 2565, are converted.
+func parseQIFDate(s string, dayFirst bool) (time.Time, error) {
+	raw := s
+	s = strings.ReplaceAll(s, " ", "")
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == '/' || r == '-' || r == '.' || r == '\'' })
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("%q is not a QIF date", raw)
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not a QIF date", raw)
+		}
+		n[i] = v
+	}
+	month, day, year := n[0], n[1], n[2]
+	if dayFirst {
+		month, day = day, month
+	}
+	switch {
+	case year < 70:
+		year += 2000
+	case year < 100:
+		year += 1900
+	case year > 2400:
+		year -= 543
+	}
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Day() != day || int(t.Month()) != month {
+		return time.Time{}, fmt.Errorf("%q is not a QIF date", raw)
+	}
+	return t, nil
+}
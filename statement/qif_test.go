@@ -0,0 +1,53 @@
+package statement_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	expn "github.com/dakeeChv/assessment/expense"
+	"github.com/dakeeChv/assessment/statement"
+)
+
+func TestParseQIF(t *testing.T) {
+	qif := "!Account\nNSavings\nTBank\n^\n" +
+		"!Type:Bank\n" +
+		"D10/11/2565\nT-1,250.00\nPTops\nMgroceries\nLFood:Groceries/home\n^\n" +
+		"D10/11/2565\nT-1,250.00\nPTops\nMgroceries\n^\n" +
+		"D11/11'22\nT500.00\nL[Checking]\n^\n"
+
+	got, err := statement.ParseQIF(strings.NewReader(qif), statement.Options{DayFirst: true})
+
+	assert.NoError(t, err)
+	if assert.Equal(t, 3, len(got)) {
+		assert.Equal(t, "Savings", got[0].Account)
+		assert.Equal(t, time.Date(2022, time.November, 10, 0, 0, 0, 0, time.UTC), got[0].Posted)
+		assert.Equal(t, expn.Money{Minor: -125000, Currency: "THB"}, got[0].Amount)
+		assert.Equal(t, "Tops", got[0].Payee)
+		assert.Equal(t, "Food:Groceries", got[0].Category)
+		// identical transactions keep apart, and their IDs the same on every import.
+		assert.NotEqual(t, got[0].FITID, got[1].FITID)
+		again, _ := statement.ParseQIF(strings.NewReader(qif), statement.Options{DayFirst: true})
+		assert.Equal(t, got[1].FITID, again[1].FITID)
+		assert.Equal(t, time.Date(2022, time.November, 11, 0, 0, 0, 0, time.UTC), got[2].Posted)
+		assert.Equal(t, "", got[2].Category)
+	}
+
+	_, err = statement.ParseQIF(strings.NewReader("!Type:Bank\nD13/31/2022\nT-1\n^\n"), statement.Options{})
+	assert.ErrorIs(t, err, statement.ErrInvalid)
+}
+
+func TestDetect(t *testing.T) {
+	f, err := statement.Detect("download.qfx", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, statement.OFX, f)
+
+	f, err = statement.Detect("download", []byte("\uFEFF!Type:CCard\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, statement.QIF, f)
+
+	_, err = statement.Detect("expenses.csv", []byte("title,amount\n"))
+	assert.ErrorIs(t, err, statement.ErrUnknownFormat)
+}
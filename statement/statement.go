@@ -0,0 +1,172 @@
+// Package statement reads the transactions of bank and card statements downloaded as OFX or QIF files.
+package statement
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	expn "github.com/dakeeChv/assessment/expense"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown statement format")
+	ErrInvalid       = errors.New("invalid statement")
+)
+
+// MaxSize is the largest statement file read.
+const MaxSize = 16 << 20
+
+// Format is the file format of a statement.
+type Format string
+
+const (
+	OFX Format = "ofx"
+	QIF Format = "qif"
+)
+
+// Transaction is a transaction of a statement.
+type Transaction struct {
+	Account string
+	// FITID is the bank's ID of the transaction, unique within the account.
+	// QIF has none, so it is derived from the transaction, see ParseQIF.
+	FITID  string
+	Posted time.Time
+	// Amount is negative for money spent.
+	Amount   expn.Money
+	Payee    string
+	Memo     string
+	Category string
+}
+
+// Options are what a statement file may not say itself.
+type Options struct {
+	// Currency is the currency of the amounts of QIF files and of OFX files without CURDEF,
+	// expense.DefaultCurrency by default.
+	Currency string
+	// Account is the account of a QIF file without an !Account header.
+	Account string
+	// DayFirst reads QIF dates as day/month/year, as Thai banks write them, instead of Quicken's month/day/year.
+	DayFirst bool
+}
+
+func (o *Options) validate() error {
+	if o.Currency == "" {
+		o.Currency = expn.DefaultCurrency
+	}
+	o.Currency = strings.ToUpper(o.Currency)
+	if _, err := expn.Exponent(o.Currency); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Detect tells the format of a statement from its file name or else its content.
+func Detect(name string, data []byte) (Format, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".ofx", ".qfx":
+		return OFX, nil
+	case ".qif":
+		return QIF, nil
+	}
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\uFEFF")), " \t\r\n")
+	switch {
+	case bytes.Contains(head, []byte("OFXHEADER")) || bytes.Contains(head, []byte("<OFX>")):
+		return OFX, nil
+	case bytes.HasPrefix(head, []byte("!")):
+		return QIF, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Parse reads the transactions of a statement in either format.
+func Parse(r io.Reader, name string, opts Options) ([]Transaction, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalid, MaxSize)
+	}
+	format, err := Detect(name, data)
+	if err != nil {
+		return nil, err
+	}
+	if format == OFX {
+		return ParseOFX(bytes.NewReader(data), opts)
+	}
+	return ParseQIF(bytes.NewReader(data), opts)
+}
+
+// Expense is the expense of a debit: the payee is its title, or the memo when there is no payee,
+// and the memo its note.
+func (t Transaction) Expense() expn.Expense {
+	title := t.Payee
+	if title == "" {
+		title = t.Memo
+	}
+	var tags []string
+	if t.Category != "" {
+		tags = []string{t.Category}
+	}
+	return expn.Expense{
+		Title:   title,
+		Amount:  expn.Money{Minor: -t.Amount.Minor, Currency: t.Amount.Currency},
+		Note:    t.Memo,
+		Tags:    tags,
+		SpentAt: t.Posted,
+	}
+}
+
+// Lines returns the debits of txns to import as expenses and how many credits, such as deposits and refunds, were left out.
+func Lines(txns []Transaction) ([]expn.StatementLine, int) {
+	var out []expn.StatementLine
+	credits := 0
+	for _, t := range txns {
+		if t.Amount.Minor >= 0 {
+			credits++
+			continue
+		}
+		out = append(out, expn.StatementLine{Account: t.Account, FITID: t.FITID, Expense: t.Expense()})
+	}
+	return out, credits
+}
+
+// thaiOffset maps the Thai letters of TIS-620 and windows-874, 0xA1 to 0xFB, onto U+0E01 to U+0E5B.
+const thaiOffset = 0x0E01 - 0xA1
+
+// toUTF8 decodes a statement which is not UTF-8 from the charset it names, windows-874 unless it is latin.
+// Thai banks still write their downloads in windows-874.
+func toUTF8(data []byte, charset string) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	charset = strings.ToUpper(charset)
+	latin := strings.Contains(charset, "1252") || strings.Contains(charset, "8859-1") || strings.Contains(charset, "LATIN")
+	var b strings.Builder
+	b.Grow(len(data) * 2)
+	for _, c := range data {
+		switch {
+		case c < 0x80:
+			b.WriteByte(c)
+		case latin:
+			b.WriteRune(rune(c))
+		case c >= 0xA1 && c <= 0xFB:
+			b.WriteRune(rune(c) + thaiOffset)
+		case c == 0xA0:
+			b.WriteRune(' ')
+		default:
+			b.WriteRune(utf8.RuneError)
+		}
+	}
+	return b.String()
+}